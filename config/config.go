@@ -0,0 +1,248 @@
+// Package config loads the settings of the API. Every setting has a
+// default, which an optional YAML file, the environment and command line
+// flags override in that order.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds every setting of the API.
+type Config struct {
+	Server   Server   `yaml:"server"`
+	Log      Log      `yaml:"log"`
+	Database Database `yaml:"database"`
+	Auth     Auth     `yaml:"auth"`
+	SSH      SSH      `yaml:"ssh"`
+	Storage  Storage  `yaml:"storage"`
+	Images   Images   `yaml:"images"`
+	Uploads  Uploads  `yaml:"uploads"`
+	GC       GC       `yaml:"gc"`
+	URLs     URLs     `yaml:"urls"`
+	Tracing  Tracing  `yaml:"tracing"`
+}
+
+type Server struct {
+	Addr            string        `yaml:"addr" env:"HTTP_ADDR" usage:"address the HTTP server listens on"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" usage:"how long in-flight requests may finish after SIGTERM or SIGINT"`
+	ShutdownDelay   time.Duration `yaml:"shutdown_delay" env:"SHUTDOWN_DELAY" usage:"keep serving this long after a signal while /readyz fails, so load balancers stop routing first"`
+}
+
+type Log struct {
+	Level  string `yaml:"level" env:"LOG_LEVEL" usage:"lowest level logged: debug, info, warn or error"`
+	Format string `yaml:"format" env:"LOG_FORMAT" usage:"log output: json or text"`
+}
+
+type Database struct {
+	Host     string `yaml:"host" env:"DB_HOST" usage:"Postgres host"`
+	Port     int    `yaml:"port" env:"DB_PORT" usage:"Postgres port"`
+	User     string `yaml:"user" env:"DB_USER" usage:"Postgres user"`
+	Password string `yaml:"password" env:"DB_PASSWORD" secret:"true" usage:"Postgres password"`
+	Name     string `yaml:"name" env:"DB_DATABASE" usage:"Postgres database"`
+	SSLMode  string `yaml:"sslmode" env:"DB_SSLMODE" usage:"Postgres sslmode"`
+	// QueryTimeout bounds each model function rather than each statement,
+	// so a function running several statements shares one deadline
+	QueryTimeout time.Duration `yaml:"query_timeout" env:"DB_QUERY_TIMEOUT" usage:"cancel database work of one model call after this long, 0 disables"`
+}
+
+// DSN returns the Postgres connection URL.
+func (d Database) DSN() string {
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(d.User, d.Password),
+		Host:     d.Host + ":" + strconv.Itoa(d.Port),
+		Path:     "/" + d.Name,
+		RawQuery: url.Values{"sslmode": {d.SSLMode}}.Encode(),
+	}
+	return u.String()
+}
+
+type Auth struct {
+	JWTSecret string `yaml:"jwt_secret" env:"JWT_SECRET" secret:"true" usage:"key signing the login tokens"`
+}
+
+type SSH struct {
+	Enabled        bool   `yaml:"enabled" env:"SSH_TUNNEL" usage:"reach the database through an SSH server"`
+	Server         string `yaml:"server" env:"HOST_SERVER" usage:"SSH server as host or host:port"`
+	User           string `yaml:"user" env:"HOST_USER" usage:"SSH user"`
+	Password       string `yaml:"password" env:"HOST_PASSWORD" secret:"true" usage:"SSH password"`
+	KeyFile        string `yaml:"key_file" env:"HOST_KEY_FILE" usage:"SSH private key file"`
+	KeyPassphrase  string `yaml:"key_passphrase" env:"HOST_KEY_PASSPHRASE" secret:"true" usage:"passphrase of the SSH private key"`
+	KnownHostsFile string `yaml:"known_hosts" env:"HOST_KNOWN_HOSTS" usage:"known_hosts file verifying the SSH server, default ~/.ssh/known_hosts"`
+}
+
+type Storage struct {
+	Driver    string `yaml:"driver" env:"STORAGE_DRIVER" usage:"where uploads are kept: local or s3"`
+	LocalRoot string `yaml:"local_root" env:"STORAGE_LOCAL_ROOT" usage:"upload directory of the local driver"`
+	S3        S3     `yaml:"s3"`
+}
+
+type S3 struct {
+	Endpoint  string `yaml:"endpoint" env:"S3_ENDPOINT" usage:"S3 endpoint as host:port"`
+	AccessKey string `yaml:"access_key" env:"S3_ACCESS_KEY" usage:"S3 access key"`
+	SecretKey string `yaml:"secret_key" env:"S3_SECRET_KEY" secret:"true" usage:"S3 secret key"`
+	Bucket    string `yaml:"bucket" env:"S3_BUCKET" usage:"S3 bucket"`
+	Region    string `yaml:"region" env:"S3_REGION" usage:"S3 region"`
+	UseSSL    bool   `yaml:"use_ssl" env:"S3_USE_SSL" usage:"connect to S3 over TLS"`
+	PublicURL string `yaml:"public_url" env:"S3_PUBLIC_URL" usage:"public URL of the bucket"`
+}
+
+type Images struct {
+	SigningKey string `yaml:"signing_key" env:"IMAGE_SIGNING_KEY" secret:"true" usage:"key signing /img transformations, empty disables them"`
+	CacheDir   string `yaml:"cache_dir" env:"IMAGE_CACHE_DIR" usage:"directory caching transformed images"`
+	// The cache is pruned down to these limits in the background
+	CacheMaxBytes int64         `yaml:"cache_max_bytes" env:"IMAGE_CACHE_MAX_BYTES" usage:"size the image cache is pruned down to, 0 disables"`
+	CacheMaxAge   time.Duration `yaml:"cache_max_age" env:"IMAGE_CACHE_MAX_AGE" usage:"remove cached images not served for this long, 0 disables"`
+}
+
+type Uploads struct {
+	TusMaxSize int64         `yaml:"tus_max_size" env:"TUS_MAX_SIZE" usage:"largest resumable upload in bytes"`
+	TusExpiry  time.Duration `yaml:"tus_expiry" env:"TUS_EXPIRY" usage:"how long a resumable upload can be resumed and used"`
+
+	Portfolio  Limits `yaml:"portfolio" env:"UPLOAD_PORTFOLIO_"`
+	Experience Limits `yaml:"experience" env:"UPLOAD_EXPERIENCE_"`
+	Skills     Limits `yaml:"skills" env:"UPLOAD_SKILLS_"`
+	Users      Limits `yaml:"users" env:"UPLOAD_USERS_"`
+	// Documents and videos attached to a portfolio gallery
+	Attachments Limits `yaml:"attachments" env:"UPLOAD_ATTACHMENTS_"`
+}
+
+// Limits bounds the uploads of one kind. Zero leaves a bound off.
+type Limits struct {
+	MaxBytes  int64 `yaml:"max_bytes" env:"MAX_BYTES" usage:"largest upload in bytes, 0 for no limit"`
+	MaxWidth  int   `yaml:"max_width" env:"MAX_WIDTH" usage:"widest image in pixels, 0 for no limit"`
+	MaxHeight int   `yaml:"max_height" env:"MAX_HEIGHT" usage:"tallest image in pixels, 0 for no limit"`
+}
+
+// Limits returns the limits of each kind of upload by its name.
+func (u Uploads) Limits() map[string]Limits {
+	return map[string]Limits{
+		"portfolio":   u.Portfolio,
+		"experience":  u.Experience,
+		"skills":      u.Skills,
+		"users":       u.Users,
+		"attachments": u.Attachments,
+	}
+}
+
+type GC struct {
+	Interval time.Duration `yaml:"interval" env:"GC_INTERVAL" usage:"collect orphaned uploads this often, 0 disables"`
+	Grace    time.Duration `yaml:"grace" env:"GC_GRACE" usage:"leave uploads younger than this alone"`
+	DryRun   bool          `yaml:"dry_run" env:"GC_DRY_RUN" usage:"only report orphaned uploads"`
+}
+
+type URLs struct {
+	PublicBaseURL  string   `yaml:"public_base_url" env:"PUBLIC_BASE_URL" usage:"public URL of the API"`
+	AssetBaseURL   string   `yaml:"asset_base_url" env:"ASSET_BASE_URL" usage:"public URL of uploads and images, such as a CDN"`
+	TrustedProxies []string `yaml:"trusted_proxies" env:"TRUSTED_PROXIES" usage:"comma separated proxies whose X-Forwarded headers are honored"`
+}
+
+type Tracing struct {
+	Exporter    string  `yaml:"exporter" env:"TRACING_EXPORTER" usage:"where spans are sent: none, otlp or stdout"`
+	Endpoint    string  `yaml:"endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT" usage:"base URL of the OTLP/HTTP collector, such as http://localhost:4318"`
+	SampleRatio float64 `yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO" usage:"share of new traces recorded, from 0 to 1"`
+	ServiceName string  `yaml:"service_name" env:"OTEL_SERVICE_NAME" usage:"service.name reported with the spans"`
+}
+
+// Default returns the settings used when nothing overrides them.
+func Default() *Config {
+	return &Config{
+		Server:   Server{Addr: ":8080", ShutdownTimeout: 30 * time.Second},
+		Log:      Log{Level: "info", Format: "json"},
+		Database: Database{Port: 5432, SSLMode: "disable", QueryTimeout: 10 * time.Second},
+		Storage:  Storage{Driver: "local", LocalRoot: "./uploads"},
+		Images:   Images{CacheDir: "./cache/images", CacheMaxBytes: 1 << 30, CacheMaxAge: 30 * 24 * time.Hour},
+		Uploads: Uploads{
+			TusMaxSize:  512 << 20,
+			TusExpiry:   24 * time.Hour,
+			Portfolio:   Limits{MaxBytes: 10 << 20, MaxWidth: 8192, MaxHeight: 8192},
+			Experience:  Limits{MaxBytes: 5 << 20, MaxWidth: 4096, MaxHeight: 4096},
+			Skills:      Limits{MaxBytes: 2 << 20, MaxWidth: 2048, MaxHeight: 2048},
+			Users:       Limits{MaxBytes: 5 << 20, MaxWidth: 4096, MaxHeight: 4096},
+			Attachments: Limits{MaxBytes: 100 << 20},
+		},
+		GC:      GC{Grace: 24 * time.Hour},
+		Tracing: Tracing{Exporter: "none", SampleRatio: 1, ServiceName: "portfolio-api"},
+	}
+}
+
+var logLevels = map[string]bool{"debug": true, "info": true, "warn": true, "error": true}
+
+var tracingExporters = map[string]bool{"none": true, "otlp": true, "stdout": true}
+
+// uploadKinds orders the upload limits for validation
+var uploadKinds = []string{"portfolio", "experience", "skills", "users", "attachments"}
+
+var sslModes = map[string]bool{
+	"disable": true, "allow": true, "prefer": true, "require": true, "verify-ca": true, "verify-full": true,
+}
+
+// Validate reports every setting that is missing or out of range.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Addr != "", "server.addr (HTTP_ADDR) is required")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout (SHUTDOWN_TIMEOUT) must be positive")
+	check(c.Server.ShutdownDelay >= 0, "server.shutdown_delay (SHUTDOWN_DELAY) must not be negative")
+
+	check(logLevels[c.Log.Level], "log.level (LOG_LEVEL) must be debug, info, warn or error, got %q", c.Log.Level)
+	check(c.Log.Format == "json" || c.Log.Format == "text", "log.format (LOG_FORMAT) must be json or text, got %q", c.Log.Format)
+
+	check(c.Database.Host != "", "database.host (DB_HOST) is required")
+	check(c.Database.Port > 0 && c.Database.Port < 65536, "database.port (DB_PORT) must be between 1 and 65535")
+	check(c.Database.User != "", "database.user (DB_USER) is required")
+	check(c.Database.Password != "", "database.password (DB_PASSWORD) is required")
+	check(c.Database.Name != "", "database.name (DB_DATABASE) is required")
+	check(sslModes[c.Database.SSLMode], "database.sslmode (DB_SSLMODE) %q is not a Postgres sslmode", c.Database.SSLMode)
+	check(c.Database.QueryTimeout >= 0, "database.query_timeout (DB_QUERY_TIMEOUT) must not be negative")
+
+	check(c.Auth.JWTSecret != "", "auth.jwt_secret (JWT_SECRET) is required")
+
+	if c.SSH.Enabled {
+		check(c.SSH.Server != "", "ssh.server (HOST_SERVER) is required when the SSH tunnel is enabled")
+		check(c.SSH.User != "", "ssh.user (HOST_USER) is required when the SSH tunnel is enabled")
+		check(c.SSH.Password != "" || c.SSH.KeyFile != "", "ssh.key_file (HOST_KEY_FILE) or ssh.password (HOST_PASSWORD) is required when the SSH tunnel is enabled")
+	}
+
+	switch c.Storage.Driver {
+	case "local":
+		check(c.Storage.LocalRoot != "", "storage.local_root (STORAGE_LOCAL_ROOT) is required for the local driver")
+	case "s3":
+		check(c.Storage.S3.Endpoint != "", "storage.s3.endpoint (S3_ENDPOINT) is required for the s3 driver")
+		check(c.Storage.S3.Bucket != "", "storage.s3.bucket (S3_BUCKET) is required for the s3 driver")
+	default:
+		check(false, "storage.driver (STORAGE_DRIVER) must be local or s3, got %q", c.Storage.Driver)
+	}
+
+	check(c.Images.SigningKey == "" || c.Images.CacheDir != "", "images.cache_dir (IMAGE_CACHE_DIR) is required when image transformations are enabled")
+	check(c.Images.CacheMaxBytes >= 0, "images.cache_max_bytes (IMAGE_CACHE_MAX_BYTES) must not be negative")
+	check(c.Images.CacheMaxAge >= 0, "images.cache_max_age (IMAGE_CACHE_MAX_AGE) must not be negative")
+
+	check(c.Uploads.TusMaxSize > 0, "uploads.tus_max_size (TUS_MAX_SIZE) must be positive")
+	check(c.Uploads.TusExpiry > 0, "uploads.tus_expiry (TUS_EXPIRY) must be positive")
+	for _, kind := range uploadKinds {
+		l, env := c.Uploads.Limits()[kind], "UPLOAD_"+strings.ToUpper(kind)+"_"
+		check(l.MaxBytes >= 0, "uploads.%s.max_bytes (%sMAX_BYTES) must not be negative", kind, env)
+		check(l.MaxWidth >= 0, "uploads.%s.max_width (%sMAX_WIDTH) must not be negative", kind, env)
+		check(l.MaxHeight >= 0, "uploads.%s.max_height (%sMAX_HEIGHT) must not be negative", kind, env)
+	}
+
+	check(c.GC.Interval >= 0, "gc.interval (GC_INTERVAL) must not be negative")
+	check(c.GC.Grace > 0, "gc.grace (GC_GRACE) must be positive")
+
+	check(tracingExporters[c.Tracing.Exporter], "tracing.exporter (TRACING_EXPORTER) must be none, otlp or stdout, got %q", c.Tracing.Exporter)
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio (TRACING_SAMPLE_RATIO) must be between 0 and 1")
+	check(c.Tracing.ServiceName != "", "tracing.service_name (OTEL_SERVICE_NAME) is required")
+
+	return errors.Join(errs...)
+}
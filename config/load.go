@@ -0,0 +1,231 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Load builds the configuration from args, the command line without the
+// program name. The defaults are overridden by the YAML file named by
+// --config or CONFIG_FILE, then by the environment, then by the flags,
+// which are named after the YAML keys such as --database.host. It returns
+// the arguments after the flags, such as a subcommand, and whether
+// --print-config was given.
+func Load(args []string) (cfg *Config, rest []string, printConfig bool, err error) {
+	cfg = Default()
+
+	fs := flag.NewFlagSet("portfolio", flag.ContinueOnError)
+	file := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML configuration file")
+	fs.BoolVar(&printConfig, "print-config", false, "print the configuration with secrets redacted and exit")
+	// Flags write into their own copy so they can be applied last
+	flags := Default()
+	set := map[string]bool{}
+	walk(reflect.ValueOf(flags).Elem(), func(f field) {
+		fs.Var(&flagValue{v: f.value}, f.name(), f.usage())
+	})
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, false, err
+	}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	if *file != "" {
+		if err := loadFile(cfg, *file); err != nil {
+			return nil, nil, false, err
+		}
+	}
+
+	var errs []error
+	walk(reflect.ValueOf(cfg).Elem(), func(f field) {
+		if f.env == "" {
+			return
+		}
+		// Empty variables, as in a copied .env.example, keep the previous layer
+		s := os.Getenv(f.env)
+		if s == "" {
+			return
+		}
+		if err := parseInto(f.value, s); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.env, err))
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, false, err
+	}
+
+	src := reflect.ValueOf(flags).Elem()
+	walk(reflect.ValueOf(cfg).Elem(), func(f field) {
+		if set[f.name()] {
+			f.value.Set(src.FieldByIndex(f.index))
+		}
+	})
+
+	return cfg, fs.Args(), printConfig, nil
+}
+
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Print writes the configuration as YAML with every secret that is set
+// replaced, so the output can be shared.
+func (c *Config) Print(w io.Writer) error {
+	redacted := *c
+	redacted.URLs.TrustedProxies = append([]string(nil), c.URLs.TrustedProxies...)
+	walk(reflect.ValueOf(&redacted).Elem(), func(f field) {
+		if f.secret && f.value.String() != "" {
+			f.value.SetString("REDACTED")
+		}
+	})
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&redacted); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// field is a setting found by walk
+type field struct {
+	path   []string
+	index  []int
+	value  reflect.Value
+	env    string
+	secret bool
+	tag    reflect.StructTag
+}
+
+func (f field) name() string { return strings.Join(f.path, ".") }
+
+func (f field) usage() string {
+	usage := f.tag.Get("usage")
+	if f.env != "" {
+		usage += " (env " + f.env + ")"
+	}
+	return usage
+}
+
+// walk calls fn for every setting of the struct v, descending into the
+// sections. The env tag of a section prefixes the variables of its
+// settings, so one type can describe several sections.
+func walk(v reflect.Value, fn func(field)) {
+	walkPath(v, nil, nil, "", fn)
+}
+
+func walkPath(v reflect.Value, path []string, index []int, envPrefix string, fn func(field)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key := strings.Split(sf.Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		p := append(append([]string(nil), path...), key)
+		idx := append(append([]int(nil), index...), i)
+		if sf.Type.Kind() == reflect.Struct {
+			walkPath(v.Field(i), p, idx, envPrefix+sf.Tag.Get("env"), fn)
+			continue
+		}
+		env := sf.Tag.Get("env")
+		if env != "" {
+			env = envPrefix + env
+		}
+		fn(field{
+			path:   p,
+			index:  idx,
+			value:  v.Field(i),
+			env:    env,
+			secret: sf.Tag.Get("secret") == "true",
+			tag:    sf.Tag,
+		})
+	}
+}
+
+// parseInto sets v from its textual form
+func parseInto(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+// flagValue exposes a setting as a flag.Value
+type flagValue struct {
+	v reflect.Value
+}
+
+func (f *flagValue) String() string {
+	if !f.v.IsValid() {
+		return ""
+	}
+	if f.v.Type() == durationType {
+		return time.Duration(f.v.Int()).String()
+	}
+	if f.v.Kind() == reflect.Slice {
+		return strings.Join(f.v.Interface().([]string), ",")
+	}
+	return fmt.Sprint(f.v.Interface())
+}
+
+func (f *flagValue) Set(s string) error { return parseInto(f.v, s) }
+
+func (f *flagValue) IsBoolFlag() bool { return f.v.IsValid() && f.v.Kind() == reflect.Bool }
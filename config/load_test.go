@@ -0,0 +1,311 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearEnv empties every variable Load reads, so the environment the tests
+// run in does not leak into them
+func clearEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("CONFIG_FILE", "")
+	walk(reflect.ValueOf(Default()).Elem(), func(f field) {
+		if f.env != "" {
+			t.Setenv(f.env, "")
+		}
+	})
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoad(t *testing.T) {
+	file := writeFile(t, `
+server:
+  addr: ":9000"
+database:
+  host: db.internal
+  port: 6543
+uploads:
+  skills:
+    max_bytes: 1024
+urls:
+  trusted_proxies: [10.0.0.0/8]
+`)
+
+	tests := []struct {
+		name  string
+		args  []string
+		env   map[string]string
+		check func(t *testing.T, cfg *Config)
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, cfg *Config) {
+				if !reflect.DeepEqual(cfg, Default()) {
+					t.Fatalf("Load() = %+v, want the defaults", cfg)
+				}
+			},
+		},
+		{
+			name: "file over defaults",
+			args: []string{"--config", file},
+			check: func(t *testing.T, cfg *Config) {
+				want := Default()
+				want.Server.Addr = ":9000"
+				want.Database.Host = "db.internal"
+				want.Database.Port = 6543
+				want.Uploads.Skills.MaxBytes = 1024
+				want.URLs.TrustedProxies = []string{"10.0.0.0/8"}
+				if !reflect.DeepEqual(cfg, want) {
+					t.Fatalf("Load() = %+v, want %+v", cfg, want)
+				}
+			},
+		},
+		{
+			name: "file from CONFIG_FILE",
+			env:  map[string]string{"CONFIG_FILE": file},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Database.Host != "db.internal" {
+					t.Fatalf("database.host = %q, want it from the file", cfg.Database.Host)
+				}
+			},
+		},
+		{
+			name: "env over file",
+			args: []string{"--config", file},
+			env: map[string]string{
+				"DB_HOST":                      "env.internal",
+				"DB_QUERY_TIMEOUT":             "3s",
+				"SSH_TUNNEL":                   "true",
+				"TRUSTED_PROXIES":              "10.0.0.1, ,192.168.0.0/16",
+				"TRACING_SAMPLE_RATIO":         "0.25",
+				"UPLOAD_SKILLS_MAX_WIDTH":      "512",
+				"UPLOAD_ATTACHMENTS_MAX_BYTES": "0",
+			},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Database.Host != "env.internal" || cfg.Database.Port != 6543 {
+					t.Fatalf("database = %s:%d, want env.internal:6543", cfg.Database.Host, cfg.Database.Port)
+				}
+				if cfg.Database.QueryTimeout != 3*time.Second || !cfg.SSH.Enabled || cfg.Tracing.SampleRatio != 0.25 {
+					t.Fatalf("typed settings from env not applied: %+v %+v %+v", cfg.Database, cfg.SSH, cfg.Tracing)
+				}
+				if want := []string{"10.0.0.1", "192.168.0.0/16"}; !reflect.DeepEqual(cfg.URLs.TrustedProxies, want) {
+					t.Fatalf("trusted proxies = %q, want %q", cfg.URLs.TrustedProxies, want)
+				}
+				if want := (Limits{MaxBytes: 1024, MaxWidth: 512, MaxHeight: 2048}); cfg.Uploads.Skills != want {
+					t.Fatalf("skills limits = %+v, want %+v", cfg.Uploads.Skills, want)
+				}
+				if cfg.Uploads.Attachments.MaxBytes != 0 {
+					t.Fatalf("attachments max bytes = %d, want 0", cfg.Uploads.Attachments.MaxBytes)
+				}
+			},
+		},
+		{
+			name: "flags over env",
+			args: []string{"--config", file, "--database.host=flag.internal", "--uploads.skills.max_bytes", "2048", "--ssh.enabled"},
+			env:  map[string]string{"DB_HOST": "env.internal", "UPLOAD_SKILLS_MAX_BYTES": "4096"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Database.Host != "flag.internal" || cfg.Uploads.Skills.MaxBytes != 2048 || !cfg.SSH.Enabled {
+					t.Fatalf("flags not applied last: %+v %+v", cfg.Database, cfg.Uploads.Skills)
+				}
+				// A flag left out does not reset what the file set
+				if cfg.Server.Addr != ":9000" {
+					t.Fatalf("server.addr = %q, want it from the file", cfg.Server.Addr)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			cfg, _, _, err := Load(tt.args)
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestLoadRest(t *testing.T) {
+	clearEnv(t)
+	_, rest, printConfig, err := Load([]string{"--print-config", "--log.level=debug", "migrate", "up", "--steps=1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !printConfig {
+		t.Fatal("printConfig = false, want true")
+	}
+	if want := []string{"migrate", "up", "--steps=1"}; !reflect.DeepEqual(rest, want) {
+		t.Fatalf("rest = %q, want %q", rest, want)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{name: "missing file", args: []string{"--config", filepath.Join(t.TempDir(), "missing.yaml")}, want: "read config file"},
+		{name: "unknown key in file", args: []string{"--config", writeFile(t, "server:\n  adr: \":80\"\n")}, want: "field adr not found"},
+		{name: "wrong type in file", args: []string{"--config", writeFile(t, "database:\n  port: many\n")}, want: "parse config file"},
+		{name: "bad integer", env: map[string]string{"DB_PORT": "many"}, want: `DB_PORT: invalid integer "many"`},
+		{name: "bad duration", env: map[string]string{"GC_GRACE": "1 day"}, want: `GC_GRACE: invalid duration "1 day"`},
+		{name: "bad boolean", env: map[string]string{"S3_USE_SSL": "maybe"}, want: `S3_USE_SSL: invalid boolean "maybe"`},
+		{name: "bad limit", env: map[string]string{"UPLOAD_USERS_MAX_HEIGHT": "tall"}, want: `UPLOAD_USERS_MAX_HEIGHT: invalid integer "tall"`},
+		{name: "bad flag", args: []string{"--database.port=many"}, want: "invalid integer"},
+		{name: "unknown flag", args: []string{"--nope"}, want: "flag provided but not defined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			_, _, _, err := Load(tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Load() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrint(t *testing.T) {
+	cfg := Default()
+	cfg.Database.Password = "db-password"
+	cfg.Auth.JWTSecret = "jwt-secret"
+	cfg.SSH.Password = "ssh-password"
+	cfg.Storage.S3.SecretKey = "s3-secret"
+	cfg.Storage.S3.AccessKey = "s3-access"
+	cfg.URLs.TrustedProxies = []string{"10.0.0.0/8"}
+
+	var buf bytes.Buffer
+	if err := cfg.Print(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, secret := range []string{"db-password", "jwt-secret", "ssh-password", "s3-secret"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("Print() shows the secret %q", secret)
+		}
+	}
+	for _, want := range []string{
+		"password: REDACTED",
+		"jwt_secret: REDACTED",
+		"secret_key: REDACTED",
+		"access_key: s3-access",
+		// Secrets that are not set stay empty rather than looking set
+		`key_passphrase: ""`,
+		`signing_key: ""`,
+		"max_bytes: 10485760",
+		"- 10.0.0.0/8",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Print() output lacks %q:\n%s", want, out)
+		}
+	}
+
+	// The configuration itself keeps its secrets
+	if cfg.Database.Password != "db-password" || cfg.Auth.JWTSecret != "jwt-secret" {
+		t.Fatal("Print() redacted the configuration it was called on")
+	}
+
+	// And the output loads back into the same settings, secrets aside
+	clearEnv(t)
+	loaded, _, _, err := Load([]string{"--config", writeFile(t, out)})
+	if err != nil {
+		t.Fatalf("loading the printed configuration: %v", err)
+	}
+	if loaded.Uploads != cfg.Uploads || loaded.Server != cfg.Server || !reflect.DeepEqual(loaded.URLs, cfg.URLs) {
+		t.Fatalf("printed configuration loads as %+v, want %+v", loaded, cfg)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() *Config {
+		cfg := Default()
+		cfg.Database.Host = "localhost"
+		cfg.Database.User = "portfolio"
+		cfg.Database.Password = "secret"
+		cfg.Database.Name = "portfolio"
+		cfg.Auth.JWTSecret = "secret"
+		return cfg
+	}
+
+	tests := []struct {
+		name   string
+		change func(cfg *Config)
+		want   []string
+	}{
+		{name: "valid", change: func(cfg *Config) {}},
+		{name: "no limits", change: func(cfg *Config) { cfg.Uploads.Attachments = Limits{} }},
+		{
+			name:   "required settings",
+			change: func(cfg *Config) { cfg.Database.Host = ""; cfg.Auth.JWTSecret = "" },
+			want:   []string{"database.host (DB_HOST) is required", "auth.jwt_secret (JWT_SECRET) is required"},
+		},
+		{
+			name:   "negative limits",
+			change: func(cfg *Config) { cfg.Uploads.Portfolio.MaxBytes = -1; cfg.Uploads.Users.MaxHeight = -1 },
+			want: []string{
+				"uploads.portfolio.max_bytes (UPLOAD_PORTFOLIO_MAX_BYTES) must not be negative",
+				"uploads.users.max_height (UPLOAD_USERS_MAX_HEIGHT) must not be negative",
+			},
+		},
+		{
+			name:   "no gc grace",
+			change: func(cfg *Config) { cfg.GC.Grace = 0 },
+			want:   []string{"gc.grace (GC_GRACE) must be positive"},
+		},
+		{
+			name:   "ssh without credentials",
+			change: func(cfg *Config) { cfg.SSH = SSH{Enabled: true, Server: "bastion", User: "deploy"} },
+			want:   []string{"ssh.key_file (HOST_KEY_FILE) or ssh.password (HOST_PASSWORD) is required"},
+		},
+		{
+			name:   "unknown choices",
+			change: func(cfg *Config) { cfg.Storage.Driver = "ftp"; cfg.Log.Format = "xml" },
+			want:   []string{`storage.driver (STORAGE_DRIVER) must be local or s3, got "ftp"`, `log.format (LOG_FORMAT) must be json or text, got "xml"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.change(cfg)
+			err := cfg.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Validate() succeeded")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Validate() error lacks %q:\n%v", want, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+// Package gc removes uploads that no database row references anymore:
+// files left behind by failed requests and blobs whose last reference
+// was dropped without being collected.
+package gc
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"portfolio/media"
+	"portfolio/model"
+	"portfolio/repository"
+	"portfolio/storage"
+	"strings"
+	"time"
+)
+
+// Options tunes a collection run.
+type Options struct {
+	// Grace protects anything younger than this, so uploads of requests
+	// that are still in flight are never mistaken for orphans. It must be
+	// positive: references are read before the walk, so a blob recorded
+	// and stored in between would otherwise be deleted.
+	Grace time.Duration
+	// DryRun only reports what would be deleted.
+	DryRun bool
+}
+
+// Report summarises a collection run.
+type Report struct {
+	Scanned int
+	Orphans []storage.Object
+	Bytes   int64
+	Blobs   []string
+	// Uploads counts the expired resumable uploads that were forgotten
+	Uploads int64
+}
+
+// Run collects orphaned uploads in store according to opts.
+func Run(ctx context.Context, db repository.DB, store storage.Storage, opts Options) (*Report, error) {
+	if opts.Grace <= 0 {
+		return nil, errors.New("gc: grace must be positive")
+	}
+	cutoff := time.Now().Add(-opts.Grace)
+	report := &Report{}
+
+	// Read the references before walking: anything stored after this point
+	// is younger than the cutoff and left alone
+	keys, err := db.Blobs().Referenced(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parts of resumable uploads are kept until the upload expires
+	if !opts.DryRun {
+		if report.Uploads, err = db.Uploads().DeleteExpired(ctx); err != nil {
+			return nil, err
+		}
+	}
+	uploads, err := db.Uploads().Active(ctx)
+	if err != nil {
+		return nil, err
+	}
+	active := make(map[string]bool, len(uploads))
+	for _, id := range uploads {
+		active[id] = true
+	}
+	referenced := make(map[string]bool, len(keys)*(1+2*len(media.Variants)))
+	for _, key := range keys {
+		referenced[key] = true
+		for _, variant := range media.VariantKeys(key) {
+			referenced[variant] = true
+		}
+	}
+
+	err = store.Walk(ctx, "", func(obj storage.Object) error {
+		report.Scanned++
+		if referenced[obj.Key] || active[uploadID(obj.Key)] || obj.ModTime.After(cutoff) {
+			return nil
+		}
+
+		report.Orphans = append(report.Orphans, obj)
+		report.Bytes += obj.Size
+		if opts.DryRun {
+			return nil
+		}
+		if err := store.Delete(ctx, obj.Key); err != nil {
+			slog.ErrorContext(ctx, "gc: error deleting orphan", "key", obj.Key, "error", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	// Blob rows without references are recorded and so skipped by the walk
+	blobs, err := db.Blobs().Unreferenced(ctx, cutoff)
+	if err != nil {
+		return report, err
+	}
+	for _, key := range blobs {
+		report.Blobs = append(report.Blobs, key)
+		if opts.DryRun {
+			continue
+		}
+		err := db.Blobs().Collect(ctx, key, func() error {
+			for _, k := range append([]string{key}, media.VariantKeys(key)...) {
+				if err := store.Delete(ctx, k); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "gc: error collecting blob", "key", key, "error", err)
+		}
+	}
+
+	return report, nil
+}
+
+// uploadID returns the resumable upload a part belongs to, if key is one
+func uploadID(key string) string {
+	rest, ok := strings.CutPrefix(key, model.UploadPrefix)
+	if !ok {
+		return ""
+	}
+	id, _, _ := strings.Cut(rest, "/")
+	return id
+}
+
+// Log writes a one line summary of report.
+func (r *Report) Log(dryRun bool) {
+	slog.Info("gc: finished",
+		"dry_run", dryRun,
+		"scanned", r.Scanned,
+		"orphans", len(r.Orphans),
+		"bytes", r.Bytes,
+		"blobs", len(r.Blobs),
+		"expired_uploads", r.Uploads,
+	)
+}
+
+// Schedule runs a collection every interval until ctx is cancelled.
+func Schedule(ctx context.Context, db repository.DB, store storage.Storage, interval time.Duration, opts Options) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := Run(ctx, db, store, opts)
+			if err != nil {
+				slog.ErrorContext(ctx, "gc: run failed", "error", err)
+				continue
+			}
+			report.Log(opts.DryRun)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package gc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"portfolio/media"
+	"portfolio/model"
+	"portfolio/repository"
+	"portfolio/storage"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fixture stores, two hours ago unless noted:
+//   - blobs/used.png, referenced, with a variant
+//   - blobs/unused.png, recorded just now but no longer referenced
+//   - portfolio/old.png and the parts of an expired upload, orphans
+//   - portfolio/new.png, an orphan stored just now
+//   - the part of an upload still in progress
+func fixture(t *testing.T) (repository.DB, *storage.Local) {
+	t.Helper()
+	ctx := context.Background()
+	db := repository.NewMemory()
+	store := storage.NewLocal(t.TempDir(), "/uploads")
+
+	put := func(key string, age time.Duration) {
+		if err := store.Put(ctx, key, strings.NewReader(key), int64(len(key)), ""); err != nil {
+			t.Fatal(err)
+		}
+		p := filepath.Join(store.Root(), filepath.FromSlash(key))
+		mtime := time.Now().Add(-age)
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, key := range []string{"blobs/used.png", "blobs/unused.png"} {
+		if _, err := db.Blobs().Ensure(ctx, key, 1); err != nil {
+			t.Fatal(err)
+		}
+		put(key, 2*time.Hour)
+	}
+	if err := db.Blobs().Retain(ctx, "blobs/used.png"); err != nil {
+		t.Fatal(err)
+	}
+	put(media.VariantKeys("blobs/used.png")[0], 2*time.Hour)
+	put(media.VariantKeys("blobs/unused.png")[0], 2*time.Hour)
+
+	put("portfolio/old.png", 2*time.Hour)
+	put("portfolio/new.png", 0)
+
+	for _, u := range []model.Upload{
+		{ID: "active", UserID: "u", Length: 10, ExpiresAt: time.Now().Add(time.Hour)},
+		{ID: "expired", UserID: "u", Length: 10, ExpiresAt: time.Now().Add(-time.Hour)},
+	} {
+		if err := db.Uploads().Insert(ctx, &u); err != nil {
+			t.Fatal(err)
+		}
+		put(u.PartKey(0, "a"), 2*time.Hour)
+	}
+	return db, store
+}
+
+func TestRun(t *testing.T) {
+	unusedVariant := media.VariantKeys("blobs/unused.png")[0]
+	expiredPart := (&model.Upload{ID: "expired"}).PartKey(0, "a")
+
+	tests := []struct {
+		name    string
+		opts    Options
+		orphans []string
+		blobs   []string
+		uploads int64
+		removed []string
+	}{
+		{
+			name:    "dry run",
+			opts:    Options{Grace: time.Hour, DryRun: true},
+			orphans: []string{"portfolio/old.png", expiredPart},
+		},
+		{
+			name:    "grace keeps recent files and blobs",
+			opts:    Options{Grace: time.Hour},
+			orphans: []string{"portfolio/old.png", expiredPart},
+			uploads: 1,
+			removed: []string{"portfolio/old.png", expiredPart},
+		},
+		{
+			name:    "short grace",
+			opts:    Options{Grace: time.Nanosecond},
+			orphans: []string{"portfolio/new.png", "portfolio/old.png", expiredPart},
+			blobs:   []string{"blobs/unused.png"},
+			uploads: 1,
+			removed: []string{"portfolio/new.png", "portfolio/old.png", expiredPart, "blobs/unused.png", unusedVariant},
+		},
+		{
+			name:    "dry run with short grace",
+			opts:    Options{Grace: time.Nanosecond, DryRun: true},
+			orphans: []string{"portfolio/new.png", "portfolio/old.png", expiredPart},
+			blobs:   []string{"blobs/unused.png"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, store := fixture(t)
+			var before []string
+			store.Walk(context.Background(), "", func(obj storage.Object) error {
+				before = append(before, obj.Key)
+				return nil
+			})
+
+			report, err := Run(context.Background(), db, store, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var orphans []string
+			for _, obj := range report.Orphans {
+				orphans = append(orphans, obj.Key)
+			}
+			if got, want := sorted(orphans), sorted(tt.orphans); !equal(got, want) {
+				t.Errorf("orphans = %v, want %v", got, want)
+			}
+			if got, want := sorted(report.Blobs), sorted(tt.blobs); !equal(got, want) {
+				t.Errorf("blobs = %v, want %v", got, want)
+			}
+			if report.Uploads != tt.uploads {
+				t.Errorf("expired uploads = %d, want %d", report.Uploads, tt.uploads)
+			}
+			if report.Scanned != len(before) {
+				t.Errorf("scanned %d objects, want %d", report.Scanned, len(before))
+			}
+
+			removed := make(map[string]bool)
+			for _, key := range tt.removed {
+				removed[key] = true
+			}
+			for _, key := range before {
+				_, err := store.Stat(context.Background(), key)
+				if gone := err == storage.ErrNotFound; gone != removed[key] {
+					t.Errorf("%s removed = %v, want %v", key, gone, removed[key])
+				}
+			}
+		})
+	}
+}
+
+func TestRunWithoutGrace(t *testing.T) {
+	db, store := fixture(t)
+	if _, err := Run(context.Background(), db, store, Options{}); err == nil {
+		t.Fatal("Run without grace succeeded, want an error")
+	}
+	if _, err := store.Stat(context.Background(), "portfolio/old.png"); err != nil {
+		t.Fatalf("portfolio/old.png: %v, want it kept", err)
+	}
+}
+
+func TestUploadID(t *testing.T) {
+	tests := map[string]string{
+		model.UploadPrefix + "abc/00000000000000000000": "abc",
+		model.UploadPrefix + "abc":                      "abc",
+		"blobs/abc.png":                                 "",
+		"portfolio/" + model.UploadPrefix + "abc/0":     "",
+	}
+	for key, want := range tests {
+		if got := uploadID(key); got != want {
+			t.Errorf("uploadID(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func sorted(keys []string) []string {
+	keys = append([]string(nil), keys...)
+	sort.Strings(keys)
+	return keys
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
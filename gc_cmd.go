@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"portfolio/config"
+	"portfolio/gc"
+	"portfolio/repository"
+	"portfolio/storage"
+	"time"
+)
+
+// runGC implements the "gc" subcommand and returns the exit code
+func runGC(db repository.DB, store storage.Storage, cfg config.GC, args []string) int {
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", cfg.DryRun, "only list orphaned uploads, do not delete them")
+	grace := flags.Duration("grace", cfg.Grace, "leave uploads younger than this alone")
+	flags.Parse(args)
+
+	opts := gc.Options{Grace: *grace, DryRun: *dryRun}
+	report, err := gc.Run(context.Background(), db, store, opts)
+	if err != nil {
+		slog.Error("Garbage collection failed", "error", err)
+		return 1
+	}
+
+	for _, obj := range report.Orphans {
+		fmt.Printf("%s\t%d\t%s\n", obj.Key, obj.Size, obj.ModTime.Format(time.RFC3339))
+	}
+	for _, key := range report.Blobs {
+		fmt.Printf("%s\tunreferenced blob\n", key)
+	}
+	report.Log(opts.DryRun)
+	return 0
+}
+
+// startGC runs the collector in the background every cfg.Interval, if set.
+// The returned channel is closed once the collector has stopped after ctx
+// is cancelled, so a run in progress is not cut off by closing the pool.
+func startGC(ctx context.Context, db repository.DB, store storage.Storage, cfg config.GC) <-chan struct{} {
+	done := make(chan struct{})
+	if cfg.Interval <= 0 {
+		close(done)
+		return done
+	}
+
+	opts := gc.Options{Grace: cfg.Grace, DryRun: cfg.DryRun}
+	slog.Info("gc: collecting orphaned uploads periodically", "interval", cfg.Interval)
+	go func() {
+		defer close(done)
+		gc.Schedule(ctx, db, store, cfg.Interval, opts)
+	}()
+	return done
+}
@@ -1,13 +1,16 @@
 package handler
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
-	"path/filepath"
+	"portfolio/logging"
+	"portfolio/metrics"
 	"portfolio/model"
+	"portfolio/repository"
+	"portfolio/storage"
 	"strings"
 	"time"
 
@@ -18,7 +21,7 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-func RegisterAuth(db *sql.DB) gin.HandlerFunc {
+func RegisterAuth(db repository.DB, store storage.Storage) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Bind(&model.User{})
 		user := model.User{
@@ -28,18 +31,23 @@ func RegisterAuth(db *sql.DB) gin.HandlerFunc {
 			Password: c.PostForm("password"),
 		}
 
+		if errs := model.Validate(&user); len(errs) > 0 {
+			validationErrorResponse(c, errs)
+			return
+		}
+
 		// Check if the email is already registered
-		if _, err := model.GetUserByEmail(db, user.Email); err == nil {
-			log.Printf("Email already registered: %s", user.Email)
-			c.JSON(http.StatusConflict, formatter.BadRequestResponse("Email already registered"))
+		if _, err := db.Users().GetByEmail(c.Request.Context(), user.Email); err == nil {
+			slog.WarnContext(c.Request.Context(), "Email already registered", "email", user.Email)
+			respondError(c, &model.ConflictError{Resource: "user", Message: "email already registered"}, "")
 			return
 		}
 
 		// Hash the password
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
 		if err != nil {
-			log.Printf("Error hashing password: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to hash password"))
+			slog.ErrorContext(c.Request.Context(), "Error hashing password", "error", err)
+			respondError(c, err, "Failed to hash password")
 			return
 		}
 		user.Password = string(hashedPassword)
@@ -47,82 +55,94 @@ func RegisterAuth(db *sql.DB) gin.HandlerFunc {
 		// Handle file upload
 		file, err := c.FormFile("image")
 		if err != nil {
-			log.Printf("Error retrieving file: %v", err)
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Failed to get uploaded file"))
+			slog.ErrorContext(c.Request.Context(), "Error retrieving file", "error", err)
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Failed to get uploaded file")
 			return
 		}
 
-		// Generate a new filename for the image
-		newFileName := uuid.New().String() + filepath.Ext(file.Filename)
-		if err := c.SaveUploadedFile(file, "uploads/users/"+newFileName); err != nil {
-			log.Printf("Error saving file: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to save uploaded file"))
+		// Name the image after its content
+		upload, ok := checkImage(c, "users", file)
+		if !ok {
 			return
 		}
-		user.Image = newFileName
+		user.Image = upload.Key
+		user.ImageWidth = upload.Width
 
-		// Insert user into the database
-		if err := model.InsertUser(db, user); err != nil {
-			log.Printf("Error inserting user into database: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to insert user into database"))
+		err = db.RunInTx(c.Request.Context(), func(tx repository.Tx) error {
+			if err := stageUpload(c.Request.Context(), db, tx, store, upload); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error saving file", "error", err)
+				return err
+			}
+
+			// Insert user into the database
+			if err := tx.Users().Insert(c.Request.Context(), user); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error inserting user into database", "error", err)
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			respondError(c, err, "Failed to insert user into database")
 			return
 		}
 
+		user.ImageSet = imageSet(c, store, "users", user.Image, user.ImageWidth)
 		c.JSON(http.StatusCreated, formatter.SuccessResponse(user))
 	}
 }
 
-func LoginAuth(db *sql.DB, jwtKey string) gin.HandlerFunc {
+func LoginAuth(db repository.DB, store storage.Storage, jwtKey string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		email := c.PostForm("email")
 		password := c.PostForm("password")
 
 		if email == "" || password == "" {
-			log.Println("Email and password are required")
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Email and password are required"))
+			slog.WarnContext(c.Request.Context(), "Email and password are required")
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Email and password are required")
 			return
 		}
 
-		user, err := model.GetUserByEmail(db, email)
+		user, err := db.Users().GetByEmail(c.Request.Context(), email)
+		if errors.Is(err, model.ErrNotFound) {
+			metrics.ObserveAuth("login", err)
+		}
 		if err != nil {
-			log.Printf("Error retrieving user by email: %v", err)
-			if err == sql.ErrNoRows {
-				c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Invalid email or password"))
+			slog.ErrorContext(c.Request.Context(), "Error retrieving user by email", "error", err)
+			if errors.Is(err, model.ErrNotFound) {
+				writeProblem(c, http.StatusUnauthorized, codeUnauthorized, "Invalid email or password")
 			} else {
-				c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Error retrieving user"))
+				respondError(c, err, "Error retrieving user")
 			}
 			return
 		}
 
 		err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+		metrics.ObserveAuth("login", err)
 		if err != nil {
-			log.Printf("Error comparing hash and password: %v", err)
-			c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Invalid email or password"))
+			slog.WarnContext(c.Request.Context(), "Password does not match", "user_id", user.ID)
+			writeProblem(c, http.StatusUnauthorized, codeUnauthorized, "Invalid email or password")
 			return
 		}
+		identify(c, user.ID)
 
 		// Generate JWT token using the helper function
 		tokenString, err := generateJWT(user.ID, jwtKey)
 		if err != nil {
-			log.Printf("Error generating JWT token: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to generate token"))
+			slog.ErrorContext(c.Request.Context(), "Error generating JWT token", "error", err)
+			respondError(c, err, "Failed to generate token")
 			return
 		}
 
 		// Update user token in the database
 		user.Token = &tokenString
-		if err := model.UpdateUser(db, *user); err != nil {
-			log.Printf("Error updating user token in database: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to update user token"))
+		if err := db.Users().Update(c.Request.Context(), *user); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error updating user token in database", "error", err)
+			respondError(c, err, "Failed to update user token")
 			return
 		}
 
 		// Include server URL in the image link
-		scheme := "http"
-		if c.Request.TLS != nil {
-			scheme = "https"
-		}
-		user.Image = scheme + "://" + c.Request.Host + "/uploads/users/" + user.Image
+		user.ImageSet = imageSet(c, store, "users", user.Image, user.ImageWidth)
 
 		//password omitempty
 		user.Password = ""
@@ -132,21 +152,21 @@ func LoginAuth(db *sql.DB, jwtKey string) gin.HandlerFunc {
 	}
 }
 
-func GetUserWithJWT(db *sql.DB, jwtKey string) gin.HandlerFunc {
+func GetUserWithJWT(db repository.DB, store storage.Storage, jwtKey string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Extract JWT token from Authorization header
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			log.Println("Authorization token not provided")
-			c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Authorization token not provided"))
+			slog.WarnContext(c.Request.Context(), "Authorization token not provided")
+			writeProblem(c, http.StatusUnauthorized, codeUnauthorized, "Authorization token not provided")
 			return
 		}
 
 		// Split the token type and the token value
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			log.Println("Invalid Authorization token format")
-			c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Invalid Authorization token format"))
+			slog.WarnContext(c.Request.Context(), "Invalid Authorization token format")
+			writeProblem(c, http.StatusUnauthorized, codeUnauthorized, "Invalid Authorization token format")
 			return
 		}
 
@@ -160,35 +180,29 @@ func GetUserWithJWT(db *sql.DB, jwtKey string) gin.HandlerFunc {
 		})
 
 		if err != nil {
-			log.Printf("Invalid token: %v", err)
-			c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Invalid token"))
+			slog.WarnContext(c.Request.Context(), "Invalid token", "error", err)
+			writeProblem(c, http.StatusUnauthorized, codeUnauthorized, "Invalid token")
 			return
 		}
 
 		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
 			userID := claims["userID"].(string)
-			user, err := model.GetUserID(db, userID)
+			identify(c, userID)
+			user, err := db.Users().Get(c.Request.Context(), userID)
 			if err != nil {
-				if err == sql.ErrNoRows {
-					c.JSON(http.StatusNotFound, formatter.NotFoundResponse("User not found"))
-				} else {
-					c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Authorization token not provided"))
-				}
+				slog.ErrorContext(c.Request.Context(), "Error retrieving user", "error", err)
+				respondError(c, err, "Failed to retrieve user")
 				return
 			}
 
 			// Check if the token matches the token stored in the user table
 			if user.Token == nil || *user.Token != tokenString {
-				c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Token mismatch"))
+				writeProblem(c, http.StatusUnauthorized, codeUnauthorized, "Token mismatch")
 				return
 			}
 
 			// Include server URL in the image link
-			scheme := "http"
-			if c.Request.TLS != nil {
-				scheme = "https"
-			}
-			user.Image = scheme + "://" + c.Request.Host + "/uploads/users/" + user.Image
+			user.ImageSet = imageSet(c, store, "users", user.Image, user.ImageWidth)
 
 			// Omit token and password from the response
 			user.Token = nil
@@ -196,7 +210,7 @@ func GetUserWithJWT(db *sql.DB, jwtKey string) gin.HandlerFunc {
 
 			c.JSON(http.StatusOK, formatter.SuccessResponse(user))
 		} else {
-			c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Invalid token claims"))
+			writeProblem(c, http.StatusUnauthorized, codeUnauthorized, "Invalid token claims")
 		}
 	}
 }
@@ -215,65 +229,154 @@ func generateJWT(userID, jwtKey string) (string, error) {
 	return tokenString, nil
 }
 
-func DeleteUser(db *sql.DB, jwtKey string) gin.HandlerFunc {
+func DeleteUser(db repository.DB, store storage.Storage, jwtKey string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Check if the user is logged in
 		authorizationHeader := c.GetHeader("Authorization")
 		if authorizationHeader == "" {
-			c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Authorization header not provided"))
+			writeProblem(c, http.StatusUnauthorized, codeUnauthorized, "Authorization header not provided")
 			return
 		}
 
 		tokenString := strings.TrimPrefix(authorizationHeader, "Bearer ")
 		if tokenString == "" {
-			c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Token not provided"))
+			writeProblem(c, http.StatusUnauthorized, codeUnauthorized, "Token not provided")
 			return
 		}
 
-		_, err := ValidateToken(tokenString, jwtKey, db)
+		userID, err := ValidateToken(c.Request.Context(), tokenString, jwtKey, db)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Invalid token"))
+			rejectToken(c, err)
 			return
 		}
+		identify(c, userID)
 
 		userIDToDelete := c.PostForm("user_id")
 		if userIDToDelete == "" {
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("User ID to delete is required"))
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "User ID to delete is required")
 			return
 		}
 
 		// Retrieve user to get the image path
-		user, err := model.GetUserID(db, userIDToDelete)
+		user, err := db.Users().Get(c.Request.Context(), userIDToDelete)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error retrieving user", "error", err)
+			respondError(c, err, "Failed to retrieve user")
+			return
+		}
+
+		// Delete the user from the database, then the image once that commits
+		err = db.RunInTx(c.Request.Context(), func(tx repository.Tx) error {
+			if err := tx.Users().Delete(c.Request.Context(), userIDToDelete); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error deleting user from database", "error", err)
+				return err
+			}
+			if err := releaseUpload(c.Request.Context(), db, tx, store, "users", user.Image); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error releasing image", "error", err)
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			respondError(c, err, "Failed to delete user")
+			return
+		}
+
+		// Return success response
+		c.JSON(http.StatusOK, formatter.SuccessResponse("User deleted successfully"))
+
+	}
+}
+
+// userPatch holds the profile fields a PATCH request may change
+type userPatch struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// PatchUser updates the profile of the user the token belongs to
+func PatchUser(db repository.DB, jwtKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authorizationHeader := c.GetHeader("Authorization")
+		if authorizationHeader == "" {
+			writeProblem(c, http.StatusUnauthorized, codeUnauthorized, "Authorization header not provided")
+			return
+		}
+
+		tokenString := strings.TrimPrefix(authorizationHeader, "Bearer ")
+		if tokenString == "" {
+			writeProblem(c, http.StatusUnauthorized, codeUnauthorized, "Token not provided")
+			return
+		}
+
+		userID, err := ValidateToken(c.Request.Context(), tokenString, jwtKey, db)
 		if err != nil {
-			log.Printf("Error retrieving user: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to retrieve user"))
+			rejectToken(c, err)
 			return
 		}
+		identify(c, userID)
 
-		// Delete the user from the database
-		if err := model.DeleteUser(db, userIDToDelete); err != nil {
-			log.Printf("Error deleting user from database: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to delete user"))
+		user, err := db.Users().Get(c.Request.Context(), userID)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error retrieving user", "error", err)
+			respondError(c, err, "Failed to retrieve user")
 			return
 		}
 
-		// Delete the user's image file
-		if user.Image != "" {
-			imagePath := "./uploads/users/" + user.Image
-			if err := os.Remove(imagePath); err != nil {
-				log.Printf("Error deleting image file: %v", err)
-				c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to delete user image"))
+		doc := userPatch{Name: user.Name, Email: user.Email}
+		if !applyPatch(c, &doc) {
+			return
+		}
+		user.Name = doc.Name
+		user.Email = doc.Email
+
+		// Another account may already use the new email
+		if doc.Email != "" {
+			other, err := db.Users().GetByEmail(c.Request.Context(), doc.Email)
+			if err != nil && !errors.Is(err, model.ErrNotFound) {
+				slog.ErrorContext(c.Request.Context(), "Error checking email", "error", err)
+				respondError(c, err, "Failed to check email")
+				return
+			}
+			if other != nil && other.ID != user.ID {
+				respondError(c, &model.ConflictError{Resource: "user", Message: "email already registered"}, "")
 				return
 			}
 		}
 
-		// Return success response
-		c.JSON(http.StatusOK, formatter.SuccessResponse("User deleted successfully"))
+		if err := db.Users().UpdateProfile(c.Request.Context(), *user); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error updating user", "error", err)
+			respondError(c, err, "Failed to update user")
+			return
+		}
 
+		c.JSON(http.StatusOK, formatter.SuccessResponse("User updated successfully"))
 	}
 }
 
-func ValidateToken(tokenString, jwtKey string, db *sql.DB) (string, error) {
+// identify adds the authenticated user to the request context, so it is
+// part of every log line written for the rest of the request
+func identify(c *gin.Context, userID string) {
+	c.Request = c.Request.WithContext(logging.WithUserID(c.Request.Context(), userID))
+}
+
+// rejectToken answers a request whose token failed validation: 401,
+// unless looking up its user timed out or the client went away
+func rejectToken(c *gin.Context, err error) {
+	if isContextError(err) {
+		respondError(c, err, "")
+		return
+	}
+	writeProblem(c, http.StatusUnauthorized, codeUnauthorized, "Invalid token")
+}
+
+func ValidateToken(ctx context.Context, tokenString, jwtKey string, db repository.DB) (string, error) {
+	userID, err := validateToken(ctx, tokenString, jwtKey, db)
+	metrics.ObserveAuth("token", err)
+	return userID, err
+}
+
+func validateToken(ctx context.Context, tokenString, jwtKey string, db repository.DB) (string, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		// Validate the token signing method
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -291,9 +394,9 @@ func ValidateToken(tokenString, jwtKey string, db *sql.DB) (string, error) {
 			return "", fmt.Errorf("userID claim is not a string")
 		}
 		// Retrieve the user from the database to check the token
-		user, err := model.GetUserID(db, userID)
+		user, err := db.Users().Get(ctx, userID)
 		if err != nil {
-			return "", fmt.Errorf("failed to retrieve user: %v", err)
+			return "", fmt.Errorf("failed to retrieve user: %w", err)
 		}
 		if user.Token != nil && *user.Token != tokenString {
 			return "", fmt.Errorf("token does not match user's token")
@@ -0,0 +1,134 @@
+package handler_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"portfolio/model"
+	"portfolio/repository"
+)
+
+func TestRegisterAuth(t *testing.T) {
+	s := newServer(t)
+	s.register("Ana", "ana@example.com", "secret")
+	if n := s.files("blobs"); n != 1 {
+		t.Fatalf("stored %d blobs, want 1", n)
+	}
+
+	// The email is taken
+	w := s.do(newCall("POST", "/api/v1/auth/register").multipart(url.Values{
+		"name": {"Ana"}, "email": {"ana@example.com"}, "password": {"secret"},
+	}, map[string][]byte{"image": pngImage(10)}))
+	s.expectProblem(w, http.StatusConflict, model.CodeConflict)
+
+	w = s.do(newCall("POST", "/api/v1/auth/register").multipart(url.Values{
+		"name": {"Ana"}, "email": {"not an email"},
+	}, map[string][]byte{"image": pngImage(10)}))
+	s.expectProblem(w, http.StatusUnprocessableEntity, model.CodeValidation)
+
+	w = s.do(newCall("POST", "/api/v1/auth/register").multipart(url.Values{
+		"name": {"Budi"}, "email": {"budi@example.com"}, "password": {"secret"},
+	}, nil))
+	s.expectProblem(w, http.StatusBadRequest, "bad_request")
+}
+
+func TestLoginAuth(t *testing.T) {
+	s := newServer(t)
+	s.register("Ana", "ana@example.com", "secret")
+	if token := s.login("ana@example.com", "secret"); token == "" {
+		t.Fatal("login returned no token")
+	}
+
+	for _, form := range []url.Values{
+		{"email": {"ana@example.com"}, "password": {"nope"}},
+		{"email": {"budi@example.com"}, "password": {"secret"}},
+	} {
+		w := s.do(newCall("POST", "/api/v1/auth/login").form(form))
+		s.expectProblem(w, http.StatusUnauthorized, "unauthorized")
+	}
+
+	s.expectProblem(s.do(newCall("POST", "/api/v1/auth/login").form(url.Values{})), http.StatusBadRequest, "bad_request")
+}
+
+func TestGetUserWithJWT(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+
+	w := s.expect(s.do(newCall("GET", "/api/v1/user").auth(token)), http.StatusOK)
+	var user struct {
+		Email string
+		Image struct{ Src string }
+	}
+	data(t, w, &user)
+	if user.Email != "ana@example.com" {
+		t.Fatalf("email = %q, want ana@example.com", user.Email)
+	}
+	if !strings.HasPrefix(user.Image.Src, testBaseURL+"/") {
+		t.Fatalf("image src = %q, want it under %s", user.Image.Src, testBaseURL)
+	}
+
+	s.expectProblem(s.do(newCall("GET", "/api/v1/user")), http.StatusUnauthorized, "unauthorized")
+	s.expectProblem(s.do(newCall("GET", "/api/v1/user").auth("garbage")), http.StatusUnauthorized, "unauthorized")
+
+	// A database failure is the server's fault, not a bad token
+	s.route(brokenUsers{s.db})
+	s.expectProblem(s.do(newCall("GET", "/api/v1/user").auth(token)), http.StatusInternalServerError, "internal_error")
+}
+
+// brokenUsers is a repository whose users cannot be read
+type brokenUsers struct{ repository.DB }
+
+func (d brokenUsers) Users() repository.Users { return failingGet{d.DB.Users()} }
+
+type failingGet struct{ repository.Users }
+
+func (failingGet) Get(context.Context, string) (*model.User, error) {
+	return nil, errors.New("connection reset by peer")
+}
+
+func TestPatchUser(t *testing.T) {
+	s := newServer(t)
+	s.register("Budi", "budi@example.com", "secret")
+	token := s.user()
+
+	patch := func(contentType, body string) *call {
+		return newCall("PATCH", "/api/v1/user").auth(token).raw(contentType, []byte(body))
+	}
+
+	s.expect(s.do(patch("application/merge-patch+json", `{"name":"Ana Maria"}`)), http.StatusOK)
+	w := s.expect(s.do(newCall("GET", "/api/v1/user").auth(token)), http.StatusOK)
+	var user struct{ Name string }
+	data(t, w, &user)
+	if user.Name != "Ana Maria" {
+		t.Fatalf("name = %q, want Ana Maria", user.Name)
+	}
+
+	s.expect(s.do(patch("application/json-patch+json", `[{"op":"replace","path":"/email","value":"ana@example.org"}]`)), http.StatusOK)
+	token = s.login("ana@example.org", "secret")
+
+	s.expectProblem(s.do(patch("application/merge-patch+json", `{"email":"budi@example.com"}`)), http.StatusConflict, model.CodeConflict)
+	s.expectProblem(s.do(patch("application/merge-patch+json", `{"password":"x"}`)), http.StatusUnprocessableEntity, model.CodeValidation)
+	s.expectProblem(s.do(patch("application/json", `{"name":"x"}`)), http.StatusUnsupportedMediaType, "unsupported_media_type")
+	s.expectProblem(s.do(newCall("PATCH", "/api/v1/user").raw("application/merge-patch+json", []byte(`{}`))), http.StatusUnauthorized, "unauthorized")
+}
+
+func TestDeleteUser(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+	w := s.expect(s.do(newCall("GET", "/api/v1/user").auth(token)), http.StatusOK)
+	var user struct{ ID string }
+	data(t, w, &user)
+
+	s.expectProblem(s.do(newCall("DELETE", "/api/v1/user").auth(token).multipart(url.Values{}, nil)), http.StatusBadRequest, "bad_request")
+	s.expectProblem(s.do(newCall("DELETE", "/api/v1/user").auth(token).multipart(url.Values{"user_id": {"missing"}}, nil)), http.StatusNotFound, model.CodeNotFound)
+
+	s.expect(s.do(newCall("DELETE", "/api/v1/user").auth(token).multipart(url.Values{"user_id": {user.ID}}, nil)), http.StatusOK)
+	if n := s.files("blobs"); n != 0 {
+		t.Fatalf("%d blobs left after delete, want 0", n)
+	}
+	s.expectProblem(s.do(newCall("GET", "/api/v1/user").auth(token)), http.StatusNotFound, model.CodeNotFound)
+}
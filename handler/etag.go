@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"net/http"
+	"portfolio/model"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Stable error code for writes sent without an If-Match header
+const codePreconditionRequired = "precondition_required"
+
+// setETag exposes a row version as a strong entity tag
+func setETag(c *gin.Context, version int) {
+	c.Header("ETag", strconv.Quote(strconv.Itoa(version)))
+}
+
+// requireIfMatch checks the If-Match header against the version the handler
+// just loaded and returns the version to write against. It aborts with 428
+// when the header is missing and 412 when none of its tags is current.
+func requireIfMatch(c *gin.Context, current int) (int, bool) {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		writeProblem(c, http.StatusPreconditionRequired, codePreconditionRequired, "If-Match header with the current ETag is required")
+		return 0, false
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return current, true
+		}
+
+		// If-Match uses strong comparison, so weak tags never match
+		version, err := strconv.Unquote(tag)
+		if err != nil {
+			continue
+		}
+		if v, err := strconv.Atoi(version); err == nil && v == current {
+			return current, true
+		}
+	}
+
+	setETag(c, current)
+	writeProblem(c, http.StatusPreconditionFailed, model.CodePreconditionFailed, "Resource was modified, fetch it again and retry with the new ETag")
+	return 0, false
+}
@@ -1,123 +1,108 @@
 package handler
 
 import (
-	"database/sql"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
-	"path/filepath"
 	"portfolio/model"
+	"portfolio/repository"
+	"portfolio/storage"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	formatter "github.com/ivanauliaa/response-formatter"
 )
 
-func AddExperiance(db *sql.DB, jwtKey string) gin.HandlerFunc {
+func AddExperiance(db repository.DB, store storage.Storage, jwtKey string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Validation with JWT
 		authorizationHeader := c.GetHeader("Authorization")
 		if authorizationHeader == "" {
-			c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Authorization header not provided"))
+			writeProblem(c, http.StatusUnauthorized, codeUnauthorized, "Authorization header not provided")
 			return
 		}
 
 		tokenString := strings.TrimPrefix(authorizationHeader, "Bearer ")
 		if tokenString == "" {
-			c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Token not provided"))
+			writeProblem(c, http.StatusUnauthorized, codeUnauthorized, "Token not provided")
 			return
 		}
 
-		_, err := ValidateToken(tokenString, jwtKey, db)
+		userID, err := ValidateToken(c.Request.Context(), tokenString, jwtKey, db)
 		if err != nil {
-			log.Printf("Error validating token: %v\n", err)
-			c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Invalid Token"))
+			slog.ErrorContext(c.Request.Context(), "Error validating token", "error", err)
+			rejectToken(c, err)
 			return
 		}
+		identify(c, userID)
 
 		// Parse form data
-		companyName := c.PostForm("company_name")
-		position := c.PostForm("position")
-		startDateStr := c.PostForm("start_date")
-		endDateStr := c.PostForm("end_date")
-		location := c.PostForm("location")
-		file, err := c.FormFile("image")
+		var errs model.ValidationErrors
+		startDate := parseDateField(&errs, "start_date", c.PostForm("start_date"))
+		endDate := parseDateField(&errs, "end_date", c.PostForm("end_date"))
+		skillIDs := c.PostFormArray("skill_ids")
 
-		if err != nil {
-			log.Printf("Error retrieving form file: %v\n", err)
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Image file is required"))
-			return
+		// Create experience
+		experience := model.Experience{
+			ID:          uuid.New().String(),
+			CompanyName: c.PostForm("company_name"),
+			Position:    c.PostForm("position"),
+			StartDate:   startDate,
+			EndDate:     endDate,
+			Location:    c.PostForm("location"),
 		}
 
-		// Generate UUID for the experience
-		experienceID := uuid.New().String()
-
-		// Save image to file
-		newFileName := uuid.New().String() + filepath.Ext(file.Filename)
-		imagePath := "uploads/experience/" + newFileName
-		if err := c.SaveUploadedFile(file, imagePath); err != nil {
-			log.Printf("Error saving uploaded file: %v\n", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to save image"))
+		// Validate every field before anything is written
+		errs = errs.Merge(model.Validate(&experience))
+		errs = append(errs, model.RequireNonEmpty("skill_ids", skillIDs)...)
+		if len(errs) > 0 {
+			validationErrorResponse(c, errs)
 			return
 		}
 
-		// Parse dates
-		startDate, err := time.Parse("2006-01-02", startDateStr)
-		if err != nil {
-			log.Printf("Error parsing start date: %v\n", err)
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Invalid start date format, expected yyyy-mm-dd"))
+		// The image comes inline or as a finished resumable upload
+		upload, ok := imageFromRequest(c, db, store, "experience", userID)
+		if !ok {
 			return
 		}
-		endDate, err := time.Parse("2006-01-02", endDateStr)
-		if err != nil {
-			log.Printf("Error parsing end date: %v\n", err)
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Invalid end date format, expected yyyy-mm-dd"))
+		if upload == nil {
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Image file or upload_id is required")
 			return
 		}
+		experience.Image = upload.Key
+		experience.ImageWidth = upload.Width
 
-		// Create experience
-		experience := model.Experience{
-			ID:          experienceID,
-			CompanyName: companyName,
-			Image:       newFileName,
-			Position:    position,
-			StartDate:   startDate,
-			EndDate:     endDate,
-			Location:    location,
-		}
-
-		// Insert experience into the database
-		if err := model.InsertExperience(db, &experience); err != nil {
-			log.Printf("Error inserting experience into database: %v\n", err)
-			// Delete the saved image if database transaction fails
-			if removeErr := os.Remove(imagePath); removeErr != nil {
-				log.Printf("Error removing image file after failed database insert: %v\n", removeErr)
+		// Write the experience, its skills and its image as one unit
+		err = db.RunInTx(c.Request.Context(), func(tx repository.Tx) error {
+			// Save image to file
+			if err := stageUpload(c.Request.Context(), db, tx, store, upload); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error saving uploaded file", "error", err)
+				return err
 			}
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to insert experience"))
-			return
-		}
 
-		// Retrieve skill IDs from form data
-		skillIDs := c.PostFormArray("skill_ids")
-		if len(skillIDs) == 0 {
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("At least one skill ID is required"))
-			return
-		}
+			// Insert experience into the database
+			if err := tx.Experiences().Insert(c.Request.Context(), &experience); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error inserting experience into database", "error", err)
+				return err
+			}
 
-		// Add skills to the portfolio
-		if err := experience.AddSkills(db, skillIDs); err != nil {
-			log.Printf("Error adding skills to portfolio: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to add skills to portfolio"))
+			// Add skills to the experience
+			if err := tx.Experiences().AddSkills(c.Request.Context(), experience.ID, skillIDs); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error adding skills to experience", "error", err)
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			respondError(c, err, "Failed to create experience")
 			return
 		}
 
 		c.JSON(http.StatusCreated, formatter.SuccessResponse(map[string]interface{}{
 			"id":           experience.ID,
 			"company_name": experience.CompanyName,
-			"image":        experience.Image,
+			"image":        imageSet(c, store, "experience", experience.Image, experience.ImageWidth),
 			"position":     experience.Position,
 			"location":     experience.Location,
 			"start_date":   experience.StartDate,
@@ -127,7 +112,7 @@ func AddExperiance(db *sql.DB, jwtKey string) gin.HandlerFunc {
 	}
 }
 
-func AddSkillsToExperience(db *sql.DB, jwtKey string) gin.HandlerFunc {
+func AddSkillsToExperience(db repository.DB, jwtKey string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if !checkUserLogin(c, jwtKey, db) {
 			return
@@ -135,35 +120,49 @@ func AddSkillsToExperience(db *sql.DB, jwtKey string) gin.HandlerFunc {
 
 		experienceID := c.Param("id")
 		if experienceID == "" {
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Experience ID is required"))
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Experience ID is required")
 			return
 		}
 
 		// Retrieve skill IDs from form input
 		skillIDs := c.PostFormArray("skill_ids")
 		if len(skillIDs) == 0 {
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Skill IDs are required"))
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Skill IDs are required")
 			return
 		}
 
-		experience, err := model.GetExperienceID(db, experienceID)
+		experience, err := db.Experiences().Get(c.Request.Context(), experienceID)
 		if err != nil {
-			log.Printf("Error retrieving portfolio: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to retrieve portfolio"))
+			slog.ErrorContext(c.Request.Context(), "Error retrieving experience", "error", err)
+			respondError(c, err, "Failed to retrieve experience")
 			return
 		}
 
-		if err := experience.AddSkills(db, skillIDs); err != nil {
-			log.Printf("Error adding skills to portfolio: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to add skills to portfolio"))
+		version, ok := requireIfMatch(c, experience.Version)
+		if !ok {
 			return
 		}
 
+		err = db.RunInTx(c.Request.Context(), func(tx repository.Tx) error {
+			if err := tx.Experiences().AddSkills(c.Request.Context(), experience.ID, skillIDs); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error adding skills to experience", "error", err)
+				return err
+			}
+			experience.Version, err = tx.Experiences().Touch(c.Request.Context(), experience.ID, version)
+			return err
+		})
+		if err != nil {
+			respondError(c, err, "Failed to add skills to experience")
+			return
+		}
+
+		setETag(c, experience.Version)
+
 		c.JSON(http.StatusOK, formatter.SuccessResponse("Skills successfully added to experience"))
 	}
 }
 
-func GetExperience(db *sql.DB) gin.HandlerFunc {
+func GetExperience(db repository.DB, store storage.Storage) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		//pagination parameters
 		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
@@ -171,34 +170,30 @@ func GetExperience(db *sql.DB) gin.HandlerFunc {
 		offset := (page - 1) * limit
 
 		//retrieve experience with pagination
-		experiences, err := model.GetExperience(db, offset, limit)
+		experiences, err := db.Experiences().List(c.Request.Context(), offset, limit)
 		if err != nil {
-			log.Printf("Error retrieving experience: %v\n", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to retrieve experiences"))
+			slog.ErrorContext(c.Request.Context(), "Error retrieving experience", "error", err)
+			respondError(c, err, "Failed to retrieve experiences")
 			return
 		}
 
 		//include server url in the image link
-		scheme := "http"
-		if c.Request.TLS != nil {
-			scheme = "https"
-		}
 
 		//retrieve experiences for each experience and inclue image paths
 		for i := range experiences {
-			experiences[i].Image = scheme + "://" + c.Request.Host + "/uploads/experience/" + experiences[i].Image
+			experiences[i].ImageSet = imageSet(c, store, "experience", experiences[i].Image, experiences[i].ImageWidth)
 		}
 
 		for i, experience := range experiences {
-			skills, err := model.GetSkillByExperienceID(db, experience.ID)
+			skills, err := db.Experiences().Skills(c.Request.Context(), experience.ID)
 			if err != nil {
-				log.Printf("Error retriving skill for experience %s: %v", experience.ID, err)
-				c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to retrive skill for experience"))
+				slog.ErrorContext(c.Request.Context(), "Error retriving skill for experience", "experience_id", experience.ID, "error", err)
+				respondError(c, err, "Failed to retrive skill for experience")
 				return
 			}
 
 			for j := range skills {
-				skills[j].Image = scheme + "://" + c.Request.Host + "/uploads/skills/" + skills[j].Image
+				skills[j].ImageSet = imageSet(c, store, "skills", skills[j].Image, skills[j].ImageWidth)
 			}
 
 			experiences[i].Skills = skills
@@ -211,85 +206,74 @@ func GetExperience(db *sql.DB) gin.HandlerFunc {
 	}
 }
 
-func GetExperienceByID(db *sql.DB) gin.HandlerFunc {
+func GetExperienceByID(db repository.DB, store storage.Storage) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		experienceID := c.Param("id")
 		if experienceID == "" {
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Experience ID is required"))
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Experience ID is required")
 			return
 		}
 
-		experience, err := model.GetExperienceID(db, experienceID)
+		experience, err := db.Experiences().Get(c.Request.Context(), experienceID)
 		if err != nil {
-			log.Printf("Error retrieving experience by ID: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to retrieve experience"))
+			slog.ErrorContext(c.Request.Context(), "Error retrieving experience by ID", "error", err)
+			respondError(c, err, "Failed to retrieve experience")
 			return
 		}
 
 		// Include server URL in the image link
-		scheme := "http"
-		if c.Request.TLS != nil {
-			scheme = "https"
-		}
-		experience.Image = scheme + "://" + c.Request.Host + "/uploads/experience/" + experience.Image
+		experience.ImageSet = imageSet(c, store, "experience", experience.Image, experience.ImageWidth)
 
 		//get skill by experience
-		// for i, experienceSkill := range experience {
-		// 	skills, err := model.GetSkillByExperienceID(db, experience.ID)
-		// 	if err != nil {
-		// 		log.Printf("Error retriving skill for experience %s: %v", experience.ID, err)
-		// 		c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to retrive skill for experience"))
-		// 		return
-		// 	}
-
-		// 	for j := range skills {
-		// 		skills[j].Image = scheme + "://" + c.Request.Host + "/uploads/skills/" + skills[j].Image
-		// 	}
-
-		// 	experience.Skills = skills
-		// }
-
-		skills, err := model.GetSkillByExperienceID(db, experience.ID)
+		skills, err := db.Experiences().Skills(c.Request.Context(), experience.ID)
 		if err != nil {
-			log.Printf("Error retriving skill for experience %s: %v", experience.ID, err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to retrive skill for experience"))
+			slog.ErrorContext(c.Request.Context(), "Error retriving skill for experience", "experience_id", experience.ID, "error", err)
+			respondError(c, err, "Failed to retrive skill for experience")
 			return
 		}
 
 		for j := range skills {
-			skills[j].Image = scheme + "://" + c.Request.Host + "/uploads/skills/" + skills[j].Image
+			skills[j].ImageSet = imageSet(c, store, "skills", skills[j].Image, skills[j].ImageWidth)
 		}
 
 		experience.Skills = skills
 
+		setETag(c, experience.Version)
 		c.JSON(http.StatusOK, formatter.SuccessResponse(experience))
 	}
 }
 
-func UpdateExperience(db *sql.DB, jwtKey string) gin.HandlerFunc {
+func UpdateExperience(db repository.DB, store storage.Storage, jwtKey string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if !checkUserLogin(c, jwtKey, db) {
+		userID, ok := authenticate(c, jwtKey, db)
+		if !ok {
 			return
 		}
 
 		experienceID := c.Param("id")
 		if experienceID == "" {
-			c.JSON(http.StatusBadGateway, formatter.BadRequestResponse("Experience id is required"))
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Experience id is required")
 			return
 		}
 
 		if err := c.Request.ParseForm(); err != nil {
-			log.Printf("Error parsing form data: %v\n", err)
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Error parsing form data"))
+			slog.ErrorContext(c.Request.Context(), "Error parsing form data", "error", err)
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Error parsing form data")
 			return
 		}
 
-		existingExperience, err := model.GetExperienceID(db, experienceID)
+		existingExperience, err := db.Experiences().Get(c.Request.Context(), experienceID)
 		if err != nil {
-			log.Printf("Error retrieving exsisting experience %v\n", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to retrieve experience"))
+			slog.ErrorContext(c.Request.Context(), "Error retrieving exsisting experience", "error", err)
+			respondError(c, err, "Failed to retrieve experience")
+			return
+		}
+
+		version, ok := requireIfMatch(c, existingExperience.Version)
+		if !ok {
 			return
 		}
+		existingExperience.Version = version
 
 		//update
 		companyName := c.PostForm("company_name")
@@ -297,66 +281,20 @@ func UpdateExperience(db *sql.DB, jwtKey string) gin.HandlerFunc {
 			existingExperience.CompanyName = companyName
 		}
 
-		//handle update image
-		file, header, err := c.Request.FormFile("image")
-		if err == nil {
-			// Generate new filename and save the file
-			newFilename := uuid.New().String() + filepath.Ext(header.Filename)
-			if err := c.SaveUploadedFile(header, "./uploads/experience/"+newFilename); err != nil {
-				log.Printf("Error saving uploaded file: %v", err)
-				c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to save image"))
-				return
-			}
-
-			// Delete old image if new one is uploaded
-			if existingExperience.Image != "" {
-				oldImagePath := "./uploads/experience/" + existingExperience.Image
-				if err := os.Remove(oldImagePath); err != nil {
-					if !os.IsNotExist(err) {
-						log.Printf("Error deleting old image file: %v", err)
-						c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to delete old image"))
-						return
-					}
-				}
-			}
-
-			// Update experience image to new filename
-			existingExperience.Image = newFilename
-		} else if err != http.ErrMissingFile {
-			log.Printf("Error retrieving image file: %v", err)
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Failed to retrieve image file"))
-			return
-		}
-
 		position := c.PostForm("position")
 		if position != "" && position != existingExperience.Position {
 			existingExperience.Position = position
 		}
 
+		var errs model.ValidationErrors
 		startDateStr := c.PostForm("start_date")
 		if startDateStr != "" {
-			startDate, err := time.Parse("2006-01-02", startDateStr)
-			if err != nil {
-				log.Printf("Error parsing start date: %v", err)
-				c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Invalid start date format"))
-				return
-			}
-			if startDate != existingExperience.StartDate {
-				existingExperience.StartDate = startDate
-			}
+			existingExperience.StartDate = parseDateField(&errs, "start_date", startDateStr)
 		}
 
 		endDateStr := c.PostForm("end_date")
 		if endDateStr != "" {
-			endDate, err := time.Parse("2006-01-02", endDateStr)
-			if err != nil {
-				log.Printf("Error parsing start date: %v", err)
-				c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Invalid end date format"))
-				return
-			}
-			if endDate != existingExperience.EndDate {
-				existingExperience.EndDate = endDate
-			}
+			existingExperience.EndDate = parseDateField(&errs, "end_date", endDateStr)
 		}
 
 		location := c.PostForm("location")
@@ -364,63 +302,173 @@ func UpdateExperience(db *sql.DB, jwtKey string) gin.HandlerFunc {
 			existingExperience.Location = location
 		}
 
-		//update data
-		if companyName != "" || file != nil || position != "" || startDateStr != "" || endDateStr != "" || location != "" {
-			err = model.UpdateExperience(db, existingExperience)
-			if err != nil {
-				log.Printf("Error updating experience: %v", err)
-				c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to update experiemce"))
-				return
+		// Validate the merged experience before anything is written
+		errs = errs.Merge(model.Validate(existingExperience))
+		if len(errs) > 0 {
+			validationErrorResponse(c, errs)
+			return
+		}
+
+		//handle update image
+		upload, ok := imageFromRequest(c, db, store, "experience", userID)
+		if !ok {
+			return
+		}
+
+		// Write the experience and its image as one unit
+		err = db.RunInTx(c.Request.Context(), func(tx repository.Tx) error {
+			if upload != nil {
+				// Stage the new image and release the old one
+				if err := stageUpload(c.Request.Context(), db, tx, store, upload); err != nil {
+					slog.ErrorContext(c.Request.Context(), "Error saving uploaded file", "error", err)
+					return err
+				}
+				if err := releaseUpload(c.Request.Context(), db, tx, store, "experience", existingExperience.Image); err != nil {
+					slog.ErrorContext(c.Request.Context(), "Error releasing image", "error", err)
+					return err
+				}
+				existingExperience.Image = upload.Key
+				existingExperience.ImageWidth = upload.Width
 			}
+
+			//update data
+			if companyName != "" || upload != nil || position != "" || startDateStr != "" || endDateStr != "" || location != "" {
+				if err := tx.Experiences().Update(c.Request.Context(), existingExperience); err != nil {
+					slog.ErrorContext(c.Request.Context(), "Error updating experience", "error", err)
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			respondError(c, err, "Failed to update experience")
+			return
 		}
 
+		setETag(c, existingExperience.Version)
+
 		c.JSON(http.StatusOK, formatter.SuccessResponse("Experience updated successfully"))
 	}
 }
 
-func DeleteExperience(db *sql.DB, jwtKey string) gin.HandlerFunc {
+// experiencePatch holds the experience fields a PATCH request may change
+type experiencePatch struct {
+	CompanyName string `json:"company_name"`
+	Position    string `json:"position"`
+	StartDate   string `json:"start_date"`
+	EndDate     string `json:"end_date"`
+	Location    string `json:"location"`
+}
+
+func PatchExperience(db repository.DB, jwtKey string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if !checkUserLogin(c, jwtKey, db) {
 			return
 		}
 
 		experienceID := c.Param("id")
-
 		if experienceID == "" {
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Experience id is required"))
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Experience id is required")
 			return
 		}
 
-		experience, err := model.GetExperienceID(db, experienceID)
+		existingExperience, err := db.Experiences().Get(c.Request.Context(), experienceID)
 		if err != nil {
-			log.Printf("Err retriveing experiences: %v\n", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to retrieve experience"))
+			slog.ErrorContext(c.Request.Context(), "Error retrieving exsisting experience", "error", err)
+			respondError(c, err, "Failed to retrieve experience")
+			return
+		}
+
+		version, ok := requireIfMatch(c, existingExperience.Version)
+		if !ok {
+			return
+		}
+		existingExperience.Version = version
+
+		doc := experiencePatch{
+			CompanyName: existingExperience.CompanyName,
+			Position:    existingExperience.Position,
+			StartDate:   existingExperience.StartDate.Format(dateLayout),
+			EndDate:     existingExperience.EndDate.Format(dateLayout),
+			Location:    existingExperience.Location,
+		}
+		if !applyPatch(c, &doc) {
 			return
 		}
 
-		err = model.DeleteExperienceAndRelations(db, experienceID)
+		var errs model.ValidationErrors
+		existingExperience.CompanyName = doc.CompanyName
+		existingExperience.Position = doc.Position
+		existingExperience.StartDate = parseDateField(&errs, "start_date", doc.StartDate)
+		existingExperience.EndDate = parseDateField(&errs, "end_date", doc.EndDate)
+		existingExperience.Location = doc.Location
+
+		// Validate the patched experience before anything is written
+		errs = errs.Merge(model.Validate(existingExperience))
+		if len(errs) > 0 {
+			validationErrorResponse(c, errs)
+			return
+		}
+
+		if err := db.Experiences().Update(c.Request.Context(), existingExperience); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error updating experience", "error", err)
+			respondError(c, err, "Failed to update experience")
+			return
+		}
+
+		setETag(c, existingExperience.Version)
+
+		c.JSON(http.StatusOK, formatter.SuccessResponse("Experience updated successfully"))
+	}
+}
+
+func DeleteExperience(db repository.DB, store storage.Storage, jwtKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkUserLogin(c, jwtKey, db) {
+			return
+		}
+
+		experienceID := c.Param("id")
+
+		if experienceID == "" {
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Experience id is required")
+			return
+		}
+
+		experience, err := db.Experiences().Get(c.Request.Context(), experienceID)
 		if err != nil {
-			log.Printf("Error deleting skill with relations: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to delete experience and its relations"))
+			slog.ErrorContext(c.Request.Context(), "Err retriveing experiences", "error", err)
+			respondError(c, err, "Failed to retrieve experience")
 			return
 		}
 
-		if experience.ID != "" {
-			imagePath := "./uploads/experience/" + experience.Image
-			if err := os.Remove(imagePath); err != nil {
-				if !os.IsNotExist(err) {
-					log.Printf("Error deleting image file: %v", err)
-					c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to delete portfolio image"))
-					return
-				}
+		version, ok := requireIfMatch(c, experience.Version)
+		if !ok {
+			return
+		}
+
+		// The image is only removed once the rows are gone for good
+		err = db.RunInTx(c.Request.Context(), func(tx repository.Tx) error {
+			if err := tx.Experiences().Delete(c.Request.Context(), experienceID, version); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error deleting experience with relations", "error", err)
+				return err
+			}
+			if err := releaseUpload(c.Request.Context(), db, tx, store, "experience", experience.Image); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error releasing image", "error", err)
+				return err
 			}
+			return nil
+		})
+		if err != nil {
+			respondError(c, err, "Failed to delete experience and its relations")
+			return
 		}
 
 		c.JSON(http.StatusOK, formatter.SuccessResponse("Experience deleted successfully"))
 	}
 }
 
-func DeleteSkillExperienceWithRelationsHandler(db *sql.DB, jwtKey string) gin.HandlerFunc {
+func DeleteSkillExperienceWithRelationsHandler(db repository.DB, jwtKey string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if !checkUserLogin(c, jwtKey, db) {
 			return
@@ -428,23 +476,43 @@ func DeleteSkillExperienceWithRelationsHandler(db *sql.DB, jwtKey string) gin.Ha
 
 		experienceID := c.Param("id")
 		if experienceID == "" {
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Experience ID is required"))
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Experience ID is required")
 			return
 		}
 
 		skillID := c.PostForm("skill_id")
 		if skillID == "" {
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Skill ID is required"))
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Skill ID is required")
 			return
 		}
 
-		err := model.DeleteSkillAndExperienceRelations(db, skillID, experienceID)
+		experience, err := db.Experiences().Get(c.Request.Context(), experienceID)
 		if err != nil {
-			log.Printf("Error deleting skill with relations: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to delete skill from portfolio"))
+			slog.ErrorContext(c.Request.Context(), "Error retrieving experience", "error", err)
+			respondError(c, err, "Failed to retrieve experience")
 			return
 		}
 
-		c.JSON(http.StatusOK, formatter.SuccessResponse("Skill successfully deleted from portfolio"))
+		version, ok := requireIfMatch(c, experience.Version)
+		if !ok {
+			return
+		}
+
+		err = db.RunInTx(c.Request.Context(), func(tx repository.Tx) error {
+			if err := tx.Experiences().RemoveSkill(c.Request.Context(), experience.ID, skillID); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error deleting skill with relations", "error", err)
+				return err
+			}
+			experience.Version, err = tx.Experiences().Touch(c.Request.Context(), experience.ID, version)
+			return err
+		})
+		if err != nil {
+			respondError(c, err, "Failed to delete skill from experience")
+			return
+		}
+
+		setETag(c, experience.Version)
+
+		c.JSON(http.StatusOK, formatter.SuccessResponse("Skill successfully deleted from experience"))
 	}
 }
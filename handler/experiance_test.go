@@ -0,0 +1,184 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"portfolio/model"
+)
+
+func TestAddExperiance(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+	skillID := s.createSkill(token, "Go")
+	id := s.createExperience(token, skillID)
+
+	w := s.expect(s.do(newCall("GET", "/api/v1/experience/"+id)), http.StatusOK)
+	var experience struct {
+		CompanyName string `json:"company_name"`
+		Skills      []struct{ ID string }
+	}
+	data(t, w, &experience)
+	if experience.CompanyName != "Acme" || len(experience.Skills) != 1 || experience.Skills[0].ID != skillID {
+		t.Fatalf("experience = %+v, want Acme with skill %s", experience, skillID)
+	}
+
+	fields := url.Values{
+		"company_name": {"Acme"},
+		"position":     {"Engineer"},
+		"start_date":   {"2022-01-01"},
+		"end_date":     {"2020-01-01"},
+		"skill_ids":    {skillID},
+	}
+	images := map[string][]byte{"image": pngImage(31)}
+	s.expectProblem(s.do(newCall("POST", "/api/v1/experience").multipart(fields, images)), http.StatusUnauthorized, "unauthorized")
+	s.expectProblem(s.do(newCall("POST", "/api/v1/experience").auth(token).multipart(fields, images)), http.StatusUnprocessableEntity, model.CodeValidation)
+
+	// An unknown skill rolls back the experience and its image
+	fields.Set("end_date", "2023-01-01")
+	fields.Set("skill_ids", "missing")
+	blobs := s.files("blobs")
+	s.expectProblem(s.do(newCall("POST", "/api/v1/experience").auth(token).multipart(fields, images)), http.StatusConflict, model.CodeConflict)
+	if n := s.files("blobs"); n != blobs {
+		t.Fatalf("stored %d blobs after rollback, want %d", n, blobs)
+	}
+	w = s.expect(s.do(newCall("GET", "/api/v1/experience")), http.StatusOK)
+	var list struct{ Experience []struct{ ID string } }
+	data(t, w, &list)
+	if len(list.Experience) != 1 {
+		t.Fatalf("listed %d experiences, want 1", len(list.Experience))
+	}
+}
+
+func TestGetExperience(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+	skillID := s.createSkill(token, "Go")
+	for i := 0; i < 3; i++ {
+		s.createExperience(token, skillID)
+	}
+
+	w := s.expect(s.do(newCall("GET", "/api/v1/experience?page=2&limit=2")), http.StatusOK)
+	var list struct {
+		Experience []struct {
+			Skills []struct{ ID string }
+		}
+	}
+	data(t, w, &list)
+	if len(list.Experience) != 1 || len(list.Experience[0].Skills) != 1 {
+		t.Fatalf("page 2 = %+v, want one experience with its skill", list.Experience)
+	}
+
+	s.expectProblem(s.do(newCall("GET", "/api/v1/experience/missing")), http.StatusNotFound, model.CodeNotFound)
+}
+
+func TestUpdateExperience(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+	id := s.createExperience(token, s.createSkill(token, "Go"))
+	target := "/api/v1/experience/" + id
+
+	update := func(fields url.Values) *call {
+		return newCall("PUT", target).auth(token).multipart(fields, map[string][]byte{"image": pngImage(32)})
+	}
+
+	s.expectProblem(s.do(update(url.Values{"position": {"Lead"}})), http.StatusPreconditionRequired, "precondition_required")
+	s.expect(s.do(update(url.Values{"position": {"Lead"}}).set("If-Match", s.etag(target))), http.StatusOK)
+	s.expectProblem(s.do(update(url.Values{"position": {"CTO"}}).set("If-Match", `"1"`)), http.StatusPreconditionFailed, model.CodePreconditionFailed)
+	s.expectProblem(s.do(update(url.Values{"start_date": {"2030-01-01"}}).set("If-Match", "*")), http.StatusUnprocessableEntity, model.CodeValidation)
+
+	w := s.expect(s.do(newCall("GET", target)), http.StatusOK)
+	var experience struct {
+		Position string
+		Version  int
+	}
+	data(t, w, &experience)
+	if experience.Position != "Lead" || experience.Version != 2 {
+		t.Fatalf("experience = %+v, want Lead at version 2", experience)
+	}
+}
+
+func TestPatchExperience(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+	id := s.createExperience(token, s.createSkill(token, "Go"))
+	target := "/api/v1/experience/" + id
+
+	patch := func(contentType, body string) *call {
+		return newCall("PATCH", target).auth(token).raw(contentType, []byte(body))
+	}
+
+	s.expectProblem(s.do(patch("application/merge-patch+json", `{"location":null}`)), http.StatusPreconditionRequired, "precondition_required")
+	s.expect(s.do(patch("application/merge-patch+json", `{"location":null}`).set("If-Match", s.etag(target))), http.StatusOK)
+	s.expect(s.do(patch("application/json-patch+json", `[{"op":"test","path":"/location","value":""},{"op":"replace","path":"/position","value":"Lead"}]`).set("If-Match", s.etag(target))), http.StatusOK)
+	s.expectProblem(s.do(patch("application/json-patch+json", `[{"op":"test","path":"/position","value":"Intern"}]`).set("If-Match", "*")), http.StatusConflict, model.CodeConflict)
+	s.expectProblem(s.do(patch("application/merge-patch+json", `{"end_date":"yesterday"}`).set("If-Match", "*")), http.StatusUnprocessableEntity, model.CodeValidation)
+
+	w := s.expect(s.do(newCall("GET", target)), http.StatusOK)
+	var experience struct{ Position, Location string }
+	data(t, w, &experience)
+	if experience.Position != "Lead" || experience.Location != "" {
+		t.Fatalf("experience = %+v, want Lead without a location", experience)
+	}
+}
+
+func TestDeleteExperience(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+	skillID := s.createSkill(token, "Go")
+	id := s.createExperience(token, skillID)
+	target := "/api/v1/experience/" + id
+
+	s.expectProblem(s.do(newCall("DELETE", target).auth(token)), http.StatusPreconditionRequired, "precondition_required")
+
+	// A portfolio still points at the experience
+	portfolioID := s.createPortfolio(token, id, skillID)
+	s.expectProblem(s.do(newCall("DELETE", target).auth(token).set("If-Match", "*")), http.StatusConflict, model.CodeConflict)
+
+	etag := s.etag("/api/v1/portfolio/" + portfolioID)
+	s.expect(s.do(newCall("PATCH", "/api/v1/portfolio/"+portfolioID).auth(token).set("If-Match", etag).
+		raw("application/merge-patch+json", []byte(`{"experience_id":null}`))), http.StatusOK)
+
+	s.expect(s.do(newCall("DELETE", target).auth(token).set("If-Match", s.etag(target))), http.StatusOK)
+	s.expectProblem(s.do(newCall("GET", target)), http.StatusNotFound, model.CodeNotFound)
+}
+
+func TestExperienceSkills(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+	goID := s.createSkill(token, "Go")
+	sqlID := s.createSkill(token, "SQL")
+	id := s.createExperience(token, goID)
+	target := "/api/v1/experience/" + id
+
+	add := func(skillIDs ...string) *call {
+		return newCall("PUT", "/api/v1/experience-skill/"+id).auth(token).form(url.Values{"skill_ids": skillIDs})
+	}
+
+	s.expectProblem(s.do(add(sqlID)), http.StatusPreconditionRequired, "precondition_required")
+	s.expectProblem(s.do(add().set("If-Match", "*")), http.StatusBadRequest, "bad_request")
+	w := s.expect(s.do(add(sqlID).set("If-Match", s.etag(target))), http.StatusOK)
+	if etag := w.Header().Get("ETag"); etag != `"2"` {
+		t.Fatalf("ETag = %s, want \"2\"", etag)
+	}
+	s.expectProblem(s.do(add(sqlID).set("If-Match", "*")), http.StatusConflict, model.CodeConflict)
+
+	remove := func() *call {
+		return newCall("POST", "/api/v1/experience-skill/"+id).auth(token).form(url.Values{"skill_id": {goID}})
+	}
+	s.expectProblem(s.do(remove()), http.StatusPreconditionRequired, "precondition_required")
+	s.expectProblem(s.do(remove().set("If-Match", `"1"`)), http.StatusPreconditionFailed, model.CodePreconditionFailed)
+	w = s.expect(s.do(remove().set("If-Match", `"2"`)), http.StatusOK)
+	if etag := w.Header().Get("ETag"); etag != `"3"` {
+		t.Fatalf("ETag after removing a skill = %s, want \"3\"", etag)
+	}
+	s.expectProblem(s.do(newCall("POST", "/api/v1/experience-skill/"+id).auth(token).form(url.Values{})), http.StatusBadRequest, "bad_request")
+
+	w = s.expect(s.do(newCall("GET", target)), http.StatusOK)
+	var experience struct{ Skills []struct{ ID string } }
+	data(t, w, &experience)
+	if len(experience.Skills) != 1 || experience.Skills[0].ID != sqlID {
+		t.Fatalf("skills = %+v, want only %s", experience.Skills, sqlID)
+	}
+}
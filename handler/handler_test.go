@@ -0,0 +1,338 @@
+package handler_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"portfolio/config"
+	"portfolio/handler"
+	"portfolio/media"
+	"portfolio/publicurl"
+	"portfolio/repository"
+	"portfolio/storage"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	testJWTKey     = "test-jwt-key"
+	testSigningKey = "test-signing-key"
+	testBaseURL    = "http://api.test"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	limits := make(map[string]media.Limits)
+	for kind, l := range config.Default().Uploads.Limits() {
+		limits[kind] = media.Limits(l)
+	}
+	media.SetLimits(limits)
+	os.Exit(m.Run())
+}
+
+// server routes every endpoint as main does, on an in-memory repository
+// and a local store in a temporary directory
+type server struct {
+	t        *testing.T
+	router   *gin.Engine
+	db       repository.DB
+	store    *storage.Local
+	draining atomic.Bool
+}
+
+func newServer(t *testing.T) *server {
+	t.Helper()
+	s := &server{
+		t:     t,
+		db:    repository.NewMemory(),
+		store: storage.NewLocal(t.TempDir(), "/uploads"),
+	}
+	s.route(s.db)
+	return s
+}
+
+// route serves the endpoints from db, which may wrap s.db to inject faults
+func (s *server) route(db repository.DB) {
+	s.t.Helper()
+	urls, err := publicurl.New(testBaseURL, "", nil)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	r := gin.New()
+	err = handler.Routes(r, db, s.store, handler.RouteConfig{
+		JWTKey:        testJWTKey,
+		SigningKey:    testSigningKey,
+		ImageCacheDir: s.t.TempDir(),
+		Tus:           handler.TusConfig{MaxSize: 1 << 20, Expiry: time.Hour},
+		URLs:          urls,
+		Checks:        []handler.HealthCheck{handler.StorageCheck(s.store)},
+		Draining:      &s.draining,
+		Build:         handler.BuildInfo{Version: "test"},
+	})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	s.router = r
+}
+
+// call is a request under construction
+type call struct {
+	method, target string
+	header         http.Header
+	body           []byte
+	// brokenAt, when positive, is where the body fails
+	brokenAt int
+}
+
+func newCall(method, target string) *call {
+	return &call{method: method, target: target, header: http.Header{}}
+}
+
+func (c *call) auth(token string) *call {
+	return c.set("Authorization", "Bearer "+token)
+}
+
+func (c *call) set(key, value string) *call {
+	c.header.Set(key, value)
+	return c
+}
+
+// form sends fields URL encoded
+func (c *call) form(fields url.Values) *call {
+	c.body = []byte(fields.Encode())
+	return c.set("Content-Type", "application/x-www-form-urlencoded")
+}
+
+// multipart sends fields along with files, keyed by form field
+func (c *call) multipart(fields url.Values, files map[string][]byte) *call {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for key, values := range fields {
+		for _, v := range values {
+			w.WriteField(key, v)
+		}
+	}
+	for field, data := range files {
+		part, _ := w.CreateFormFile(field, field+".bin")
+		part.Write(data)
+	}
+	w.Close()
+
+	c.body = buf.Bytes()
+	return c.set("Content-Type", w.FormDataContentType())
+}
+
+// raw sends body as is with the given Content-Type
+func (c *call) raw(contentType string, body []byte) *call {
+	c.body = body
+	return c.set("Content-Type", contentType)
+}
+
+// cutOff makes the body fail after n bytes, as when the client goes away
+// halfway through sending it
+func (c *call) cutOff(n int) *call {
+	c.brokenAt = n
+	return c
+}
+
+func (s *server) do(c *call) *httptest.ResponseRecorder {
+	var body io.Reader = bytes.NewReader(c.body)
+	if c.brokenAt > 0 {
+		body = io.MultiReader(bytes.NewReader(c.body[:c.brokenAt]), iotest.ErrReader(errors.New("connection reset")))
+	}
+	req := httptest.NewRequest(c.method, c.target, body)
+	req.ContentLength = int64(len(c.body))
+	for key := range c.header {
+		req.Header.Set(key, c.header.Get(key))
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+// expect fails the test unless w has the given status
+func (s *server) expect(w *httptest.ResponseRecorder, status int) *httptest.ResponseRecorder {
+	s.t.Helper()
+	if w.Code != status {
+		s.t.Fatalf("status = %d, want %d, body: %s", w.Code, status, w.Body.String())
+	}
+	return w
+}
+
+// data decodes the data field of a success response into v
+func data(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	var body struct{ Data json.RawMessage }
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body.String(), err)
+	}
+	if err := json.Unmarshal(body.Data, v); err != nil {
+		t.Fatalf("decoding data %s: %v", body.Data, err)
+	}
+}
+
+// problem decodes a problem response
+func problem(t *testing.T, w *httptest.ResponseRecorder) handler.Problem {
+	t.Helper()
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/problem+json") {
+		t.Fatalf("Content-Type = %q, want a problem", ct)
+	}
+	var p handler.Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body.String(), err)
+	}
+	return p
+}
+
+// expectProblem fails the test unless w is a problem with status and code
+func (s *server) expectProblem(w *httptest.ResponseRecorder, status int, code string) {
+	s.t.Helper()
+	s.expect(w, status)
+	if p := problem(s.t, w); p.Code != code {
+		s.t.Fatalf("problem code = %q, want %q", p.Code, code)
+	}
+}
+
+// pngImage returns a small PNG filled with shade, so images with another
+// shade are stored as another blob
+func pngImage(shade uint8) []byte {
+	img := image.NewGray(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = shade
+	}
+	img.SetGray(0, 0, color.Gray{Y: shade + 1})
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+// register creates an account and returns its id
+func (s *server) register(name, email, password string) string {
+	s.t.Helper()
+	w := s.expect(s.do(newCall("POST", "/api/v1/auth/register").multipart(url.Values{
+		"name": {name}, "email": {email}, "password": {password},
+	}, map[string][]byte{"image": pngImage(10)})), http.StatusCreated)
+
+	var user struct{ ID string }
+	data(s.t, w, &user)
+	return user.ID
+}
+
+// login returns a token for the account with email
+func (s *server) login(email, password string) string {
+	s.t.Helper()
+	w := s.expect(s.do(newCall("POST", "/api/v1/auth/login").form(url.Values{
+		"email": {email}, "password": {password},
+	})), http.StatusOK)
+
+	var user struct{ Token string }
+	data(s.t, w, &user)
+	return user.Token
+}
+
+// user registers an account and returns a token for it
+func (s *server) user() string {
+	s.t.Helper()
+	s.register("Ana", "ana@example.com", "secret")
+	return s.login("ana@example.com", "secret")
+}
+
+// createSkill creates a skill and returns its id
+func (s *server) createSkill(token, name string) string {
+	s.t.Helper()
+	w := s.expect(s.do(newCall("POST", "/api/v1/skills").auth(token).multipart(url.Values{
+		"name": {name},
+	}, map[string][]byte{"image": pngImage(20)})), http.StatusCreated)
+
+	var skill struct{ ID string }
+	data(s.t, w, &skill)
+	return skill.ID
+}
+
+// createExperience creates an experience with skillIDs and returns its id
+func (s *server) createExperience(token string, skillIDs ...string) string {
+	s.t.Helper()
+	w := s.expect(s.do(newCall("POST", "/api/v1/experience").auth(token).multipart(url.Values{
+		"company_name": {"Acme"},
+		"position":     {"Engineer"},
+		"location":     {"Jakarta"},
+		"start_date":   {"2020-01-01"},
+		"end_date":     {"2022-12-31"},
+		"skill_ids":    skillIDs,
+	}, map[string][]byte{"image": pngImage(30)})), http.StatusCreated)
+
+	var experience struct{ ID string }
+	data(s.t, w, &experience)
+	return experience.ID
+}
+
+// createPortfolio creates a portfolio with skillIDs and, unless empty,
+// experienceID, and returns its id
+func (s *server) createPortfolio(token, experienceID string, skillIDs ...string) string {
+	s.t.Helper()
+	fields := url.Values{
+		"title":        {"Portfolio site"},
+		"subtitle":     {"Built with Go"},
+		"content":      {"A site about my work"},
+		"status":       {"done"},
+		"date_project": {"2023-05-01"},
+		"skill_ids":    skillIDs,
+	}
+	if experienceID != "" {
+		fields.Set("experience_id", experienceID)
+	}
+	w := s.expect(s.do(newCall("POST", "/api/v1/portfolio").auth(token).multipart(fields, map[string][]byte{"image": pngImage(40)})), http.StatusOK)
+
+	var portfolio struct{ ID string }
+	data(s.t, w, &portfolio)
+	return portfolio.ID
+}
+
+// etag returns the ETag a GET of target answers with
+func (s *server) etag(target string) string {
+	s.t.Helper()
+	w := s.expect(s.do(newCall("GET", target)), http.StatusOK)
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		s.t.Fatalf("GET %s has no ETag", target)
+	}
+	return etag
+}
+
+// files counts the files the store holds below dir
+func (s *server) files(dir string) int {
+	s.t.Helper()
+	n := 0
+	err := filepath.WalkDir(filepath.Join(s.store.Root(), dir), func(path string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return filepath.SkipDir
+		}
+		if err == nil && !d.IsDir() {
+			n++
+		}
+		return err
+	})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return n
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"portfolio/migrations"
+	"portfolio/storage"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	formatter "github.com/ivanauliaa/response-formatter"
+)
+
+// readyTimeout bounds all the checks of one readiness probe
+const readyTimeout = 3 * time.Second
+
+// storageProbeTTL is how long the result of a storage probe is reused, so
+// frequent readiness probes do not write an object each
+const storageProbeTTL = 5 * time.Second
+
+// HealthCheck is a dependency probed by Readyz.
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// BuildInfo identifies the running binary.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+// Healthz reports that the process is up and serving requests. It checks
+// nothing else, so a restart is only triggered when the process hangs.
+func Healthz() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, formatter.SuccessResponse(gin.H{"status": "ok"}))
+	}
+}
+
+// Readyz runs every check concurrently and answers 503 when one fails, or
+// right away while draining is set during shutdown. The response only says
+// which checks failed; why is logged, since the endpoint is public.
+func Readyz(draining *atomic.Bool, checks []HealthCheck) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if draining.Load() {
+			c.JSON(http.StatusServiceUnavailable, formatter.ResponseFormatter(http.StatusServiceUnavailable, "Fail", gin.H{"status": "shutting down"}))
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), readyTimeout)
+		defer cancel()
+
+		results := make(map[string]string, len(checks))
+		ready := true
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, check := range checks {
+			wg.Add(1)
+			go func(check HealthCheck) {
+				defer wg.Done()
+				err := check.Check(ctx)
+
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					slog.WarnContext(c.Request.Context(), "Readiness check failed", "check", check.Name, "error", err)
+					results[check.Name] = "failed"
+					ready = false
+					return
+				}
+				results[check.Name] = "ok"
+			}(check)
+		}
+		wg.Wait()
+
+		if !ready {
+			c.JSON(http.StatusServiceUnavailable, formatter.ResponseFormatter(http.StatusServiceUnavailable, "Fail", gin.H{"status": "not ready", "checks": results}))
+			return
+		}
+		c.JSON(http.StatusOK, formatter.SuccessResponse(gin.H{"status": "ready", "checks": results}))
+	}
+}
+
+// Version reports the build of the running binary.
+func Version(info BuildInfo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, formatter.SuccessResponse(info))
+	}
+}
+
+// DatabaseCheck pings the database.
+func DatabaseCheck(db *sql.DB) HealthCheck {
+	return HealthCheck{Name: "database", Check: db.PingContext}
+}
+
+// MigrationsCheck fails while embedded migrations are not applied yet.
+func MigrationsCheck(db *sql.DB) HealthCheck {
+	return HealthCheck{Name: "migrations", Check: func(ctx context.Context) error {
+		pending, err := migrations.Pending(ctx, db)
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			return fmt.Errorf("%d pending, first is %d_%s", len(pending), pending[0].Version, pending[0].Name)
+		}
+		return nil
+	}}
+}
+
+// StorageCheck writes and deletes a small object to prove the backend
+// accepts uploads. The result is reused for storageProbeTTL.
+func StorageCheck(store storage.Storage) HealthCheck {
+	var (
+		mu       sync.Mutex
+		probedAt time.Time
+		result   error
+	)
+	return HealthCheck{Name: "storage", Check: func(ctx context.Context) error {
+		// Concurrent probes wait for the one running rather than start their own
+		mu.Lock()
+		defer mu.Unlock()
+		if !probedAt.IsZero() && time.Since(probedAt) < storageProbeTTL {
+			return result
+		}
+
+		err := probeStorage(ctx, store)
+		// A probe cut short by its caller says nothing about the storage
+		if ctx.Err() == nil {
+			probedAt, result = time.Now(), err
+		}
+		return err
+	}}
+}
+
+func probeStorage(ctx context.Context, store storage.Storage) error {
+	key := "healthz/" + uuid.NewString()
+	probe := []byte("ok")
+	if err := store.Put(ctx, key, bytes.NewReader(probe), int64(len(probe)), "text/plain"); err != nil {
+		return err
+	}
+	return store.Delete(ctx, key)
+}
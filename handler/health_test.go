@@ -0,0 +1,119 @@
+package handler_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"portfolio/handler"
+	"portfolio/storage"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHealthz(t *testing.T) {
+	s := newServer(t)
+	s.expect(s.do(newCall("GET", "/healthz")), http.StatusOK)
+}
+
+func TestReadyz(t *testing.T) {
+	s := newServer(t)
+	s.expect(s.do(newCall("GET", "/readyz")), http.StatusOK)
+
+	s.draining.Store(true)
+	s.expect(s.do(newCall("GET", "/readyz")), http.StatusServiceUnavailable)
+}
+
+func TestReadyzFailure(t *testing.T) {
+	s := newServer(t)
+	// A file where the upload directory should be makes every write fail
+	root := s.store.Root()
+	if err := os.RemoveAll(root); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(root, []byte("not a directory"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := s.expect(s.do(newCall("GET", "/readyz")), http.StatusServiceUnavailable)
+	var result struct{ Checks map[string]string }
+	data(t, w, &result)
+	if result.Checks["storage"] != "failed" {
+		t.Fatalf("storage check = %q, want failed", result.Checks["storage"])
+	}
+	if strings.Contains(w.Body.String(), root) {
+		t.Fatalf("readiness response reveals the error: %s", w.Body.String())
+	}
+
+	// The failure is remembered for a few seconds rather than probed again
+	if err := os.Remove(root); err != nil {
+		t.Fatal(err)
+	}
+	s.expect(s.do(newCall("GET", "/readyz")), http.StatusServiceUnavailable)
+}
+
+// countingStore counts the objects written
+type countingStore struct {
+	storage.Storage
+	puts atomic.Int32
+}
+
+func (s *countingStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	s.puts.Add(1)
+	return s.Storage.Put(ctx, key, r, size, contentType)
+}
+
+func TestStorageCheckCached(t *testing.T) {
+	store := &countingStore{Storage: storage.NewLocal(t.TempDir(), "/uploads")}
+	check := handler.StorageCheck(store)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := check.Check(context.Background()); err != nil {
+				t.Errorf("Check() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := store.puts.Load(); n != 1 {
+		t.Fatalf("storage probed %d times, want once", n)
+	}
+
+	// A cancelled probe is not remembered
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	check = handler.StorageCheck(store)
+	check.Check(ctx)
+	if err := check.Check(context.Background()); err != nil {
+		t.Fatalf("Check() after a cancelled probe error = %v", err)
+	}
+	if n := store.puts.Load(); n != 3 {
+		t.Fatalf("storage probed %d times, want the cancelled probe repeated", n)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	s := newServer(t)
+	w := s.expect(s.do(newCall("GET", "/version")), http.StatusOK)
+
+	var info struct{ Version string }
+	data(t, w, &info)
+	if info.Version != "test" {
+		t.Fatalf("version = %q, want %q", info.Version, "test")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	s := newServer(t)
+	s.expect(s.do(newCall("GET", "/healthz")), http.StatusOK)
+
+	w := s.expect(s.do(newCall("GET", "/metrics")), http.StatusOK)
+	if !strings.Contains(w.Body.String(), "http_requests_total") {
+		t.Fatalf("metrics do not count requests:\n%s", w.Body.String())
+	}
+}
@@ -0,0 +1,259 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"portfolio/media"
+	"portfolio/model"
+	"portfolio/publicurl"
+	"portfolio/repository"
+	"portfolio/storage"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	formatter "github.com/ivanauliaa/response-formatter"
+	"golang.org/x/sync/singleflight"
+)
+
+// Upload prefixes that may be transformed
+var imageEntities = map[string]bool{
+	"blobs":      true,
+	"portfolio":  true,
+	"experience": true,
+	"skills":     true,
+	"users":      true,
+}
+
+// Transformed images are addressed by their parameters and uploads are
+// never overwritten in place, so clients may cache them forever
+const transformCacheControl = "public, max-age=31536000, immutable"
+
+// TransformImage serves /img/:entity/:file resized, cropped and converted
+// as described by the w, h, fit and fmt query parameters. Requests must
+// carry a sig produced by SignImageURL so that clients cannot make the
+// server render arbitrary sizes. Results are cached below cacheDir, and a
+// cached render is only served while its source is still stored.
+func TransformImage(store storage.Storage, signingKey, cacheDir string) gin.HandlerFunc {
+	secret := []byte(signingKey)
+	var renders singleflight.Group
+
+	return func(c *gin.Context) {
+		entity, file := c.Param("entity"), c.Param("file")
+		if !imageEntities[entity] || strings.HasPrefix(file, ".") {
+			respondError(c, &model.NotFoundError{Resource: "image", ID: entity + "/" + file}, "")
+			return
+		}
+		key := entity + "/" + file
+
+		t, err := media.ParseTransform(c.Request.URL.Query())
+		if err != nil {
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, err.Error())
+			return
+		}
+		if !media.Verify(secret, key, t, c.Query("sig")) {
+			respondError(c, &model.ForbiddenError{Message: "Invalid image signature"}, "")
+			return
+		}
+
+		format := t.Format
+		if format == "" {
+			format = media.FallbackExt(key)
+		}
+
+		sum := sha256.Sum256([]byte(key + "?" + t.Canonical()))
+		name := hex.EncodeToString(sum[:])
+		etag := strconv.Quote(name[:32])
+		if c.GetHeader("If-None-Match") == etag {
+			c.Header("ETag", etag)
+			c.Header("Cache-Control", transformCacheControl)
+			c.AbortWithStatus(http.StatusNotModified)
+			return
+		}
+
+		cached := filepath.Join(cacheDir, name[:2], name+format)
+		if _, err := os.Stat(cached); err == nil {
+			// The source may have been deleted or collected since
+			_, err := store.Stat(c.Request.Context(), key)
+			if errors.Is(err, storage.ErrNotFound) {
+				os.Remove(cached)
+				respondError(c, &model.NotFoundError{Resource: "image", ID: key}, "")
+				return
+			}
+			if err != nil {
+				slog.WarnContext(c.Request.Context(), "Error checking image source, serving cached render", "key", key, "error", err)
+			}
+			// Pruning removes the renders that were not served for longest
+			now := time.Now()
+			os.Chtimes(cached, now, now)
+		} else {
+			// Concurrent requests for the same rendition share one render
+			ctx := context.WithoutCancel(c.Request.Context())
+			_, err, _ := renders.Do(cached, func() (interface{}, error) {
+				return nil, renderImage(ctx, store, key, t, format, cached)
+			})
+			var badType *media.UnsupportedTypeError
+			if errors.Is(err, storage.ErrNotFound) {
+				respondError(c, &model.NotFoundError{Resource: "image", ID: key}, "")
+				return
+			}
+			if errors.As(err, &badType) {
+				respondError(c, err, "")
+				return
+			}
+			if err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error transforming image", "key", key, "error", err)
+				writeProblem(c, http.StatusInternalServerError, codeInternal, "Failed to transform image")
+				return
+			}
+		}
+
+		c.Header("ETag", etag)
+		c.Header("Cache-Control", transformCacheControl)
+		c.File(cached)
+	}
+}
+
+// renderImage transforms the upload stored under key and writes the result
+// to dst, going through a temporary file so readers never see half of it
+func renderImage(ctx context.Context, store storage.Storage, key string, t media.Transform, format, dst string) error {
+	src, err := store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// Blobs also hold gallery documents and videos, which are not decoded
+	r := bufio.NewReaderSize(src, media.SniffLen)
+	head, err := r.Peek(media.SniffLen)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if _, err := media.Sniff(head); err != nil {
+		return err
+	}
+
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return fmt.Errorf("decoding %s: %w", key, err)
+	}
+	data, err := media.Encode(t.Apply(img), format)
+	if err != nil {
+		return fmt.Errorf("encoding %s: %w", key, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".render-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// PruneImageCache removes renders below dir that were not served for
+// maxAge, then the least recently served ones until the cache holds at most
+// maxBytes. Zero limits are not enforced. It returns how many files were
+// removed and their size.
+func PruneImageCache(dir string, maxBytes int64, maxAge time.Duration) (int, int64, error) {
+	type render struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var renders []render
+	var total int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		renders = append(renders, render{path, info.Size(), info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	sort.Slice(renders, func(i, j int) bool { return renders[i].modTime.Before(renders[j].modTime) })
+	cutoff := time.Now().Add(-maxAge)
+	removed, freed := 0, int64(0)
+	for _, r := range renders {
+		expired := maxAge > 0 && r.modTime.Before(cutoff)
+		full := maxBytes > 0 && total-freed > maxBytes
+		if !expired && !full {
+			break
+		}
+		if err := os.Remove(r.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return removed, freed, err
+		}
+		removed++
+		freed += r.size
+	}
+	return removed, freed, nil
+}
+
+// SignImageURL returns a signed /img URL for the transformation described
+// by the query string, for logged in users building pages
+func SignImageURL(db repository.DB, jwtKey, signingKey string) gin.HandlerFunc {
+	secret := []byte(signingKey)
+
+	return func(c *gin.Context) {
+		if !checkUserLogin(c, jwtKey, db) {
+			return
+		}
+
+		entity, file := c.Param("entity"), c.Param("file")
+		if !imageEntities[entity] {
+			respondError(c, &model.NotFoundError{Resource: "image", ID: entity + "/" + file}, "")
+			return
+		}
+		key := entity + "/" + file
+
+		t, err := media.ParseTransform(c.Request.URL.Query())
+		if err != nil {
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, err.Error())
+			return
+		}
+
+		query := t.Query()
+		query.Set("sig", media.Sign(secret, key, t))
+
+		c.JSON(http.StatusOK, formatter.SuccessResponse(map[string]string{
+			"url": publicurl.Asset(c, "/img/"+key+"?"+query.Encode()),
+		}))
+	}
+}
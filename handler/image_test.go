@@ -0,0 +1,154 @@
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"image/png"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"portfolio/handler"
+	"portfolio/model"
+)
+
+// signedImage returns the signed path of the user's avatar scaled by query
+func (s *server) signedImage(token, query string) string {
+	s.t.Helper()
+	w := s.expect(s.do(newCall("GET", "/api/v1/user").auth(token)), http.StatusOK)
+	var user struct{ Image struct{ Src string } }
+	data(s.t, w, &user)
+
+	file := path.Base(user.Image.Src)
+	w = s.expect(s.do(newCall("GET", "/api/v1/img/sign/blobs/"+file+"?"+query).auth(token)), http.StatusOK)
+	var signed struct{ URL string }
+	data(s.t, w, &signed)
+	if !strings.HasPrefix(signed.URL, testBaseURL+"/img/blobs/"+file+"?") {
+		s.t.Fatalf("signed URL = %q, want it for %s", signed.URL, file)
+	}
+	return strings.TrimPrefix(signed.URL, testBaseURL)
+}
+
+func TestSignImageURL(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+	s.signedImage(token, "w=4")
+
+	s.expectProblem(s.do(newCall("GET", "/api/v1/img/sign/blobs/a.png?w=4")), http.StatusUnauthorized, "unauthorized")
+	s.expectProblem(s.do(newCall("GET", "/api/v1/img/sign/secrets/a.png?w=4").auth(token)), http.StatusNotFound, model.CodeNotFound)
+	s.expectProblem(s.do(newCall("GET", "/api/v1/img/sign/blobs/a.png").auth(token)), http.StatusBadRequest, "bad_request")
+	s.expectProblem(s.do(newCall("GET", "/api/v1/img/sign/blobs/a.png?w=4&fit=stretch").auth(token)), http.StatusBadRequest, "bad_request")
+}
+
+func TestTransformImage(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+	target := s.signedImage(token, "w=4&fmt=png")
+
+	w := s.expect(s.do(newCall("GET", target)), http.StatusOK)
+	if ct := w.Header().Get("Content-Type"); ct != "image/png" {
+		t.Fatalf("Content-Type = %q, want image/png", ct)
+	}
+	img, err := png.Decode(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if width := img.Bounds().Dx(); width != 4 {
+		t.Fatalf("width = %d, want 4", width)
+	}
+
+	// The rendition is cached and revalidated by its ETag
+	etag := w.Header().Get("ETag")
+	s.expect(s.do(newCall("GET", target).set("If-None-Match", etag)), http.StatusNotModified)
+	s.expect(s.do(newCall("GET", target)), http.StatusOK)
+
+	u, _ := url.Parse(target)
+	query := u.Query()
+	query.Set("w", "6")
+	s.expectProblem(s.do(newCall("GET", u.Path+"?"+query.Encode())), http.StatusForbidden, model.CodeForbidden)
+
+	// A valid signature for a file that was never stored
+	w = s.expect(s.do(newCall("GET", "/api/v1/img/sign/blobs/missing.png?w=4").auth(token)), http.StatusOK)
+	var signed struct{ URL string }
+	data(t, w, &signed)
+	s.expectProblem(s.do(newCall("GET", strings.TrimPrefix(signed.URL, testBaseURL))), http.StatusNotFound, model.CodeNotFound)
+	s.expectProblem(s.do(newCall("GET", "/img/secrets/a.png?"+u.RawQuery)), http.StatusNotFound, model.CodeNotFound)
+
+	// Gallery documents live under blobs too but are not images
+	pdf := []byte("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n1 0 obj\n<<>>\nendobj\ntrailer\n<<>>\n%%EOF\n")
+	if err := s.store.Put(context.Background(), "blobs/doc.pdf", bytes.NewReader(pdf), int64(len(pdf)), "application/pdf"); err != nil {
+		t.Fatal(err)
+	}
+	w = s.expect(s.do(newCall("GET", "/api/v1/img/sign/blobs/doc.pdf?w=4").auth(token)), http.StatusOK)
+	data(t, w, &signed)
+	s.expectProblem(s.do(newCall("GET", strings.TrimPrefix(signed.URL, testBaseURL))), http.StatusUnsupportedMediaType, "unsupported_media_type")
+
+	// The cached render goes away with its source
+	w = s.expect(s.do(newCall("GET", "/api/v1/user").auth(token)), http.StatusOK)
+	var user struct{ ID string }
+	data(t, w, &user)
+	s.expect(s.do(newCall("DELETE", "/api/v1/user").auth(token).multipart(url.Values{"user_id": {user.ID}}, nil)), http.StatusOK)
+	s.expectProblem(s.do(newCall("GET", target)), http.StatusNotFound, model.CodeNotFound)
+}
+
+func TestPruneImageCache(t *testing.T) {
+	now := time.Now()
+	renders := []struct {
+		name string
+		size int
+		age  time.Duration
+	}{
+		{"ab/old.png", 10, 48 * time.Hour},
+		{"ab/stale.png", 10, 3 * time.Hour},
+		{"cd/recent.png", 10, 2 * time.Hour},
+		{"cd/fresh.png", 10, time.Minute},
+	}
+
+	tests := []struct {
+		name     string
+		maxBytes int64
+		maxAge   time.Duration
+		kept     []string
+	}{
+		{"no limits", 0, 0, []string{"ab/old.png", "ab/stale.png", "cd/recent.png", "cd/fresh.png"}},
+		{"by age", 0, 24 * time.Hour, []string{"ab/stale.png", "cd/recent.png", "cd/fresh.png"}},
+		{"by size", 25, 0, []string{"cd/recent.png", "cd/fresh.png"}},
+		{"by age and size", 15, 24 * time.Hour, []string{"cd/fresh.png"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, r := range renders {
+				p := filepath.Join(dir, r.name)
+				os.MkdirAll(filepath.Dir(p), 0o755)
+				if err := os.WriteFile(p, make([]byte, r.size), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				os.Chtimes(p, now.Add(-r.age), now.Add(-r.age))
+			}
+
+			removed, freed, err := handler.PruneImageCache(dir, tt.maxBytes, tt.maxAge)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := len(renders) - len(tt.kept)
+			if removed != want || freed != int64(want*10) {
+				t.Fatalf("removed %d files of %d bytes, want %d of %d", removed, freed, want, want*10)
+			}
+			for _, name := range tt.kept {
+				if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+					t.Errorf("%s was removed", name)
+				}
+			}
+		})
+	}
+
+	if _, _, err := handler.PruneImageCache(filepath.Join(t.TempDir(), "missing"), 1, time.Hour); err != nil {
+		t.Fatalf("pruning a missing cache: %v", err)
+	}
+}
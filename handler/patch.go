@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"portfolio/model"
+	"reflect"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/gin-gonic/gin"
+)
+
+// Media types accepted by the PATCH routes
+const (
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
+)
+
+// Stable error codes for patch documents that cannot be applied
+const (
+	codeUnsupportedMediaType = "unsupported_media_type"
+	codeInvalidPatch         = "invalid_patch"
+)
+
+// applyPatch applies the request body to doc, which holds the editable
+// fields of the stored resource. The body is a JSON Merge Patch (RFC 7386)
+// or a JSON Patch (RFC 6902) depending on its Content-Type. A field set to
+// null or removed ends up as its zero value, so it is cleared. It aborts the
+// request and returns false when the patch cannot be applied.
+func applyPatch(c *gin.Context, doc interface{}) bool {
+	original, err := json.Marshal(doc)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error encoding patch target", "error", err)
+		writeProblem(c, http.StatusInternalServerError, codeInternal, "Failed to prepare resource for patching")
+		return false
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		writeProblem(c, http.StatusBadRequest, codeBadRequest, "Error reading request body")
+		return false
+	}
+
+	var patched []byte
+	switch c.ContentType() {
+	case mergePatchContentType:
+		patched, err = jsonpatch.MergePatch(original, body)
+		if err != nil {
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Malformed merge patch document")
+			return false
+		}
+	case jsonPatchContentType:
+		patch, err := jsonpatch.DecodePatch(body)
+		if err != nil {
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Malformed JSON patch document")
+			return false
+		}
+		patched, err = patch.Apply(original)
+		if errors.Is(err, jsonpatch.ErrTestFailed) {
+			writeProblem(c, http.StatusConflict, model.CodeConflict, err.Error())
+			return false
+		}
+		if err != nil {
+			writeProblem(c, http.StatusUnprocessableEntity, codeInvalidPatch, err.Error())
+			return false
+		}
+	default:
+		c.Header("Accept-Patch", mergePatchContentType+", "+jsonPatchContentType)
+		writeProblem(c, http.StatusUnsupportedMediaType, codeUnsupportedMediaType, "Content-Type must be "+mergePatchContentType+" or "+jsonPatchContentType)
+		return false
+	}
+
+	// Start from a blank document so removed fields are cleared
+	if err := decodePatched(patched, doc); err != nil {
+		validationErrorResponse(c, model.ValidationErrors{patchFieldError(err)})
+		return false
+	}
+	return true
+}
+
+func decodePatched(data []byte, doc interface{}) error {
+	v := reflect.ValueOf(doc).Elem()
+	v.Set(reflect.Zero(v.Type()))
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(doc)
+}
+
+// patchFieldError turns a decoding failure of the patched document into the
+// field that caused it
+func patchFieldError(err error) model.FieldError {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return model.FieldError{Field: typeErr.Field, Code: model.CodeInvalid, Message: "must be a " + typeErr.Type.String()}
+	}
+
+	// encoding/json has no typed error for unknown fields
+	const unknownPrefix = "json: unknown field "
+	if msg := err.Error(); strings.HasPrefix(msg, unknownPrefix) {
+		field := strings.Trim(strings.TrimPrefix(msg, unknownPrefix), `"`)
+		return model.FieldError{Field: field, Code: model.CodeInvalid, Message: "cannot be changed with PATCH"}
+	}
+
+	return model.FieldError{Code: model.CodeInvalid, Message: "patched document is not an object"}
+}
@@ -1,115 +1,108 @@
 package handler
 
 import (
-	"database/sql"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
-	"path/filepath"
 	"portfolio/model"
+	"portfolio/repository"
+	"portfolio/storage"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	formatter "github.com/ivanauliaa/response-formatter"
 )
 
-func AddPortfolioWithSkills(db *sql.DB, jwtKey string) gin.HandlerFunc {
+func AddPortfolioWithSkills(db repository.DB, store storage.Storage, jwtKey string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Validate JWT token
 		authorizationHeader := c.GetHeader("Authorization")
 		if authorizationHeader == "" {
-			c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Authorization header not provided"))
+			writeProblem(c, http.StatusUnauthorized, codeUnauthorized, "Authorization header not provided")
 			return
 		}
 
 		tokenString := strings.TrimPrefix(authorizationHeader, "Bearer ")
 		if tokenString == "" {
-			c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Token not provided"))
+			writeProblem(c, http.StatusUnauthorized, codeUnauthorized, "Token not provided")
 			return
 		}
 
-		_, err := ValidateToken(tokenString, jwtKey, db)
+		userID, err := ValidateToken(c.Request.Context(), tokenString, jwtKey, db)
 		if err != nil {
-			log.Printf("Error validating token: %v", err)
-			c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Invalid token"))
+			slog.ErrorContext(c.Request.Context(), "Error validating token", "error", err)
+			rejectToken(c, err)
 			return
 		}
+		identify(c, userID)
 
 		// Parse form data
-		title := c.PostForm("title")
-		subtitle := c.PostForm("subtitle")
-		content := c.PostForm("content")
-		file, err := c.FormFile("image")
-		if err != nil {
-			log.Printf("Error retrieving form file: %v", err)
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Image file is required"))
-			return
-		}
-
-		// Generate unique ID for the portfolio
-		portfolioID := uuid.New().String()
-
-		// Save the image in the uploads/portfolio directory
-		newFilename := uuid.New().String() + filepath.Ext(file.Filename)
-		if err := c.SaveUploadedFile(file, "uploads/portfolio/"+newFilename); err != nil {
-			log.Printf("Error saving uploaded file: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to save image"))
-			return
-		}
-
-		status := c.PostForm("status")
-		dateProjectStr := c.PostForm("date_project")
-
-		//parse dateProject
-		dateProject, err := time.Parse("2006-01-02", dateProjectStr)
-
-		if err != nil {
-			log.Printf("Error parsing start date: %v\n", err)
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Invalid start date format, expected yyyy-mm-dd"))
-			return
-		}
+		var errs model.ValidationErrors
+		dateProject := parseDateField(&errs, "date_project", c.PostForm("date_project"))
+		skillIDs := c.PostFormArray("skill_ids")
 
-		// Create portfolio instance
 		portfolio := model.Portfolio{
-			ID:          portfolioID,
-			Title:       title,
-			Subtitle:    subtitle,
-			Image:       newFilename,
-			Content:     content,
-			Status:      status,
+			ID:          uuid.New().String(),
+			Title:       c.PostForm("title"),
+			Subtitle:    c.PostForm("subtitle"),
+			Content:     c.PostForm("content"),
+			Status:      c.PostForm("status"),
 			DateProject: dateProject,
 		}
 
-		// Insert portfolio into database
-		if err := model.InsertPortfolio(db, &portfolio); err != nil {
-			log.Printf("Error inserting portfolio into database: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to insert portfolio"))
+		// Validate every field before anything is written
+		errs = errs.Merge(model.Validate(&portfolio))
+		errs = append(errs, model.RequireNonEmpty("skill_ids", skillIDs)...)
+		if len(errs) > 0 {
+			validationErrorResponse(c, errs)
 			return
 		}
 
-		// Retrieve skill IDs from form data
-		skillIDs := c.PostFormArray("skill_ids")
-		if len(skillIDs) == 0 {
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("At least one skill ID is required"))
+		// The image comes inline or as a finished resumable upload
+		upload, ok := imageFromRequest(c, db, store, "portfolio", userID)
+		if !ok {
 			return
 		}
-
-		// Add skills to the portfolio
-		if err := portfolio.AddSkills(db, skillIDs); err != nil {
-			log.Printf("Error adding skills to portfolio: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to add skills to portfolio"))
+		if upload == nil {
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Image file or upload_id is required")
 			return
 		}
-
-		//add experience
+		portfolio.Image = upload.Key
+		portfolio.ImageWidth = upload.Width
 		experienceID := c.PostForm("experience_id")
 
-		if err := portfolio.AddExperience(db, experienceID); err != nil {
-			log.Printf("Error adding experience to portfolio: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to add experience to portfolio"))
+		// Write the portfolio, its relations and its image as one unit
+		err = db.RunInTx(c.Request.Context(), func(tx repository.Tx) error {
+			// Store the image, or reuse identical content already stored
+			if err := stageUpload(c.Request.Context(), db, tx, store, upload); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error saving uploaded file", "error", err)
+				return err
+			}
+
+			// Insert portfolio into database
+			if err := tx.Portfolios().Insert(c.Request.Context(), &portfolio); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error inserting portfolio into database", "error", err)
+				return err
+			}
+
+			// Add skills to the portfolio
+			if err := tx.Portfolios().AddSkills(c.Request.Context(), portfolio.ID, skillIDs); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error adding skills to portfolio", "error", err)
+				return err
+			}
+
+			//add experience
+			if experienceID != "" {
+				if err := tx.Portfolios().SetExperience(c.Request.Context(), portfolio.ID, experienceID); err != nil {
+					slog.ErrorContext(c.Request.Context(), "Error adding experience to portfolio", "error", err)
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			respondError(c, err, "Failed to create portfolio")
 			return
 		}
 
@@ -119,14 +112,14 @@ func AddPortfolioWithSkills(db *sql.DB, jwtKey string) gin.HandlerFunc {
 			"title":        portfolio.Title,
 			"subtitle":     portfolio.Subtitle,
 			"content":      portfolio.Content,
-			"image":        newFilename,
+			"image":        imageSet(c, store, "portfolio", portfolio.Image, portfolio.ImageWidth),
 			"skills":       skillIDs,
 			"date_project": dateProject,
 		}))
 	}
 }
 
-func GetPortfolioAndSkillsPaginated(db *sql.DB) gin.HandlerFunc {
+func GetPortfolioAndSkillsPaginated(db repository.DB, store storage.Storage) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Pagination parameters
 		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
@@ -134,30 +127,24 @@ func GetPortfolioAndSkillsPaginated(db *sql.DB) gin.HandlerFunc {
 		offset := (page - 1) * limit
 
 		// Retrieve portfolios with pagination
-		portfolios, err := model.GetPortfoliosPaginated(db, offset, limit)
+		portfolios, err := db.Portfolios().List(c.Request.Context(), offset, limit)
 		if err != nil {
-			log.Printf("Error retrieving portfolios: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to retrieve portfolios"))
+			slog.ErrorContext(c.Request.Context(), "Error retrieving portfolios", "error", err)
+			respondError(c, err, "Failed to retrieve portfolios")
 			return
 		}
-		// Include server URL in the image link
-		scheme := "http"
-		if c.Request.TLS != nil {
-			scheme = "https"
-		}
-
 		// Retrieve skills for each portfolio and include image paths
 		for i, portfolio := range portfolios {
-			skills, err := model.GetSkillsByPortfolioID(db, portfolio.ID)
+			skills, err := db.Portfolios().Skills(c.Request.Context(), portfolio.ID)
 			if err != nil {
-				log.Printf("Error retrieving skills for portfolio %s: %v", portfolio.ID, err)
-				c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to retrieve skills for portfolio"))
+				slog.ErrorContext(c.Request.Context(), "Error retrieving skills for portfolio", "portfolio_id", portfolio.ID, "error", err)
+				respondError(c, err, "Failed to retrieve skills for portfolio")
 				return
 			}
 
 			// Append the server path to each skill's image
 			for j := range skills {
-				skills[j].Image = scheme + "://" + c.Request.Host + "/uploads/skills/" + skills[j].Image
+				skills[j].ImageSet = imageSet(c, store, "skills", skills[j].Image, skills[j].ImageWidth)
 			}
 
 			portfolios[i].Skills = skills
@@ -165,16 +152,16 @@ func GetPortfolioAndSkillsPaginated(db *sql.DB) gin.HandlerFunc {
 
 		// retrieve experience for each portfolio
 		for i, portfolio := range portfolios {
-			experience, err := model.GetExperienceByPortfolioID(db, portfolio.ID)
+			experience, err := db.Portfolios().Experience(c.Request.Context(), portfolio.ID)
 
 			if err != nil {
-				log.Printf("Error retrieving experience for portfolio %s: %v", portfolio.ID, err)
-				c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to retrieve experience for portfolio"))
+				slog.ErrorContext(c.Request.Context(), "Error retrieving experience for portfolio", "portfolio_id", portfolio.ID, "error", err)
+				respondError(c, err, "Failed to retrieve experience for portfolio")
 				return
 			}
 
 			// Append the server path to each experience image
-			experience.Image = scheme + "://" + c.Request.Host + "/uploads/experience/" + experience.Image
+			experience.ImageSet = imageSet(c, store, "experience", experience.Image, experience.ImageWidth)
 
 			portfolios[i].Experience = experience
 		}
@@ -188,7 +175,7 @@ func GetPortfolioAndSkillsPaginated(db *sql.DB) gin.HandlerFunc {
 
 		// Append the server path to each portfolio's image
 		for i := range portfolios {
-			portfolios[i].Image = scheme + "://" + c.Request.Host + "/uploads/portfolio/" + portfolios[i].Image
+			portfolios[i].ImageSet = imageSet(c, store, "portfolio", portfolios[i].Image, portfolios[i].ImageWidth)
 		}
 
 		// Return success response with portfolios and their skills
@@ -198,65 +185,43 @@ func GetPortfolioAndSkillsPaginated(db *sql.DB) gin.HandlerFunc {
 	}
 }
 
-func GetPortfolioAndSkillsByID(db *sql.DB) gin.HandlerFunc {
+func GetPortfolioAndSkillsByID(db repository.DB, store storage.Storage) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		portfolioID := c.Param("id")
 		if portfolioID == "" {
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Portfolio ID is required"))
-			return
-		}
-
-		portfolio, err := model.GetPortfolioByID(db, portfolioID)
-		if err != nil {
-			log.Printf("Error retrieving portfolio: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to retrieve portfolio"))
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Portfolio ID is required")
 			return
 		}
 
-		skills, err := model.GetSkillsByPortfolioID(db, portfolioID)
+		portfolio, err := db.Portfolios().Get(c.Request.Context(), portfolioID)
 		if err != nil {
-			log.Printf("Error retrieving skills for portfolio %s: %v", portfolioID, err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to retrieve skills for portfolio"))
+			slog.ErrorContext(c.Request.Context(), "Error retrieving portfolio", "error", err)
+			respondError(c, err, "Failed to retrieve portfolio")
 			return
 		}
+		setETag(c, portfolio.Version)
 
-		// Include server URL in the image links
-		scheme := "http"
-		if c.Request.TLS != nil {
-			scheme = "https"
+		// Skills, experience and gallery were loaded with the portfolio
+		portfolio.ImageSet = imageSet(c, store, "portfolio", portfolio.Image, portfolio.ImageWidth)
+		for i := range portfolio.Skills {
+			portfolio.Skills[i].ImageSet = imageSet(c, store, "skills", portfolio.Skills[i].Image, portfolio.Skills[i].ImageWidth)
 		}
-		portfolio.Image = scheme + "://" + c.Request.Host + "/uploads/portfolio/" + portfolio.Image
-		for i := range skills {
-			skills[i].Image = scheme + "://" + c.Request.Host + "/uploads/skills/" + skills[i].Image
+		if experience := portfolio.Experience; experience != nil && experience.ID != "" {
+			experience.ImageSet = imageSet(c, store, "experience", experience.Image, experience.ImageWidth)
+		} else {
+			portfolio.Experience = nil
 		}
-
-		portfolio.Skills = skills
-
-		// Retrieve experience for the portfolio
-		experience, err := model.GetExperienceByPortfolioID(db, portfolioID)
-		if err != nil {
-			log.Printf("Error retrieving experience for portfolio %s: %v", portfolioID, err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to retrieve experience for portfolio"))
-			return
-		}
-
-		if experience.ID == "" {
-			c.JSON(http.StatusOK, formatter.SuccessResponse(map[string]interface{}{
-				"portfolio": portfolio,
-			}))
-			return
+		for i := range portfolio.Media {
+			setMediaURLs(c, store, &portfolio.Media[i])
 		}
 
-		// Include server URL in the experience image link
-		experience.Image = scheme + "://" + c.Request.Host + "/uploads/experience/" + experience.Image
-
 		c.JSON(http.StatusOK, formatter.SuccessResponse(map[string]interface{}{
 			"portfolio": portfolio,
 		}))
 	}
 }
 
-func DeleteSkillWithRelationsHandler(db *sql.DB, jwtKey string) gin.HandlerFunc {
+func DeleteSkillWithRelationsHandler(db repository.DB, jwtKey string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if !checkUserLogin(c, jwtKey, db) {
 			return
@@ -264,28 +229,48 @@ func DeleteSkillWithRelationsHandler(db *sql.DB, jwtKey string) gin.HandlerFunc
 
 		portfolioID := c.Param("id")
 		if portfolioID == "" {
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Portfolio ID is required"))
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Portfolio ID is required")
 			return
 		}
 
 		skillID := c.PostForm("skill_id")
 		if skillID == "" {
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Skill ID is required"))
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Skill ID is required")
+			return
+		}
+
+		portfolio, err := db.Portfolios().Get(c.Request.Context(), portfolioID)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error retrieving portfolio", "error", err)
+			respondError(c, err, "Failed to retrieve portfolio")
 			return
 		}
 
-		err := model.DeleteSkillAndPortfolioRelations(db, skillID, portfolioID)
+		version, ok := requireIfMatch(c, portfolio.Version)
+		if !ok {
+			return
+		}
+
+		err = db.RunInTx(c.Request.Context(), func(tx repository.Tx) error {
+			if err := tx.Portfolios().RemoveSkill(c.Request.Context(), portfolio.ID, skillID); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error deleting skill with relations", "error", err)
+				return err
+			}
+			portfolio.Version, err = tx.Portfolios().Touch(c.Request.Context(), portfolio.ID, version)
+			return err
+		})
 		if err != nil {
-			log.Printf("Error deleting skill with relations: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to delete skill from portfolio"))
+			respondError(c, err, "Failed to delete skill from portfolio")
 			return
 		}
 
+		setETag(c, portfolio.Version)
+
 		c.JSON(http.StatusOK, formatter.SuccessResponse("Skill successfully deleted from portfolio"))
 	}
 }
 
-func AddSkillsToPortfolio(db *sql.DB, jwtKey string) gin.HandlerFunc {
+func AddSkillsToPortfolio(db repository.DB, jwtKey string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if !checkUserLogin(c, jwtKey, db) {
 			return
@@ -293,59 +278,80 @@ func AddSkillsToPortfolio(db *sql.DB, jwtKey string) gin.HandlerFunc {
 
 		portfolioID := c.Param("id")
 		if portfolioID == "" {
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Portfolio ID is required"))
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Portfolio ID is required")
 			return
 		}
 
 		// Retrieve skill IDs from form input
 		skillIDs := c.PostFormArray("skill_ids")
 		if len(skillIDs) == 0 {
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Skill IDs are required"))
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Skill IDs are required")
 			return
 		}
 
-		portfolio, err := model.GetPortfolioByID(db, portfolioID)
+		portfolio, err := db.Portfolios().Get(c.Request.Context(), portfolioID)
 		if err != nil {
-			log.Printf("Error retrieving portfolio: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to retrieve portfolio"))
+			slog.ErrorContext(c.Request.Context(), "Error retrieving portfolio", "error", err)
+			respondError(c, err, "Failed to retrieve portfolio")
 			return
 		}
 
-		if err := portfolio.AddSkills(db, skillIDs); err != nil {
-			log.Printf("Error adding skills to portfolio: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to add skills to portfolio"))
+		version, ok := requireIfMatch(c, portfolio.Version)
+		if !ok {
 			return
 		}
 
+		err = db.RunInTx(c.Request.Context(), func(tx repository.Tx) error {
+			if err := tx.Portfolios().AddSkills(c.Request.Context(), portfolio.ID, skillIDs); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error adding skills to portfolio", "error", err)
+				return err
+			}
+			portfolio.Version, err = tx.Portfolios().Touch(c.Request.Context(), portfolio.ID, version)
+			return err
+		})
+		if err != nil {
+			respondError(c, err, "Failed to add skills to portfolio")
+			return
+		}
+
+		setETag(c, portfolio.Version)
+
 		c.JSON(http.StatusOK, formatter.SuccessResponse("Skills successfully added to portfolio"))
 	}
 }
 
-func UpdatePortfolioHandler(db *sql.DB, jwtKey string) gin.HandlerFunc {
+func UpdatePortfolioHandler(db repository.DB, store storage.Storage, jwtKey string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if !checkUserLogin(c, jwtKey, db) {
+		userID, ok := authenticate(c, jwtKey, db)
+		if !ok {
 			return
 		}
 
 		portfolioID := c.Param("id")
 		if portfolioID == "" {
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Portfolio ID is required"))
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Portfolio ID is required")
 			return
 		}
 
 		if err := c.Request.ParseForm(); err != nil {
-			log.Printf("Error parsing form data: %v", err)
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Error parsing form data"))
+			slog.ErrorContext(c.Request.Context(), "Error parsing form data", "error", err)
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Error parsing form data")
 			return
 		}
 
 		// Retrieve existing portfolio to update
-		existingPortfolio, err := model.GetPortfolioByID(db, portfolioID)
+		existingPortfolio, err := db.Portfolios().Get(c.Request.Context(), portfolioID)
 		if err != nil {
-			log.Printf("Error retrieving existing portfolio: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to retrieve portfolio"))
+			slog.ErrorContext(c.Request.Context(), "Error retrieving existing portfolio", "error", err)
+			respondError(c, err, "Failed to retrieve portfolio")
+			return
+		}
+
+		version, ok := requireIfMatch(c, existingPortfolio.Version)
+		if !ok {
 			return
 		}
+		existingPortfolio.Version = version
 
 		// Update portfolio instance with form data if provided
 		title := c.PostForm("title")
@@ -363,80 +369,167 @@ func UpdatePortfolioHandler(db *sql.DB, jwtKey string) gin.HandlerFunc {
 			existingPortfolio.Content = content
 		}
 
-		// Handle file upload for image if provided
-		file, header, err := c.Request.FormFile("image")
-		if err == nil {
-			// Generate new filename and save the file
-			newFilename := uuid.New().String() + filepath.Ext(header.Filename)
-			if err := c.SaveUploadedFile(header, "./uploads/portfolio/"+newFilename); err != nil {
-				log.Printf("Error saving uploaded file: %v", err)
-				c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to save image"))
-				return
+		status := c.PostForm("status")
+		if status != "" && status != existingPortfolio.Status {
+			existingPortfolio.Status = status
+		}
+
+		dateProjectStr := c.PostForm("date_project")
+		var errs model.ValidationErrors
+		if dateProjectStr != "" {
+			existingPortfolio.DateProject = parseDateField(&errs, "date_project", dateProjectStr)
+		}
+
+		// Validate the merged portfolio before anything is written
+		errs = errs.Merge(model.Validate(existingPortfolio))
+		if len(errs) > 0 {
+			validationErrorResponse(c, errs)
+			return
+		}
+
+		experienceID := c.PostForm("experience_id")
+
+		// Handle file upload for image if provided, inline or resumable
+		upload, ok := imageFromRequest(c, db, store, "portfolio", userID)
+		if !ok {
+			return
+		}
+
+		// Write the portfolio, its relations and its image as one unit
+		err = db.RunInTx(c.Request.Context(), func(tx repository.Tx) error {
+			if upload != nil {
+				// Stage the new image and release the old one
+				if err := stageUpload(c.Request.Context(), db, tx, store, upload); err != nil {
+					slog.ErrorContext(c.Request.Context(), "Error saving uploaded file", "error", err)
+					return err
+				}
+				if err := releaseUpload(c.Request.Context(), db, tx, store, "portfolio", existingPortfolio.Image); err != nil {
+					slog.ErrorContext(c.Request.Context(), "Error releasing image", "error", err)
+					return err
+				}
+				existingPortfolio.Image = upload.Key
+				existingPortfolio.ImageWidth = upload.Width
 			}
 
-			// Delete old image if new one is uploaded
-			if existingPortfolio.Image != "" {
-				oldImagePath := "./uploads/portfolio/" + existingPortfolio.Image
-				if err := os.Remove(oldImagePath); err != nil {
-					if !os.IsNotExist(err) {
-						log.Printf("Error deleting old image file: %v", err)
-						c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to delete old image"))
-						return
-					}
+			// update experience
+			if experienceID != "" {
+				if err := tx.Portfolios().SetExperience(c.Request.Context(), existingPortfolio.ID, experienceID); err != nil {
+					slog.ErrorContext(c.Request.Context(), "Error adding experience to portfolio", "error", err)
+					return err
 				}
 			}
 
-			// Update portfolio image to new filename
-			existingPortfolio.Image = newFilename
-		} else if err != http.ErrMissingFile {
-			log.Printf("Error retrieving image file: %v", err)
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Failed to retrieve image file"))
+			// Update the portfolio in the database only if changes were made
+			if title != "" || subtitle != "" || content != "" || upload != nil || status != "" || dateProjectStr != "" || experienceID != "" {
+				if err := tx.Portfolios().Update(c.Request.Context(), existingPortfolio); err != nil {
+					slog.ErrorContext(c.Request.Context(), "Error updating portfolio", "error", err)
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			respondError(c, err, "Failed to update portfolio")
 			return
 		}
 
-		status := c.PostForm("status")
-		if status != "" && status != existingPortfolio.Status {
-			existingPortfolio.Status = status
+		setETag(c, existingPortfolio.Version)
+
+		c.JSON(http.StatusOK, formatter.SuccessResponse("Portfolio updated successfully"))
+	}
+}
+
+// portfolioPatch holds the portfolio fields a PATCH request may change
+type portfolioPatch struct {
+	Title        string `json:"title"`
+	Subtitle     string `json:"subtitle"`
+	Content      string `json:"content"`
+	Status       string `json:"status"`
+	DateProject  string `json:"date_project"`
+	ExperienceID string `json:"experience_id"`
+}
+
+func PatchPortfolioHandler(db repository.DB, jwtKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkUserLogin(c, jwtKey, db) {
+			return
 		}
 
-		dateProjectStr := c.PostForm("date_project")
-		if dateProjectStr != "" {
-			dateProject, err := time.Parse("2006-01-02", dateProjectStr)
-			if err != nil {
-				log.Printf("Error parsing start date: %v", err)
-				c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Invalid end date format"))
-				return
-			}
-			if dateProject != existingPortfolio.DateProject {
-				existingPortfolio.DateProject = dateProject
-			}
+		portfolioID := c.Param("id")
+		if portfolioID == "" {
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Portfolio ID is required")
+			return
 		}
 
-		// update experience
-		experienceID := c.PostForm("experience_id")
-		if experienceID != "" {
-			if err := existingPortfolio.UpdateExperiencePortfolio(db, experienceID); err != nil {
-				log.Printf("Error adding experience to portfolio: %v", err)
-				c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to add experience to portfolio"))
-				return
-			}
+		existingPortfolio, err := db.Portfolios().Get(c.Request.Context(), portfolioID)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error retrieving existing portfolio", "error", err)
+			respondError(c, err, "Failed to retrieve portfolio")
+			return
 		}
 
-		// Update the portfolio in the database only if changes were made
-		if title != "" || subtitle != "" || content != "" || file != nil || status != "" || dateProjectStr != "" {
-			err = model.UpdatePortfolio(db, existingPortfolio)
-			if err != nil {
-				log.Printf("Error updating portfolio: %v", err)
-				c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to update portfolio"))
-				return
+		version, ok := requireIfMatch(c, existingPortfolio.Version)
+		if !ok {
+			return
+		}
+		existingPortfolio.Version = version
+
+		doc := portfolioPatch{
+			Title:       existingPortfolio.Title,
+			Subtitle:    existingPortfolio.Subtitle,
+			Content:     existingPortfolio.Content,
+			Status:      existingPortfolio.Status,
+			DateProject: existingPortfolio.DateProject.Format(dateLayout),
+		}
+		if existingPortfolio.Experience != nil {
+			doc.ExperienceID = existingPortfolio.Experience.ID
+		}
+		previousExperienceID := doc.ExperienceID
+
+		if !applyPatch(c, &doc) {
+			return
+		}
+
+		var errs model.ValidationErrors
+		existingPortfolio.Title = doc.Title
+		existingPortfolio.Subtitle = doc.Subtitle
+		existingPortfolio.Content = doc.Content
+		existingPortfolio.Status = doc.Status
+		existingPortfolio.DateProject = parseDateField(&errs, "date_project", doc.DateProject)
+
+		// Validate the patched portfolio before anything is written
+		errs = errs.Merge(model.Validate(existingPortfolio))
+		if len(errs) > 0 {
+			validationErrorResponse(c, errs)
+			return
+		}
+
+		err = db.RunInTx(c.Request.Context(), func(tx repository.Tx) error {
+			if doc.ExperienceID != previousExperienceID {
+				if err := tx.Portfolios().SetExperience(c.Request.Context(), existingPortfolio.ID, doc.ExperienceID); err != nil {
+					slog.ErrorContext(c.Request.Context(), "Error updating portfolio experience", "error", err)
+					return err
+				}
 			}
+
+			if err := tx.Portfolios().Update(c.Request.Context(), existingPortfolio); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error updating portfolio", "error", err)
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			respondError(c, err, "Failed to update portfolio")
+			return
 		}
 
+		setETag(c, existingPortfolio.Version)
+
 		c.JSON(http.StatusOK, formatter.SuccessResponse("Portfolio updated successfully"))
 	}
 }
 
-func DeletePortfolioHandler(db *sql.DB, jwtKey string) gin.HandlerFunc {
+func DeletePortfolioHandler(db repository.DB, store storage.Storage, jwtKey string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if !checkUserLogin(c, jwtKey, db) {
 			return
@@ -444,57 +537,75 @@ func DeletePortfolioHandler(db *sql.DB, jwtKey string) gin.HandlerFunc {
 
 		portfolioID := c.Param("id")
 		if portfolioID == "" {
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Portfolio ID is required"))
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Portfolio ID is required")
 			return
 		}
 
-		portfolio, err := model.GetPortfolioByID(db, portfolioID)
+		portfolio, err := db.Portfolios().Get(c.Request.Context(), portfolioID)
 		if err != nil {
-			log.Printf("Error retrieving portfolio: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to retrieve portfolio"))
+			slog.ErrorContext(c.Request.Context(), "Error retrieving portfolio", "error", err)
+			respondError(c, err, "Failed to retrieve portfolio")
 			return
 		}
 
-		err = model.DeletePortfolioAndRelations(db, portfolioID)
-		if err != nil {
-			log.Printf("Error deleting portfolio and its relations: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to delete portfolio and its relations"))
+		version, ok := requireIfMatch(c, portfolio.Version)
+		if !ok {
 			return
 		}
 
-		if portfolio.Image != "" {
-			imagePath := "./uploads/portfolio/" + portfolio.Image
-			if err := os.Remove(imagePath); err != nil {
-				if !os.IsNotExist(err) {
-					log.Printf("Error deleting image file: %v", err)
-					c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to delete portfolio image"))
-					return
+		// The image is only removed once the rows are gone for good
+		err = db.RunInTx(c.Request.Context(), func(tx repository.Tx) error {
+			if err := tx.Portfolios().Delete(c.Request.Context(), portfolioID, version); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error deleting portfolio and its relations", "error", err)
+				return err
+			}
+			if err := releaseUpload(c.Request.Context(), db, tx, store, "portfolio", portfolio.Image); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error releasing image", "error", err)
+				return err
+			}
+			for _, m := range portfolio.Media {
+				if err := releaseUpload(c.Request.Context(), db, tx, store, "", m.Key); err != nil {
+					slog.ErrorContext(c.Request.Context(), "Error releasing media", "error", err)
+					return err
 				}
 			}
+			return nil
+		})
+		if err != nil {
+			respondError(c, err, "Failed to delete portfolio and its relations")
+			return
 		}
 
 		c.JSON(http.StatusOK, formatter.SuccessResponse("Portfolio and its relations deleted successfully"))
 	}
 }
 
-func checkUserLogin(c *gin.Context, jwtKey string, db *sql.DB) bool {
+func checkUserLogin(c *gin.Context, jwtKey string, db repository.DB) bool {
+	_, ok := authenticate(c, jwtKey, db)
+	return ok
+}
+
+// authenticate validates the bearer token of the request and returns the
+// id of its user, aborting with 401 otherwise
+func authenticate(c *gin.Context, jwtKey string, db repository.DB) (string, bool) {
 	authorizationHeader := c.GetHeader("Authorization")
 	if authorizationHeader == "" {
-		c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Authorization header not provided"))
-		return false
+		writeProblem(c, http.StatusUnauthorized, codeUnauthorized, "Authorization header not provided")
+		return "", false
 	}
 
 	tokenString := strings.TrimPrefix(authorizationHeader, "Bearer ")
 	if tokenString == "" {
-		c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Token not provided"))
-		return false
+		writeProblem(c, http.StatusUnauthorized, codeUnauthorized, "Token not provided")
+		return "", false
 	}
 
-	_, err := ValidateToken(tokenString, jwtKey, db)
+	userID, err := ValidateToken(c.Request.Context(), tokenString, jwtKey, db)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Invalid token"))
-		return false
+		rejectToken(c, err)
+		return "", false
 	}
+	identify(c, userID)
 
-	return true
+	return userID, true
 }
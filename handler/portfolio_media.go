@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"portfolio/media"
+	"portfolio/model"
+	"portfolio/repository"
+	"portfolio/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	formatter "github.com/ivanauliaa/response-formatter"
+)
+
+// AddPortfolioMedia appends an image, document or video to the gallery of
+// a portfolio. The file comes as the file form field or as the upload_id
+// of a finished resumable upload, with optional caption and alt_text.
+func AddPortfolioMedia(db repository.DB, store storage.Storage, jwtKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := authenticate(c, jwtKey, db)
+		if !ok {
+			return
+		}
+
+		portfolio, err := db.Portfolios().Get(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error retrieving portfolio", "error", err)
+			respondError(c, err, "Failed to retrieve portfolio")
+			return
+		}
+
+		version, ok := requireIfMatch(c, portfolio.Version)
+		if !ok {
+			return
+		}
+
+		item := model.PortfolioMedia{
+			ID:          uuid.New().String(),
+			PortfolioID: portfolio.ID,
+			Type:        media.KindImage,
+			Caption:     c.PostForm("caption"),
+			AltText:     c.PostForm("alt_text"),
+		}
+		if errs := model.Validate(&item); len(errs) > 0 {
+			validationErrorResponse(c, errs)
+			return
+		}
+
+		file, ok := mediaFromRequest(c, db, store, userID)
+		if !ok {
+			return
+		}
+		if file == nil {
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Media file or upload_id is required")
+			return
+		}
+		item.Type = file.Kind
+		item.Key = file.Key
+		item.ContentType = file.ContentType
+		item.Size = file.Size
+		item.Width = file.Width
+
+		err = db.RunInTx(c.Request.Context(), func(tx repository.Tx) error {
+			if err := stageMedia(c.Request.Context(), db, tx, store, file); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error saving media file", "error", err)
+				return err
+			}
+			if err := tx.Portfolios().AddMedia(c.Request.Context(), &item); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error inserting portfolio media", "error", err)
+				return err
+			}
+			portfolio.Version, err = tx.Portfolios().Touch(c.Request.Context(), portfolio.ID, version)
+			return err
+		})
+		if err != nil {
+			respondError(c, err, "Failed to add media to portfolio")
+			return
+		}
+
+		setETag(c, portfolio.Version)
+		setMediaURLs(c, store, &item)
+
+		c.JSON(http.StatusCreated, formatter.SuccessResponse(item))
+	}
+}
+
+// ReorderPortfolioMedia puts the gallery of a portfolio in the order of
+// the media_ids form field, which lists every media of it once.
+func ReorderPortfolioMedia(db repository.DB, store storage.Storage, jwtKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkUserLogin(c, jwtKey, db) {
+			return
+		}
+
+		mediaIDs := c.PostFormArray("media_ids")
+		if errs := model.RequireNonEmpty("media_ids", mediaIDs); len(errs) > 0 {
+			validationErrorResponse(c, errs)
+			return
+		}
+
+		portfolio, err := db.Portfolios().Get(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error retrieving portfolio", "error", err)
+			respondError(c, err, "Failed to retrieve portfolio")
+			return
+		}
+
+		version, ok := requireIfMatch(c, portfolio.Version)
+		if !ok {
+			return
+		}
+
+		var gallery []model.PortfolioMedia
+		err = db.RunInTx(c.Request.Context(), func(tx repository.Tx) error {
+			if err := tx.Portfolios().ReorderMedia(c.Request.Context(), portfolio.ID, mediaIDs); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error reordering portfolio media", "error", err)
+				return err
+			}
+			if portfolio.Version, err = tx.Portfolios().Touch(c.Request.Context(), portfolio.ID, version); err != nil {
+				return err
+			}
+			gallery, err = tx.Portfolios().Media(c.Request.Context(), portfolio.ID)
+			return err
+		})
+		if err != nil {
+			respondError(c, err, "Failed to reorder portfolio media")
+			return
+		}
+
+		setETag(c, portfolio.Version)
+		for i := range gallery {
+			setMediaURLs(c, store, &gallery[i])
+		}
+
+		c.JSON(http.StatusOK, formatter.SuccessResponse(gallery))
+	}
+}
+
+// DeletePortfolioMedia removes one media from the gallery of a portfolio.
+// Its file is deleted once nothing else references the same content.
+func DeletePortfolioMedia(db repository.DB, store storage.Storage, jwtKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkUserLogin(c, jwtKey, db) {
+			return
+		}
+
+		portfolio, err := db.Portfolios().Get(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error retrieving portfolio", "error", err)
+			respondError(c, err, "Failed to retrieve portfolio")
+			return
+		}
+
+		item, err := db.Portfolios().GetMedia(c.Request.Context(), portfolio.ID, c.Param("media_id"))
+		if err != nil {
+			respondError(c, err, "Failed to retrieve portfolio media")
+			return
+		}
+
+		version, ok := requireIfMatch(c, portfolio.Version)
+		if !ok {
+			return
+		}
+
+		err = db.RunInTx(c.Request.Context(), func(tx repository.Tx) error {
+			if err := tx.Portfolios().DeleteMedia(c.Request.Context(), item); err != nil {
+				return err
+			}
+			if err := releaseUpload(c.Request.Context(), db, tx, store, "", item.Key); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error releasing media", "error", err)
+				return err
+			}
+			portfolio.Version, err = tx.Portfolios().Touch(c.Request.Context(), portfolio.ID, version)
+			return err
+		})
+		if err != nil {
+			respondError(c, err, "Failed to delete portfolio media")
+			return
+		}
+
+		setETag(c, portfolio.Version)
+
+		c.JSON(http.StatusOK, formatter.SuccessResponse("Portfolio media deleted successfully"))
+	}
+}
+
+// setMediaURLs fills in the download URL of a gallery item and, for
+// images, the variants generated for it
+func setMediaURLs(c *gin.Context, store storage.Storage, item *model.PortfolioMedia) {
+	item.URL = imageURL(c, store, item.Key)
+	if item.Type == media.KindImage {
+		item.ImageSet = imageSet(c, store, "", item.Key, item.Width)
+	}
+}
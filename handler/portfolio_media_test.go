@@ -0,0 +1,96 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"portfolio/model"
+)
+
+// pdfDocument is enough of a PDF for its type to be detected
+var pdfDocument = []byte("%PDF-1.4\n1 0 obj << /Type /Catalog >> endobj\ntrailer << /Root 1 0 R >>\n%%EOF\n")
+
+// addMedia attaches file to the portfolio id and returns the new media id
+func (s *server) addMedia(token, id string, file []byte) string {
+	s.t.Helper()
+	w := s.expect(s.do(newCall("POST", "/api/v1/portfolio/"+id+"/media").auth(token).
+		set("If-Match", s.etag("/api/v1/portfolio/"+id)).
+		multipart(url.Values{"caption": {"Screenshot"}}, map[string][]byte{"file": file})), http.StatusCreated)
+
+	var item struct{ ID string }
+	data(s.t, w, &item)
+	return item.ID
+}
+
+func TestAddPortfolioMedia(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+	id := s.createPortfolio(token, "", s.createSkill(token, "Go"))
+	target := "/api/v1/portfolio/" + id + "/media"
+
+	s.addMedia(token, id, pngImage(50))
+	s.addMedia(token, id, pdfDocument)
+
+	p := s.portfolio(id)
+	if len(p.Media) != 2 || p.Media[0].Type != "image" || p.Media[1].Type != "document" || p.Version != 3 {
+		t.Fatalf("portfolio = %+v, want an image then a document at version 3", p)
+	}
+
+	files := map[string][]byte{"file": pngImage(51)}
+	s.expectProblem(s.do(newCall("POST", target).set("If-Match", "*").multipart(nil, files)), http.StatusUnauthorized, "unauthorized")
+	s.expectProblem(s.do(newCall("POST", target).auth(token).multipart(nil, files)), http.StatusPreconditionRequired, "precondition_required")
+	s.expectProblem(s.do(newCall("POST", target).auth(token).set("If-Match", `"1"`).multipart(nil, files)), http.StatusPreconditionFailed, model.CodePreconditionFailed)
+	s.expectProblem(s.do(newCall("POST", target).auth(token).set("If-Match", "*").multipart(nil, nil)), http.StatusBadRequest, "bad_request")
+	s.expectProblem(s.do(newCall("POST", target).auth(token).set("If-Match", "*").
+		multipart(nil, map[string][]byte{"file": []byte("plain text")})), http.StatusUnsupportedMediaType, "unsupported_media_type")
+	s.expectProblem(s.do(newCall("POST", "/api/v1/portfolio/missing/media").auth(token).set("If-Match", "*").multipart(nil, files)),
+		http.StatusNotFound, model.CodeNotFound)
+}
+
+func TestReorderPortfolioMedia(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+	id := s.createPortfolio(token, "", s.createSkill(token, "Go"))
+	first := s.addMedia(token, id, pngImage(50))
+	second := s.addMedia(token, id, pdfDocument)
+	target := "/api/v1/portfolio/" + id + "/media/order"
+
+	reorder := func(mediaIDs ...string) *call {
+		return newCall("PUT", target).auth(token).form(url.Values{"media_ids": mediaIDs})
+	}
+
+	s.expectProblem(s.do(reorder(second, first)), http.StatusPreconditionRequired, "precondition_required")
+	s.expect(s.do(reorder(second, first).set("If-Match", s.etag("/api/v1/portfolio/"+id))), http.StatusOK)
+
+	p := s.portfolio(id)
+	if len(p.Media) != 2 || p.Media[0].ID != second || p.Media[1].ID != first {
+		t.Fatalf("media = %+v, want %s then %s", p.Media, second, first)
+	}
+
+	for _, mediaIDs := range [][]string{{first}, {first, first}, {first, second, "missing"}} {
+		s.expectProblem(s.do(reorder(mediaIDs...).set("If-Match", "*")), http.StatusUnprocessableEntity, model.CodeValidation)
+	}
+}
+
+func TestDeletePortfolioMedia(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+	id := s.createPortfolio(token, "", s.createSkill(token, "Go"))
+	first := s.addMedia(token, id, pngImage(50))
+	second := s.addMedia(token, id, pdfDocument)
+	blobs := s.files("blobs")
+
+	target := "/api/v1/portfolio/" + id + "/media/" + first
+	s.expectProblem(s.do(newCall("DELETE", target).auth(token)), http.StatusPreconditionRequired, "precondition_required")
+	s.expect(s.do(newCall("DELETE", target).auth(token).set("If-Match", s.etag("/api/v1/portfolio/"+id))), http.StatusOK)
+	s.expectProblem(s.do(newCall("DELETE", target).auth(token).set("If-Match", "*")), http.StatusNotFound, model.CodeNotFound)
+
+	p := s.portfolio(id)
+	if len(p.Media) != 1 || p.Media[0].ID != second || p.Media[0].Position != 0 {
+		t.Fatalf("media = %+v, want only %s first", p.Media, second)
+	}
+	if n := s.files("blobs"); n != blobs-1 {
+		t.Fatalf("stored %d blobs after delete, want %d", n, blobs-1)
+	}
+}
@@ -0,0 +1,195 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"portfolio/model"
+)
+
+// portfolioView is the part of GET /api/v1/portfolio/:id the tests read
+type portfolioView struct {
+	Title      string
+	Status     string
+	Version    int
+	Skills     []struct{ ID string }
+	Experience *struct{ ID string }
+	Media      []struct {
+		ID       string
+		Type     string
+		Position int
+	}
+}
+
+func (s *server) portfolio(id string) portfolioView {
+	s.t.Helper()
+	w := s.expect(s.do(newCall("GET", "/api/v1/portfolio/"+id)), http.StatusOK)
+	var body struct{ Portfolio portfolioView }
+	data(s.t, w, &body)
+	return body.Portfolio
+}
+
+func TestAddPortfolioWithSkills(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+	skillID := s.createSkill(token, "Go")
+	experienceID := s.createExperience(token, skillID)
+	id := s.createPortfolio(token, experienceID, skillID)
+
+	p := s.portfolio(id)
+	if p.Title != "Portfolio site" || p.Version != 1 || len(p.Skills) != 1 || p.Experience == nil || p.Experience.ID != experienceID {
+		t.Fatalf("portfolio = %+v, want it with skill %s and experience %s", p, skillID, experienceID)
+	}
+
+	fields := url.Values{"title": {"Other"}, "date_project": {"2023-05-01"}, "skill_ids": {skillID}}
+	images := map[string][]byte{"image": pngImage(41)}
+	s.expectProblem(s.do(newCall("POST", "/api/v1/portfolio").multipart(fields, images)), http.StatusUnauthorized, "unauthorized")
+	s.expectProblem(s.do(newCall("POST", "/api/v1/portfolio").auth(token).multipart(fields, nil)), http.StatusBadRequest, "bad_request")
+	s.expectProblem(s.do(newCall("POST", "/api/v1/portfolio").auth(token).multipart(url.Values{"title": {"Other"}, "date_project": {"May"}}, images)),
+		http.StatusUnprocessableEntity, model.CodeValidation)
+
+	// An unknown skill rolls back the portfolio and its image
+	blobs := s.files("blobs")
+	fields.Set("skill_ids", "missing")
+	s.expectProblem(s.do(newCall("POST", "/api/v1/portfolio").auth(token).multipart(fields, images)), http.StatusConflict, model.CodeConflict)
+	if n := s.files("blobs"); n != blobs {
+		t.Fatalf("stored %d blobs after rollback, want %d", n, blobs)
+	}
+
+	w := s.expect(s.do(newCall("GET", "/api/v1/portfolio")), http.StatusOK)
+	var list struct{ Portfolios []struct{ ID string } }
+	data(t, w, &list)
+	if len(list.Portfolios) != 1 || list.Portfolios[0].ID != id {
+		t.Fatalf("portfolios = %+v, want only %s", list.Portfolios, id)
+	}
+}
+
+func TestGetPortfolioAndSkills(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+	skillID := s.createSkill(token, "Go")
+	for i := 0; i < 3; i++ {
+		s.createPortfolio(token, "", skillID)
+	}
+
+	w := s.expect(s.do(newCall("GET", "/api/v1/portfolio?page=1&limit=2")), http.StatusOK)
+	var list struct {
+		Portfolios []struct {
+			Skills []struct{ ID string }
+		}
+	}
+	data(t, w, &list)
+	if len(list.Portfolios) != 2 || len(list.Portfolios[0].Skills) != 1 {
+		t.Fatalf("page 1 = %+v, want two portfolios with their skill", list.Portfolios)
+	}
+
+	s.expectProblem(s.do(newCall("GET", "/api/v1/portfolio/missing")), http.StatusNotFound, model.CodeNotFound)
+}
+
+func TestUpdatePortfolioHandler(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+	skillID := s.createSkill(token, "Go")
+	experienceID := s.createExperience(token, skillID)
+	id := s.createPortfolio(token, "", skillID)
+	target := "/api/v1/portfolio/" + id
+
+	update := func(fields url.Values) *call {
+		return newCall("PUT", target).auth(token).multipart(fields, map[string][]byte{"image": pngImage(42)})
+	}
+
+	s.expectProblem(s.do(update(url.Values{"title": {"New"}})), http.StatusPreconditionRequired, "precondition_required")
+	s.expect(s.do(update(url.Values{"title": {"New"}, "experience_id": {experienceID}}).set("If-Match", s.etag(target))), http.StatusOK)
+	s.expectProblem(s.do(update(url.Values{"title": {"Newer"}}).set("If-Match", `"1"`)), http.StatusPreconditionFailed, model.CodePreconditionFailed)
+
+	// A malformed date is reported once, not as missing as well
+	w := s.do(update(url.Values{"date_project": {"soon"}}).set("If-Match", "*"))
+	s.expectProblem(w, http.StatusUnprocessableEntity, model.CodeValidation)
+	if errs := problem(t, w).Errors; len(errs) != 1 || errs[0].Code != model.CodeInvalidDate {
+		t.Fatalf("errors = %+v, want only invalid_date", errs)
+	}
+
+	p := s.portfolio(id)
+	if p.Title != "New" || p.Version != 2 || p.Experience == nil || p.Experience.ID != experienceID {
+		t.Fatalf("portfolio = %+v, want New at version 2 with experience %s", p, experienceID)
+	}
+}
+
+func TestPatchPortfolioHandler(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+	id := s.createPortfolio(token, "", s.createSkill(token, "Go"))
+	target := "/api/v1/portfolio/" + id
+
+	patch := func(body string) *call {
+		return newCall("PATCH", target).auth(token).raw("application/merge-patch+json", []byte(body))
+	}
+
+	s.expectProblem(s.do(patch(`{"status":null}`)), http.StatusPreconditionRequired, "precondition_required")
+	s.expect(s.do(patch(`{"status":null}`).set("If-Match", s.etag(target))), http.StatusOK)
+	s.expectProblem(s.do(patch(`{"title":""}`).set("If-Match", "*")), http.StatusUnprocessableEntity, model.CodeValidation)
+	s.expectProblem(s.do(patch(`{"experience_id":"missing"}`).set("If-Match", "*")), http.StatusConflict, model.CodeConflict)
+	s.expectProblem(s.do(patch(`{"image":"x.png"}`).set("If-Match", "*")), http.StatusUnprocessableEntity, model.CodeValidation)
+
+	if p := s.portfolio(id); p.Status != "" || p.Version != 2 {
+		t.Fatalf("portfolio = %+v, want no status at version 2", p)
+	}
+}
+
+func TestDeletePortfolioHandler(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+	skillID := s.createSkill(token, "Go")
+	first := s.createPortfolio(token, "", skillID)
+	second := s.createPortfolio(token, "", skillID)
+
+	// Both portfolios share the blob of their identical image
+	blobs := s.files("blobs")
+
+	s.expectProblem(s.do(newCall("DELETE", "/api/v1/portfolio/"+first).auth(token)), http.StatusPreconditionRequired, "precondition_required")
+	s.expectProblem(s.do(newCall("DELETE", "/api/v1/portfolio/"+first).auth(token).set("If-Match", `"2"`)), http.StatusPreconditionFailed, model.CodePreconditionFailed)
+
+	s.expect(s.do(newCall("DELETE", "/api/v1/portfolio/"+first).auth(token).set("If-Match", `"1"`)), http.StatusOK)
+	s.expectProblem(s.do(newCall("GET", "/api/v1/portfolio/"+first)), http.StatusNotFound, model.CodeNotFound)
+	if n := s.files("blobs"); n != blobs {
+		t.Fatalf("stored %d blobs while the image is still used, want %d", n, blobs)
+	}
+
+	s.expect(s.do(newCall("DELETE", "/api/v1/portfolio/"+second).auth(token).set("If-Match", "*")), http.StatusOK)
+	if n := s.files("blobs"); n != blobs-1 {
+		t.Fatalf("stored %d blobs after the last use, want %d", n, blobs-1)
+	}
+}
+
+func TestPortfolioSkills(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+	goID := s.createSkill(token, "Go")
+	sqlID := s.createSkill(token, "SQL")
+	id := s.createPortfolio(token, "", goID)
+
+	add := func(skillIDs ...string) *call {
+		return newCall("PUT", "/api/v1/portfolio-skill/"+id).auth(token).form(url.Values{"skill_ids": skillIDs})
+	}
+
+	s.expectProblem(s.do(add(sqlID)), http.StatusPreconditionRequired, "precondition_required")
+	s.expect(s.do(add(sqlID).set("If-Match", s.etag("/api/v1/portfolio/"+id))), http.StatusOK)
+	s.expectProblem(s.do(add(sqlID).set("If-Match", `"1"`)), http.StatusPreconditionFailed, model.CodePreconditionFailed)
+	s.expectProblem(s.do(add("missing").set("If-Match", "*")), http.StatusConflict, model.CodeConflict)
+
+	remove := func() *call {
+		return newCall("POST", "/api/v1/portfolio-skill/"+id).auth(token).form(url.Values{"skill_id": {goID}})
+	}
+	s.expectProblem(s.do(remove()), http.StatusPreconditionRequired, "precondition_required")
+	s.expectProblem(s.do(remove().set("If-Match", `"1"`)), http.StatusPreconditionFailed, model.CodePreconditionFailed)
+	w := s.expect(s.do(remove().set("If-Match", `"2"`)), http.StatusOK)
+	if etag := w.Header().Get("ETag"); etag != `"3"` {
+		t.Fatalf("ETag after removing a skill = %s, want \"3\"", etag)
+	}
+	s.expectProblem(s.do(newCall("POST", "/api/v1/portfolio-skill/"+id).form(url.Values{"skill_id": {sqlID}})), http.StatusUnauthorized, "unauthorized")
+
+	if p := s.portfolio(id); len(p.Skills) != 1 || p.Skills[0].ID != sqlID || p.Version != 3 {
+		t.Fatalf("portfolio = %+v, want only %s at version 3", p, sqlID)
+	}
+}
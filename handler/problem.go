@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"portfolio/media"
+	"portfolio/model"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+)
+
+const problemContentType = "application/problem+json"
+
+// Stable error codes for failures that do not come from the model package.
+// Together with the model.Code* constants these are the values clients
+// switch on; the human readable detail may change at any time.
+const (
+	codeBadRequest   = "bad_request"
+	codeUnauthorized = "unauthorized"
+	codeInternal     = "internal_error"
+	codeTooLarge     = "payload_too_large"
+	codeTimeout      = "timeout"
+	codeCanceled     = "client_closed_request"
+)
+
+// statusClientClosedRequest is the nginx convention for a request the
+// client gave up on before it was answered. Nobody reads the response, but
+// it keeps these requests apart from server errors in logs and metrics.
+const statusClientClosedRequest = 499
+
+// Problem is an RFC 7807 problem details document.
+type Problem struct {
+	Type     string                 `json:"type"`
+	Title    string                 `json:"title"`
+	Status   int                    `json:"status"`
+	Detail   string                 `json:"detail,omitempty"`
+	Instance string                 `json:"instance,omitempty"`
+	Code     string                 `json:"code"`
+	Errors   model.ValidationErrors `json:"errors,omitempty"`
+}
+
+// writeProblem aborts the request with an application/problem+json body
+func writeProblem(c *gin.Context, status int, code, detail string) {
+	abortWithProblem(c, Problem{Status: status, Code: code, Detail: detail})
+}
+
+// respondError maps domain errors from the model package to their problem
+// response, falling back to a 500 with the given detail for anything else
+func respondError(c *gin.Context, err error, detail string) {
+	var (
+		verrs      model.ValidationErrors
+		notFound   *model.NotFoundError
+		conflict   *model.ConflictError
+		forbidden  *model.ForbiddenError
+		stale      *model.PreconditionFailedError
+		badType    *media.UnsupportedTypeError
+		badFile    *media.UnsupportedAttachmentError
+		tooLarge   *media.TooLargeError
+		dimensions *media.DimensionsError
+	)
+
+	switch {
+	// The client is gone, whatever error that caused further down
+	case errors.Is(err, context.Canceled) || c.Request.Context().Err() == context.Canceled:
+		slog.InfoContext(c.Request.Context(), "Request canceled by the client", "error", err)
+		abortWithProblem(c, Problem{Status: statusClientClosedRequest, Title: "Client Closed Request", Code: codeCanceled, Detail: "The request was canceled"})
+	case errors.Is(err, context.DeadlineExceeded):
+		slog.WarnContext(c.Request.Context(), "Request timed out", "error", err)
+		writeProblem(c, http.StatusGatewayTimeout, codeTimeout, "The request took too long")
+	case errors.As(err, &verrs):
+		validationErrorResponse(c, verrs)
+	case errors.As(err, &notFound):
+		writeProblem(c, http.StatusNotFound, model.CodeNotFound, notFound.Error())
+	case errors.As(err, &conflict):
+		writeProblem(c, http.StatusConflict, model.CodeConflict, conflict.Error())
+	case errors.As(err, &stale):
+		setETag(c, stale.Current)
+		writeProblem(c, http.StatusPreconditionFailed, model.CodePreconditionFailed, stale.Error())
+	case errors.As(err, &forbidden):
+		writeProblem(c, http.StatusForbidden, model.CodeForbidden, forbidden.Error())
+	case errors.As(err, &badType):
+		writeProblem(c, http.StatusUnsupportedMediaType, codeUnsupportedMediaType, badType.Error())
+	case errors.As(err, &badFile):
+		writeProblem(c, http.StatusUnsupportedMediaType, codeUnsupportedMediaType, badFile.Error())
+	case errors.As(err, &tooLarge):
+		writeProblem(c, http.StatusRequestEntityTooLarge, codeTooLarge, tooLarge.Error())
+	case errors.As(err, &dimensions):
+		validationErrorResponse(c, model.ValidationErrors{{Field: "image", Code: model.CodeTooLarge, Message: dimensions.Error()}})
+	default:
+		slog.ErrorContext(c.Request.Context(), "Unhandled error", "error", err)
+		writeProblem(c, http.StatusInternalServerError, codeInternal, detail)
+	}
+}
+
+// isContextError reports whether err comes from a cancelled or expired
+// context rather than from the operation itself
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+func abortWithProblem(c *gin.Context, p Problem) {
+	if p.Type == "" {
+		p.Type = "/problems/" + p.Code
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	if p.Instance == "" {
+		p.Instance = c.Request.URL.Path
+	}
+
+	c.Header("Content-Type", problemContentType)
+	c.AbortWithStatusJSON(p.Status, p)
+}
+
+// Recovery turns a panic in a handler into a logged 500 problem. It
+// replaces the recovery middleware of gin.Default, which writes plain text
+// logs of its own.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, recovered any) {
+		slog.ErrorContext(c.Request.Context(), "Panic while handling request", "panic", recovered, "stack", string(debug.Stack()))
+		writeProblem(c, http.StatusInternalServerError, codeInternal, "Internal server error")
+	})
+}
@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"portfolio/logging"
+	"portfolio/metrics"
+	"portfolio/publicurl"
+	"portfolio/repository"
+	"portfolio/storage"
+	"portfolio/tracing"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RouteConfig holds what the routes need besides the repository and the
+// storage.
+type RouteConfig struct {
+	JWTKey string
+	// SigningKey signs the parameters of image transformations, which are
+	// disabled without it.
+	SigningKey    string
+	ImageCacheDir string
+	Tus           TusConfig
+	URLs          *publicurl.Config
+	// Checks are run by /readyz, which fails while Draining is set.
+	Checks   []HealthCheck
+	Draining *atomic.Bool
+	Build    BuildInfo
+}
+
+// Routes installs the middleware and every endpoint of the API on r.
+func Routes(r *gin.Engine, db repository.DB, store storage.Storage, cfg RouteConfig) error {
+	jwtKey := cfg.JWTKey
+
+	// X-Forwarded-For is only honored from the trusted proxies, so clients
+	// cannot choose the client_ip logged and the client.address traced
+	var proxies []string
+	for _, prefix := range cfg.URLs.TrustedProxies {
+		proxies = append(proxies, prefix.String())
+	}
+	if err := r.SetTrustedProxies(proxies); err != nil {
+		return fmt.Errorf("trusted proxies: %w", err)
+	}
+
+	r.Use(tracing.Middleware(), logging.Middleware(), metrics.Middleware(), Recovery())
+	r.Use(CORS())
+	r.Use(cfg.URLs.Middleware())
+
+	// Probes for the orchestrator
+	r.GET("/healthz", Healthz())
+	r.GET("/readyz", Readyz(cfg.Draining, cfg.Checks))
+	r.GET("/version", Version(cfg.Build))
+	r.GET("/metrics", metrics.Handler())
+
+	r.POST("/api/v1/auth/register", RegisterAuth(db, store))
+	r.POST("/api/v1/auth/login", LoginAuth(db, store, jwtKey))
+	r.GET("/api/v1/user", GetUserWithJWT(db, store, jwtKey))
+	r.DELETE("/api/v1/user", DeleteUser(db, store, jwtKey))
+	r.PATCH("/api/v1/user", PatchUser(db, jwtKey))
+
+	//skills
+	r.POST("/api/v1/skills", AddSkills(db, store, jwtKey))
+	r.GET("/api/v1/skills", GetSkill(db, store))
+	r.GET("/api/v1/skills/:id", GetSkillByID(db, store))
+	r.PUT("/api/v1/skills/:id", UpdateSkill(db, store, jwtKey))
+	r.PATCH("/api/v1/skills/:id", PatchSkill(db, jwtKey))
+	r.DELETE("/api/v1/skills/:id", DeleteSkill(db, store, jwtKey))
+
+	//portfolio
+	r.POST("/api/v1/portfolio", AddPortfolioWithSkills(db, store, jwtKey))
+	r.GET("/api/v1/portfolio", GetPortfolioAndSkillsPaginated(db, store))
+	r.GET("/api/v1/portfolio/:id", GetPortfolioAndSkillsByID(db, store))
+	r.DELETE("/api/v1/portfolio/:id", DeletePortfolioHandler(db, store, jwtKey))
+	r.PUT("/api/v1/portfolio/:id", UpdatePortfolioHandler(db, store, jwtKey))
+	r.PATCH("/api/v1/portfolio/:id", PatchPortfolioHandler(db, jwtKey))
+	r.POST("/api/v1/portfolio/:id/media", AddPortfolioMedia(db, store, jwtKey))
+	r.PUT("/api/v1/portfolio/:id/media/order", ReorderPortfolioMedia(db, store, jwtKey))
+	r.DELETE("/api/v1/portfolio/:id/media/:media_id", DeletePortfolioMedia(db, store, jwtKey))
+
+	//experience
+	r.POST("/api/v1/experience", AddExperiance(db, store, jwtKey))
+	r.GET("/api/v1/experience", GetExperience(db, store))
+	r.GET("/api/v1/experience/:id", GetExperienceByID(db, store))
+	r.PUT("/api/v1/experience/:id", UpdateExperience(db, store, jwtKey))
+	r.PATCH("/api/v1/experience/:id", PatchExperience(db, jwtKey))
+	r.DELETE("/api/v1/experience/:id", DeleteExperience(db, store, jwtKey))
+
+	//resumable uploads (tus)
+	r.OPTIONS("/api/v1/uploads", TusOptions(cfg.Tus))
+	r.POST("/api/v1/uploads", CreateUpload(db, jwtKey, cfg.Tus))
+	r.HEAD("/api/v1/uploads/:id", UploadStatus(db, jwtKey))
+	r.PATCH("/api/v1/uploads/:id", AppendUpload(db, store, jwtKey))
+	r.DELETE("/api/v1/uploads/:id", TerminateUpload(db, store, jwtKey))
+
+	r.PUT("/api/v1/portfolio-skill/:id", AddSkillsToPortfolio(db, jwtKey))
+	r.PUT("/api/v1/experience-skill/:id", AddSkillsToExperience(db, jwtKey))
+
+	r.POST("/api/v1/portfolio-skill/:id", DeleteSkillWithRelationsHandler(db, jwtKey))
+	r.POST("/api/v1/experience-skill/:id", DeleteSkillExperienceWithRelationsHandler(db, jwtKey))
+
+	// On-the-fly image transformations need a key to sign their parameters
+	if cfg.SigningKey != "" {
+		r.GET("/img/:entity/:file", TransformImage(store, cfg.SigningKey, cfg.ImageCacheDir))
+		r.GET("/api/v1/img/sign/:entity/:file", SignImageURL(db, jwtKey, cfg.SigningKey))
+	} else {
+		slog.Warn("images.signing_key (IMAGE_SIGNING_KEY) is not set, /img transformations are disabled")
+	}
+
+	// Serve static files for images when they are kept on local disk
+	if local, ok := storage.Unwrap(store).(*storage.Local); ok {
+		r.Static("/uploads", local.Root())
+	}
+	return nil
+}
+
+// CORS lets browsers on any origin call the API.
+func CORS() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match, X-Request-ID, traceparent, tracestate, "+strings.Join(TusHeaders, ", "))
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "ETag, X-Request-ID, "+strings.Join(TusHeaders, ", "))
+
+		// Answer preflights here; a plain OPTIONS request is a tus client
+		// asking what the upload endpoint supports
+		if c.Request.Method == "OPTIONS" && c.GetHeader("Access-Control-Request-Method") != "" {
+			c.AbortWithStatus(http.StatusOK)
+			return
+		}
+
+		c.Next()
+	}
+}
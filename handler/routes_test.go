@@ -0,0 +1,91 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"portfolio/handler"
+	"portfolio/publicurl"
+	"portfolio/repository"
+	"portfolio/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCORS(t *testing.T) {
+	s := newServer(t)
+
+	// Preflights are answered by the middleware
+	w := s.expect(s.do(newCall("OPTIONS", "/api/v1/portfolio/1").
+		set("Origin", "https://example.com").
+		set("Access-Control-Request-Method", "PATCH")), http.StatusOK)
+	if v := w.Header().Get("Access-Control-Allow-Origin"); v != "*" {
+		t.Fatalf("Access-Control-Allow-Origin = %q, want *", v)
+	}
+	for _, header := range []string{"If-Match", "Upload-Offset", "traceparent"} {
+		if !strings.Contains(w.Header().Get("Access-Control-Allow-Headers"), header) {
+			t.Errorf("Access-Control-Allow-Headers lacks %s", header)
+		}
+	}
+
+	// A plain OPTIONS request reaches the tus endpoint
+	w = s.expect(s.do(newCall("OPTIONS", "/api/v1/uploads")), http.StatusNoContent)
+	if w.Header().Get("Tus-Version") == "" {
+		t.Fatal("OPTIONS /api/v1/uploads did not reach the tus endpoint")
+	}
+
+	w = s.expect(s.do(newCall("GET", "/healthz")), http.StatusOK)
+	if !strings.Contains(w.Header().Get("Access-Control-Expose-Headers"), "ETag") {
+		t.Fatalf("Access-Control-Expose-Headers = %q, want ETag exposed", w.Header().Get("Access-Control-Expose-Headers"))
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	s := newServer(t)
+
+	w := s.expect(s.do(newCall("GET", "/healthz").set("X-Request-ID", "abc-123")), http.StatusOK)
+	if v := w.Header().Get("X-Request-ID"); v != "abc-123" {
+		t.Fatalf("X-Request-ID = %q, want the one sent", v)
+	}
+
+	w = s.expect(s.do(newCall("GET", "/healthz")), http.StatusOK)
+	if w.Header().Get("X-Request-ID") == "" {
+		t.Fatal("no X-Request-ID generated")
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxies []string
+		want    string
+	}{
+		{name: "no trusted proxies", want: "192.0.2.1"},
+		{name: "request from a trusted proxy", proxies: []string{"192.0.2.0/24"}, want: "203.0.113.7"},
+		{name: "request from another address", proxies: []string{"10.0.0.1"}, want: "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			urls, err := publicurl.New(testBaseURL, "", tt.proxies)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := gin.New()
+			if err := handler.Routes(r, repository.NewMemory(), storage.NewLocal(t.TempDir(), "/uploads"), handler.RouteConfig{URLs: urls, Draining: new(atomic.Bool)}); err != nil {
+				t.Fatal(err)
+			}
+			r.GET("/ip", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+
+			req := httptest.NewRequest("GET", "/ip", nil)
+			req.Header.Set("X-Forwarded-For", "203.0.113.7")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if got := w.Body.String(); got != tt.want {
+				t.Fatalf("client IP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
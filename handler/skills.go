@@ -1,14 +1,11 @@
 package handler
 
 import (
-	"database/sql"
-	"io"
-	"log"
-	"mime/multipart"
+	"log/slog"
 	"net/http"
-	"os"
-	"path/filepath"
 	"portfolio/model"
+	"portfolio/repository"
+	"portfolio/storage"
 	"strconv"
 	"strings"
 
@@ -17,27 +14,28 @@ import (
 	formatter "github.com/ivanauliaa/response-formatter"
 )
 
-func AddSkills(db *sql.DB, jwtKey string) gin.HandlerFunc {
+func AddSkills(db repository.DB, store storage.Storage, jwtKey string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		//check if the user is logged in
 		authorizationHeader := c.GetHeader("Authorization")
 		if authorizationHeader == "" {
-			c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Authorization header not provided"))
+			writeProblem(c, http.StatusUnauthorized, codeUnauthorized, "Authorization header not provided")
 			return
 		}
 
 		tokenStirng := strings.TrimPrefix(authorizationHeader, "Bearer ")
 		if tokenStirng == "" {
-			c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Token not provided"))
+			writeProblem(c, http.StatusUnauthorized, codeUnauthorized, "Token not provided")
 			return
 		}
 
-		_, err := ValidateToken(tokenStirng, jwtKey, db)
+		userID, err := ValidateToken(c.Request.Context(), tokenStirng, jwtKey, db)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Invalid token"))
+			rejectToken(c, err)
 			return
 		}
+		identify(c, userID)
 
 		c.Bind(&model.Skills{})
 
@@ -46,148 +44,164 @@ func AddSkills(db *sql.DB, jwtKey string) gin.HandlerFunc {
 			Name: c.PostForm("name"),
 		}
 
+		if errs := model.Validate(&skil); len(errs) > 0 {
+			validationErrorResponse(c, errs)
+			return
+		}
+
 		//handler file upload image
 		file, err := c.FormFile("image")
 		if err != nil {
-			log.Printf("Error retrieving file %v\n", err)
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Failed to get uploaded file"))
+			slog.ErrorContext(c.Request.Context(), "Error retrieving file", "error", err)
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Failed to get uploaded file")
 			return
 		}
 
-		//Generate a new filename for the image
-		newFilename := uuid.New().String() + filepath.Ext(file.Filename)
-		if err := c.SaveUploadedFile(file, "uploads/skills/"+newFilename); err != nil {
-			log.Printf("Error saving file: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to save uploaded file"))
+		// Name the image after its content
+		upload, ok := checkImage(c, "skills", file)
+		if !ok {
 			return
 		}
-		skil.Image = newFilename
+		skil.Image = upload.Key
+		skil.ImageWidth = upload.Width
+
+		err = db.RunInTx(c.Request.Context(), func(tx repository.Tx) error {
+			if err := stageUpload(c.Request.Context(), db, tx, store, upload); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error saving file", "error", err)
+				return err
+			}
 
-		//insert skil into the database
-		if err := model.InsertSkills(db, skil); err != nil {
-			log.Printf("Error inserting user into database: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to insert skill into database"))
+			//insert skil into the database
+			if err := tx.Skills().Insert(c.Request.Context(), skil); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error inserting skill into database", "error", err)
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			respondError(c, err, "Failed to insert skill into database")
 			return
 		}
+
+		skil.ImageSet = imageSet(c, store, "skills", skil.Image, skil.ImageWidth)
 		c.JSON(http.StatusCreated, formatter.SuccessResponse(skil))
 	}
 }
 
-func GetSkill(db *sql.DB) gin.HandlerFunc {
+func GetSkill(db repository.DB, store storage.Storage) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		// Parse pagination query parameters
 		limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
 		if err != nil {
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Invalid limit value"))
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Invalid limit value")
 			return
 		}
 
 		offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
 		if err != nil {
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Invalid offset value"))
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Invalid offset value")
 			return
 		}
 
 		// Retrieve skills with pagination
-		skills, err := model.GetListSkills(db, offset, limit)
+		skills, err := db.Skills().List(c.Request.Context(), offset, limit)
 		if err != nil {
-			log.Printf("Error retrieving skills: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to retrieve skills"))
+			slog.ErrorContext(c.Request.Context(), "Error retrieving skills", "error", err)
+			respondError(c, err, "Failed to retrieve skills")
 			return
 		}
 
 		// Include server URL in the image links
-		scheme := "http"
-		if c.Request.TLS != nil {
-			scheme = "https"
-		}
 		for i := range skills {
-			skills[i].Image = scheme + "://" + c.Request.Host + "/uploads/skills/" + skills[i].Image
+			skills[i].ImageSet = imageSet(c, store, "skills", skills[i].Image, skills[i].ImageWidth)
 		}
 
 		c.JSON(http.StatusOK, formatter.SuccessResponse(skills))
 	}
 }
 
-func GetSkillByID(db *sql.DB) gin.HandlerFunc {
+func GetSkillByID(db repository.DB, store storage.Storage) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		skillID := c.Param("id")
 		if skillID == "" {
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Skill ID is required"))
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Skill ID is required")
 			return
 		}
 
-		skill, err := model.GetSkillID(db, skillID)
+		skill, err := db.Skills().Get(c.Request.Context(), skillID)
 		if err != nil {
-			log.Printf("Error retrieving skill by ID: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to retrieve skill"))
+			slog.ErrorContext(c.Request.Context(), "Error retrieving skill by ID", "error", err)
+			respondError(c, err, "Failed to retrieve skill")
 			return
 		}
 
 		// Include server URL in the image link
-		scheme := "http"
-		if c.Request.TLS != nil {
-			scheme = "https"
-		}
-		skill.Image = scheme + "://" + c.Request.Host + "/uploads/skills/" + skill.Image
+		skill.ImageSet = imageSet(c, store, "skills", skill.Image, skill.ImageWidth)
 
+		setETag(c, skill.Version)
 		c.JSON(http.StatusOK, formatter.SuccessResponse(skill))
 	}
 }
 
-
-func DeleteSkill(db *sql.DB, jwtKey string) gin.HandlerFunc {
+func DeleteSkill(db repository.DB, store storage.Storage, jwtKey string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		//check user login
 		authorizationHeader := c.GetHeader("Authorization")
 		if authorizationHeader == "" {
-			c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Authorization header not provided"))
+			writeProblem(c, http.StatusUnauthorized, codeUnauthorized, "Authorization header not provided")
 			return
 		}
 
 		tokenString := strings.TrimPrefix(authorizationHeader, "Bearer ")
 
 		if tokenString == "" {
-			c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Token not provided"))
+			writeProblem(c, http.StatusUnauthorized, codeUnauthorized, "Token not provided")
 			return
 		}
 
-		_, err := ValidateToken(tokenString, jwtKey, db)
+		userID, err := ValidateToken(c.Request.Context(), tokenString, jwtKey, db)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Invalid token"))
+			rejectToken(c, err)
 			return
 		}
+		identify(c, userID)
 
 		skilIDToDelete := c.Param("id")
 		if skilIDToDelete == "" {
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Skill id required"))
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Skill id required")
 			return
 		}
 
 		//retrive skill to get the image path
-		skill, err := model.GetSkillID(db, skilIDToDelete)
+		skill, err := db.Skills().Get(c.Request.Context(), skilIDToDelete)
 
 		if err != nil {
-			log.Printf("Error retriving skillll %v\n", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to retrieve skill"))
+			slog.ErrorContext(c.Request.Context(), "Error retrieving skill", "error", err)
+			respondError(c, err, "Failed to retrieve skill")
+			return
 		}
 
-		//delete skill from db
-		if err := model.DeleteSkill(db, skilIDToDelete); err != nil {
-			log.Printf("Error deleting skill from database: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to delete skill"))
+		version, ok := requireIfMatch(c, skill.Version)
+		if !ok {
 			return
 		}
 
-		// Delete the skill's image file
-		if skill.Image != "" {
-			imagePath := "./uploads/skills/" + skill.Image
-			if err := os.Remove(imagePath); err != nil {
-				log.Printf("Error deleting image file: %v", err)
-				c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to delete skill image"))
-				return
+		//delete skill from db, then its image once the delete commits
+		err = db.RunInTx(c.Request.Context(), func(tx repository.Tx) error {
+			if err := tx.Skills().Delete(c.Request.Context(), skilIDToDelete, version); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error deleting skill from database", "error", err)
+				return err
+			}
+			if err := releaseUpload(c.Request.Context(), db, tx, store, "skills", skill.Image); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error releasing image", "error", err)
+				return err
 			}
+			return nil
+		})
+		if err != nil {
+			respondError(c, err, "Failed to delete skill")
+			return
 		}
 
 		// Return success response
@@ -195,45 +209,52 @@ func DeleteSkill(db *sql.DB, jwtKey string) gin.HandlerFunc {
 	}
 }
 
-func UpdateSkill(db *sql.DB, jwtKey string) gin.HandlerFunc {
+func UpdateSkill(db repository.DB, store storage.Storage, jwtKey string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Check user login
 		authorizationHeader := c.GetHeader("Authorization")
 		if authorizationHeader == "" {
-			c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Authorization header not provided"))
+			writeProblem(c, http.StatusUnauthorized, codeUnauthorized, "Authorization header not provided")
 			return
 		}
 
 		tokenString := strings.TrimPrefix(authorizationHeader, "Bearer ")
 
 		if tokenString == "" {
-			c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Token not provided"))
+			writeProblem(c, http.StatusUnauthorized, codeUnauthorized, "Token not provided")
 			return
 		}
 
-		_, err := ValidateToken(tokenString, jwtKey, db)
+		userID, err := ValidateToken(c.Request.Context(), tokenString, jwtKey, db)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, formatter.UnauthorizedResponse("Invalid token"))
+			rejectToken(c, err)
 			return
 		}
+		identify(c, userID)
 
 		skillIDToUpdate := c.Param("id")
 		if skillIDToUpdate == "" {
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Skill id required"))
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Skill id required")
 			return
 		}
 
 		// Retrieve existing skill to check for image update
-		existingSkill, err := model.GetSkillID(db, skillIDToUpdate)
+		existingSkill, err := db.Skills().Get(c.Request.Context(), skillIDToUpdate)
 		if err != nil {
-			log.Printf("Error retrieving skill: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to retrieve skill"))
+			slog.ErrorContext(c.Request.Context(), "Error retrieving skill", "error", err)
+			respondError(c, err, "Failed to retrieve skill")
+			return
+		}
+
+		version, ok := requireIfMatch(c, existingSkill.Version)
+		if !ok {
 			return
 		}
+		existingSkill.Version = version
 
 		// Parse form data
 		if err := c.Request.ParseForm(); err != nil {
-			c.JSON(http.StatusBadRequest, formatter.BadRequestResponse("Error parsing form data"))
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Error parsing form data")
 			return
 		}
 
@@ -243,58 +264,101 @@ func UpdateSkill(db *sql.DB, jwtKey string) gin.HandlerFunc {
 			existingSkill.Name = newSkillName
 		}
 
+		if errs := model.Validate(existingSkill); len(errs) > 0 {
+			validationErrorResponse(c, errs)
+			return
+		}
+
 		// Assume new image is uploaded with form key 'image'
-		file, header, err := c.Request.FormFile("image")
-		if err == nil {
-			defer file.Close()
-
-			// Generate new image filename and save
-			newImageName := uuid.New().String() + filepath.Ext(header.Filename)
-			newImagePath := "uploads/skills/" + newImageName
-			if err := saveUploadedFile(header, newImagePath); err != nil {
-				log.Printf("Error saving new image: %v", err)
-				c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to save new image"))
+		header, _ := c.FormFile("image")
+
+		var upload *checkedImage
+		if header != nil {
+			var ok bool
+			if upload, ok = checkImage(c, "skills", header); !ok {
 				return
 			}
+		}
 
-			// Delete old image if new one is uploaded
-			if existingSkill.Image != "" {
-				oldImagePath := "./uploads/skills/" + existingSkill.Image
-				if err := os.Remove(oldImagePath); err != nil {
-					log.Printf("Error deleting old image file: %v", err)
-					c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to delete old image"))
-					// Continue to update the skill even if old image deletion fails
+		err = db.RunInTx(c.Request.Context(), func(tx repository.Tx) error {
+			if header != nil {
+				// Stage the new image and release the old one
+				if err := stageUpload(c.Request.Context(), db, tx, store, upload); err != nil {
+					slog.ErrorContext(c.Request.Context(), "Error saving new image", "error", err)
+					return err
+				}
+				if err := releaseUpload(c.Request.Context(), db, tx, store, "skills", existingSkill.Image); err != nil {
+					slog.ErrorContext(c.Request.Context(), "Error releasing image", "error", err)
+					return err
 				}
-			}
 
-			// Update skill record with new image name
-			existingSkill.Image = newImageName
-		}
+				// Update skill record with new image name
+				existingSkill.Image = upload.Key
+				existingSkill.ImageWidth = upload.Width
+			}
 
-		// Update skill in database
-		if err := model.UpdateSkill(db, existingSkill); err != nil {
-			log.Printf("Error updating skill: %v", err)
-			c.JSON(http.StatusInternalServerError, formatter.InternalServerErrorResponse("Failed to update skill"))
+			// Update skill in database
+			if err := tx.Skills().Update(c.Request.Context(), existingSkill); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error updating skill", "error", err)
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			respondError(c, err, "Failed to update skill")
 			return
 		}
 
+		setETag(c, existingSkill.Version)
+
 		c.JSON(http.StatusOK, formatter.SuccessResponse("Skill updated successfully"))
 	}
 }
 
-func saveUploadedFile(file *multipart.FileHeader, dst string) error {
-	src, err := file.Open()
-	if err != nil {
-		return err
-	}
-	defer src.Close()
+// skillPatch holds the skill fields a PATCH request may change
+type skillPatch struct {
+	Name string `json:"name"`
+}
 
-	out, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
+func PatchSkill(db repository.DB, jwtKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkUserLogin(c, jwtKey, db) {
+			return
+		}
 
-	_, err = io.Copy(out, src)
-	return err
+		skillID := c.Param("id")
+		if skillID == "" {
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Skill id required")
+			return
+		}
+
+		existingSkill, err := db.Skills().Get(c.Request.Context(), skillID)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error retrieving skill", "error", err)
+			respondError(c, err, "Failed to retrieve skill")
+			return
+		}
+
+		version, ok := requireIfMatch(c, existingSkill.Version)
+		if !ok {
+			return
+		}
+		existingSkill.Version = version
+
+		doc := skillPatch{Name: existingSkill.Name}
+		if !applyPatch(c, &doc) {
+			return
+		}
+		existingSkill.Name = doc.Name
+
+		if err := db.Skills().Update(c.Request.Context(), existingSkill); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error updating skill", "error", err)
+			respondError(c, err, "Failed to update skill")
+			return
+		}
+
+		setETag(c, existingSkill.Version)
+
+		c.JSON(http.StatusOK, formatter.SuccessResponse("Skill updated successfully"))
+	}
 }
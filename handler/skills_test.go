@@ -0,0 +1,131 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"portfolio/model"
+)
+
+func TestAddSkills(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+	id := s.createSkill(token, "Go")
+
+	w := s.expect(s.do(newCall("GET", "/api/v1/skills/"+id)), http.StatusOK)
+	var skill struct {
+		Name    string
+		Version int
+	}
+	data(t, w, &skill)
+	if skill.Name != "Go" || skill.Version != 1 {
+		t.Fatalf("skill = %+v, want Go at version 1", skill)
+	}
+	if etag := w.Header().Get("ETag"); etag != `"1"` {
+		t.Fatalf("ETag = %s, want \"1\"", etag)
+	}
+
+	s.expectProblem(s.do(newCall("POST", "/api/v1/skills").multipart(url.Values{"name": {"Go"}},
+		map[string][]byte{"image": pngImage(20)})), http.StatusUnauthorized, "unauthorized")
+	s.expectProblem(s.do(newCall("POST", "/api/v1/skills").auth(token).multipart(url.Values{},
+		map[string][]byte{"image": pngImage(20)})), http.StatusUnprocessableEntity, model.CodeValidation)
+	s.expectProblem(s.do(newCall("POST", "/api/v1/skills").auth(token).multipart(url.Values{"name": {"Go"}},
+		map[string][]byte{"image": []byte("not an image")})), http.StatusUnsupportedMediaType, "unsupported_media_type")
+}
+
+func TestGetSkill(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+	for _, name := range []string{"Go", "SQL", "Docker"} {
+		s.createSkill(token, name)
+	}
+
+	w := s.expect(s.do(newCall("GET", "/api/v1/skills?limit=2&offset=1")), http.StatusOK)
+	var skills []struct{ Name string }
+	data(t, w, &skills)
+	if len(skills) != 2 || skills[0].Name != "SQL" || skills[1].Name != "Docker" {
+		t.Fatalf("skills = %+v, want SQL and Docker", skills)
+	}
+
+	s.expectProblem(s.do(newCall("GET", "/api/v1/skills?limit=x")), http.StatusBadRequest, "bad_request")
+	s.expectProblem(s.do(newCall("GET", "/api/v1/skills/missing")), http.StatusNotFound, model.CodeNotFound)
+}
+
+func TestUpdateSkill(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+	id := s.createSkill(token, "Go")
+	target := "/api/v1/skills/" + id
+
+	update := func(ifMatch string, shade uint8) *call {
+		c := newCall("PUT", target).auth(token).multipart(url.Values{"name": {"Golang"}},
+			map[string][]byte{"image": pngImage(shade)})
+		if ifMatch != "" {
+			c.set("If-Match", ifMatch)
+		}
+		return c
+	}
+
+	s.expectProblem(s.do(update("", 21)), http.StatusPreconditionRequired, "precondition_required")
+
+	w := s.expect(s.do(update(s.etag(target), 21)), http.StatusOK)
+	if etag := w.Header().Get("ETag"); etag != `"2"` {
+		t.Fatalf("ETag = %s, want \"2\"", etag)
+	}
+	// The new image replaced the old one, next to the user's
+	if n := s.files("blobs"); n != 2 {
+		t.Fatalf("stored %d blobs, want 2", n)
+	}
+
+	w = s.do(update(`"1"`, 22))
+	s.expectProblem(w, http.StatusPreconditionFailed, model.CodePreconditionFailed)
+	if etag := w.Header().Get("ETag"); etag != `"2"` {
+		t.Fatalf("ETag = %s, want the current \"2\"", etag)
+	}
+
+	s.expectProblem(s.do(newCall("PUT", "/api/v1/skills/missing").auth(token).set("If-Match", "*").
+		multipart(url.Values{"name": {"Golang"}}, nil)), http.StatusNotFound, model.CodeNotFound)
+}
+
+func TestPatchSkill(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+	id := s.createSkill(token, "Go")
+	target := "/api/v1/skills/" + id
+
+	patch := func(body string) *call {
+		return newCall("PATCH", target).auth(token).raw("application/merge-patch+json", []byte(body))
+	}
+
+	s.expectProblem(s.do(patch(`{"name":"Golang"}`)), http.StatusPreconditionRequired, "precondition_required")
+	s.expect(s.do(patch(`{"name":"Golang"}`).set("If-Match", s.etag(target))), http.StatusOK)
+
+	w := s.expect(s.do(newCall("GET", target)), http.StatusOK)
+	var skill struct{ Name string }
+	data(t, w, &skill)
+	if skill.Name != "Golang" {
+		t.Fatalf("name = %q, want Golang", skill.Name)
+	}
+
+	s.expectProblem(s.do(patch(`{"name":null}`).set("If-Match", "*")), http.StatusUnprocessableEntity, model.CodeValidation)
+	s.expectProblem(s.do(patch(`{"name":"Go"}`).set("If-Match", `"1"`)), http.StatusPreconditionFailed, model.CodePreconditionFailed)
+}
+
+func TestDeleteSkill(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+	id := s.createSkill(token, "Go")
+	target := "/api/v1/skills/" + id
+
+	s.expectProblem(s.do(newCall("DELETE", target).auth(token)), http.StatusPreconditionRequired, "precondition_required")
+	s.expectProblem(s.do(newCall("DELETE", target).auth(token).set("If-Match", `"7"`)), http.StatusPreconditionFailed, model.CodePreconditionFailed)
+
+	// A portfolio still uses the skill
+	portfolioID := s.createPortfolio(token, "", id)
+	s.expectProblem(s.do(newCall("DELETE", target).auth(token).set("If-Match", `"1"`)), http.StatusConflict, model.CodeConflict)
+
+	s.expect(s.do(newCall("POST", "/api/v1/portfolio-skill/"+portfolioID).auth(token).set("If-Match", "*").form(url.Values{"skill_id": {id}})), http.StatusOK)
+	s.expect(s.do(newCall("DELETE", target).auth(token).set("If-Match", `"1"`)), http.StatusOK)
+	s.expectProblem(s.do(newCall("GET", target)), http.StatusNotFound, model.CodeNotFound)
+}
@@ -0,0 +1,407 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"portfolio/media"
+	"portfolio/metrics"
+	"portfolio/model"
+	"portfolio/publicurl"
+	"portfolio/repository"
+	"portfolio/storage"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Resumable uploads speak the tus protocol, see
+// https://tus.io/protocols/resumable-upload
+const (
+	tusVersion     = "1.0.0"
+	tusExtensions  = "creation,expiration,termination"
+	tusContentType = "application/offset+octet-stream"
+)
+
+// TusHeaders lists the request and response headers of the tus protocol,
+// for CORS
+var TusHeaders = []string{
+	"Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size",
+	"Upload-Length", "Upload-Offset", "Upload-Metadata", "Upload-Expires", "Location",
+}
+
+// TusConfig tunes the resumable upload endpoint.
+type TusConfig struct {
+	// MaxSize is the largest Upload-Length accepted.
+	MaxSize int64
+	// Expiry is how long an upload can be resumed and referenced after
+	// it was created.
+	Expiry time.Duration
+}
+
+// TusOptions advertises the protocol version and extensions supported.
+func TusOptions(cfg TusConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Tus-Resumable", tusVersion)
+		c.Header("Tus-Version", tusVersion)
+		c.Header("Tus-Extension", tusExtensions)
+		c.Header("Tus-Max-Size", strconv.FormatInt(cfg.MaxSize, 10))
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// CreateUpload starts an upload of Upload-Length bytes and points the
+// client to it with the Location header.
+func CreateUpload(db repository.DB, jwtKey string, cfg TusConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkTusResumable(c) {
+			return
+		}
+		userID, ok := authenticate(c, jwtKey, db)
+		if !ok {
+			return
+		}
+
+		length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+		if err != nil || length < 0 {
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Upload-Length must be a non-negative integer")
+			return
+		}
+		if length > cfg.MaxSize {
+			respondError(c, &media.TooLargeError{Size: length, Max: cfg.MaxSize}, "")
+			return
+		}
+
+		metadata := c.GetHeader("Upload-Metadata")
+		if _, err := parseTusMetadata(metadata); err != nil {
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, err.Error())
+			return
+		}
+
+		upload := model.Upload{
+			ID:        uuid.New().String(),
+			UserID:    userID,
+			Length:    length,
+			Metadata:  metadata,
+			ExpiresAt: time.Now().Add(cfg.Expiry),
+		}
+		if err := db.Uploads().Insert(c.Request.Context(), &upload); err != nil {
+			respondError(c, err, "Failed to create upload")
+			return
+		}
+
+		c.Header("Location", publicurl.API(c, "/api/v1/uploads/"+upload.ID))
+		setUploadHeaders(c, &upload)
+		c.Status(http.StatusCreated)
+	}
+}
+
+// UploadStatus reports how many bytes of an upload were received, so the
+// client knows where to resume.
+func UploadStatus(db repository.DB, jwtKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkTusResumable(c) {
+			return
+		}
+		userID, ok := authenticate(c, jwtKey, db)
+		if !ok {
+			return
+		}
+
+		upload, err := db.Uploads().Get(c.Request.Context(), c.Param("id"), userID)
+		if err != nil {
+			respondError(c, err, "Failed to retrieve upload")
+			return
+		}
+
+		c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+		if upload.Metadata != "" {
+			c.Header("Upload-Metadata", upload.Metadata)
+		}
+		c.Header("Cache-Control", "no-store")
+		setUploadHeaders(c, upload)
+		c.Status(http.StatusOK)
+	}
+}
+
+// AppendUpload stores the request body as the bytes of an upload starting
+// at Upload-Offset, which must be the number of bytes received so far. A
+// body cut off halfway still counts up to where it ended, so the client
+// resumes from there. The row is locked only to check and to commit the
+// offset: concurrent requests each stream into a part of their own, and
+// only the first to record its part for the offset succeeds.
+func AppendUpload(db repository.DB, store storage.Storage, jwtKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkTusResumable(c) {
+			return
+		}
+		userID, ok := authenticate(c, jwtKey, db)
+		if !ok {
+			return
+		}
+
+		if c.ContentType() != tusContentType {
+			writeProblem(c, http.StatusUnsupportedMediaType, codeUnsupportedMediaType, "Content-Type must be "+tusContentType)
+			return
+		}
+		offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, "Upload-Offset must be a non-negative integer")
+			return
+		}
+
+		id := c.Param("id")
+		ctx := c.Request.Context()
+		var upload *model.Upload
+		err = db.RunInTx(ctx, func(tx repository.Tx) error {
+			upload, err = tx.Uploads().Lock(ctx, id, userID)
+			if err != nil {
+				return err
+			}
+			return checkOffset(upload, offset, c.Request.ContentLength)
+		})
+		if err != nil {
+			respondError(c, err, "Failed to store upload")
+			return
+		}
+
+		// What arrived is kept even when the client goes away, so the part
+		// and the offset are written without the request's cancellation
+		keep := context.WithoutCancel(ctx)
+		remaining := upload.Length - upload.Offset
+		body := &partialReader{r: io.LimitReader(c.Request.Body, remaining)}
+		key := upload.PartKey(offset, uuid.New().String())
+		if err := store.Put(keep, key, body, -1, "application/octet-stream"); err != nil {
+			slog.ErrorContext(ctx, "Error storing upload part", "error", err)
+			respondError(c, err, "Failed to store upload")
+			return
+		}
+		// A part that is not recorded is never read, so it goes right away
+		recorded := false
+		defer func() {
+			if !recorded {
+				if err := store.Delete(keep, key); err != nil {
+					slog.WarnContext(ctx, "Error deleting unused upload part", "key", key, "error", err)
+				}
+			}
+		}()
+		// A body without Content-Length may still run past the end
+		if body.err == nil && body.n == remaining {
+			if n, _ := c.Request.Body.Read(make([]byte, 1)); n > 0 {
+				respondError(c, &media.TooLargeError{Size: upload.Length + 1, Max: upload.Length}, "")
+				return
+			}
+		}
+
+		if body.n > 0 {
+			err = db.RunInTx(keep, func(tx repository.Tx) error {
+				latest, err := tx.Uploads().Lock(keep, id, userID)
+				if err != nil {
+					return err
+				}
+				if err := checkOffset(latest, offset, 0); err != nil {
+					return err
+				}
+				if err := tx.Uploads().AddPart(keep, latest.ID, offset, key); err != nil {
+					return err
+				}
+				latest.Offset += body.n
+				upload = latest
+				return tx.Uploads().UpdateOffset(keep, upload.ID, upload.Offset)
+			})
+			if err != nil {
+				respondError(c, err, "Failed to store upload")
+				return
+			}
+			recorded = true
+			metrics.AddTusBytes(body.n)
+		}
+
+		setUploadHeaders(c, upload)
+		if body.err != nil {
+			slog.InfoContext(ctx, "Upload body cut off", "upload_id", upload.ID, "received", body.n, "error", body.err)
+			if ctx.Err() != nil {
+				respondError(c, ctx.Err(), "")
+				return
+			}
+			writeProblem(c, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("The request body broke off after %d bytes, resume from Upload-Offset", body.n))
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// checkOffset rejects an append that does not continue where upload stands
+// or that announces more bytes than are left
+func checkOffset(upload *model.Upload, offset, contentLength int64) error {
+	if offset != upload.Offset {
+		return &model.ConflictError{Resource: "upload", Message: fmt.Sprintf("Upload-Offset must be %d", upload.Offset)}
+	}
+	if remaining := upload.Length - upload.Offset; contentLength > remaining {
+		return &media.TooLargeError{Size: upload.Offset + contentLength, Max: upload.Length}
+	}
+	return nil
+}
+
+// TerminateUpload discards an upload and the bytes received for it.
+func TerminateUpload(db repository.DB, store storage.Storage, jwtKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkTusResumable(c) {
+			return
+		}
+		userID, ok := authenticate(c, jwtKey, db)
+		if !ok {
+			return
+		}
+
+		err := db.RunInTx(c.Request.Context(), func(tx repository.Tx) error {
+			upload, err := tx.Uploads().Lock(c.Request.Context(), c.Param("id"), userID)
+			if err != nil {
+				return err
+			}
+			if err := tx.Uploads().Delete(c.Request.Context(), upload.ID); err != nil {
+				return err
+			}
+			tx.OnCommit(func() { deleteUploadParts(c.Request.Context(), store, upload) })
+			return nil
+		})
+		if err != nil {
+			respondError(c, err, "Failed to terminate upload")
+			return
+		}
+
+		c.Header("Tus-Resumable", tusVersion)
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// checkTusResumable rejects requests for a protocol version other than
+// the one supported
+func checkTusResumable(c *gin.Context) bool {
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		writeProblem(c, http.StatusPreconditionFailed, codeBadRequest, "Tus-Resumable must be "+tusVersion)
+		return false
+	}
+	return true
+}
+
+func setUploadHeaders(c *gin.Context, upload *model.Upload) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma separated
+// keys, each followed by a space and its base64 encoded value if it has one
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("Upload-Metadata has an empty key")
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("Upload-Metadata value of %s is not base64", key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// openUpload returns the bytes of a finished upload by chaining its
+// recorded parts. Parts left behind by appends that did not commit are
+// never read.
+func openUpload(ctx context.Context, db repository.DB, store storage.Storage, upload *model.Upload) (io.ReadCloser, error) {
+	keys, err := db.Uploads().Parts(ctx, upload.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 && upload.Length > 0 {
+		return nil, fmt.Errorf("upload %s has no recorded parts", upload.ID)
+	}
+	return &partsReader{ctx: ctx, store: store, keys: keys}, nil
+}
+
+// deleteUploadParts removes the stored bytes of upload. It runs after the
+// response is decided, so it must not be cancelled together with the
+// request.
+func deleteUploadParts(ctx context.Context, store storage.Storage, upload *model.Upload) {
+	ctx = context.WithoutCancel(ctx)
+	err := store.Walk(ctx, upload.PartsPrefix(), func(obj storage.Object) error {
+		return store.Delete(ctx, obj.Key)
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error deleting parts of upload", "upload_id", upload.ID, "error", err)
+	}
+}
+
+// partsReader reads the stored parts of an upload one after another
+type partsReader struct {
+	ctx   context.Context
+	store storage.Storage
+	keys  []string
+	cur   io.ReadCloser
+}
+
+func (r *partsReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if len(r.keys) == 0 {
+				return 0, io.EOF
+			}
+			cur, err := r.store.Get(r.ctx, r.keys[0])
+			if err != nil {
+				return 0, err
+			}
+			r.cur, r.keys = cur, r.keys[1:]
+		}
+
+		n, err := r.cur.Read(p)
+		if err == io.EOF {
+			r.cur.Close()
+			r.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *partsReader) Close() error {
+	if r.cur == nil {
+		return nil
+	}
+	return r.cur.Close()
+}
+
+// partialReader counts the bytes read and ends the stream at the first
+// error, which it keeps, so the bytes before it can still be stored
+type partialReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (r *partialReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if err != nil && err != io.EOF {
+		r.err, err = err, io.EOF
+	}
+	return n, err
+}
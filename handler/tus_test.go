@@ -0,0 +1,175 @@
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"portfolio/model"
+)
+
+// tus starts a request of the resumable upload protocol
+func tus(method, target, token string) *call {
+	return newCall(method, target).auth(token).set("Tus-Resumable", "1.0.0")
+}
+
+// createUpload announces an upload of length bytes and returns its id
+func (s *server) createUpload(token string, length int) string {
+	s.t.Helper()
+	w := s.expect(s.do(tus("POST", "/api/v1/uploads", token).
+		set("Upload-Length", strconv.Itoa(length)).
+		set("Upload-Metadata", "filename c2hvdC5wbmc=")), http.StatusCreated)
+
+	location := w.Header().Get("Location")
+	prefix := testBaseURL + "/api/v1/uploads/"
+	if !strings.HasPrefix(location, prefix) {
+		s.t.Fatalf("Location = %q, want it under %s", location, prefix)
+	}
+	return strings.TrimPrefix(location, prefix)
+}
+
+// appendUpload sends chunk as the bytes of upload id from offset
+func appendUpload(token, id string, offset int, chunk []byte) *call {
+	return tus("PATCH", "/api/v1/uploads/"+id, token).
+		set("Upload-Offset", strconv.Itoa(offset)).
+		raw("application/offset+octet-stream", chunk)
+}
+
+func TestTusOptions(t *testing.T) {
+	s := newServer(t)
+	w := s.expect(s.do(newCall("OPTIONS", "/api/v1/uploads")), http.StatusNoContent)
+	if v := w.Header().Get("Tus-Version"); v != "1.0.0" {
+		t.Fatalf("Tus-Version = %q, want 1.0.0", v)
+	}
+	if v := w.Header().Get("Tus-Max-Size"); v != strconv.Itoa(1<<20) {
+		t.Fatalf("Tus-Max-Size = %q, want %d", v, 1<<20)
+	}
+}
+
+func TestCreateUpload(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+	s.createUpload(token, 10)
+
+	s.expectProblem(s.do(newCall("POST", "/api/v1/uploads").auth(token).set("Upload-Length", "10")), http.StatusPreconditionFailed, "bad_request")
+	s.expectProblem(s.do(tus("POST", "/api/v1/uploads", "").set("Upload-Length", "10")), http.StatusUnauthorized, "unauthorized")
+	s.expectProblem(s.do(tus("POST", "/api/v1/uploads", token).set("Upload-Length", "-1")), http.StatusBadRequest, "bad_request")
+	s.expectProblem(s.do(tus("POST", "/api/v1/uploads", token).set("Upload-Length", strconv.Itoa(1<<20+1))), http.StatusRequestEntityTooLarge, "payload_too_large")
+	s.expectProblem(s.do(tus("POST", "/api/v1/uploads", token).set("Upload-Length", "10").set("Upload-Metadata", "filename !!")), http.StatusBadRequest, "bad_request")
+}
+
+func TestAppendUpload(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+	file := pngImage(60)
+	id := s.createUpload(token, len(file))
+	half := len(file) / 2
+
+	w := s.expect(s.do(appendUpload(token, id, 0, file[:half])), http.StatusNoContent)
+	if v := w.Header().Get("Upload-Offset"); v != strconv.Itoa(half) {
+		t.Fatalf("Upload-Offset = %q, want %d", v, half)
+	}
+
+	w = s.expect(s.do(tus("HEAD", "/api/v1/uploads/"+id, token)), http.StatusOK)
+	if v := w.Header().Get("Upload-Offset"); v != strconv.Itoa(half) {
+		t.Fatalf("HEAD Upload-Offset = %q, want %d", v, half)
+	}
+	if v := w.Header().Get("Upload-Length"); v != strconv.Itoa(len(file)) {
+		t.Fatalf("HEAD Upload-Length = %q, want %d", v, len(file))
+	}
+
+	// An unfinished upload cannot be used yet
+	fields := url.Values{"title": {"Uploaded"}, "date_project": {"2023-05-01"}, "skill_ids": {s.createSkill(token, "Go")}, "upload_id": {id}}
+	s.expectProblem(s.do(newCall("POST", "/api/v1/portfolio").auth(token).multipart(fields, nil)), http.StatusConflict, model.CodeConflict)
+
+	s.expectProblem(s.do(appendUpload(token, id, 0, file[half:])), http.StatusConflict, model.CodeConflict)
+	s.expectProblem(s.do(appendUpload(token, id, half, append(file[half:], 0))), http.StatusRequestEntityTooLarge, "payload_too_large")
+	s.expectProblem(s.do(appendUpload(token, id, half, file[half:]).set("Content-Type", "application/octet-stream")), http.StatusUnsupportedMediaType, "unsupported_media_type")
+	s.expectProblem(s.do(appendUpload(token, id, half, file[half:]).set("Upload-Offset", "x")), http.StatusBadRequest, "bad_request")
+	// A request on another instance that lost the race for the same
+	// offset leaves a part of its own, which is never read
+	stale := bytes.Repeat([]byte{0xFF}, len(file)-half)
+	staleKey := (&model.Upload{ID: id}).PartKey(int64(half), "stale")
+	if err := s.store.Put(context.Background(), staleKey, bytes.NewReader(stale), int64(len(stale)), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.expect(s.do(appendUpload(token, id, half, file[half:])), http.StatusNoContent)
+
+	// The finished upload becomes the portfolio image and its parts go away
+	w = s.expect(s.do(newCall("POST", "/api/v1/portfolio").auth(token).multipart(fields, nil)), http.StatusOK)
+	var portfolio struct{ Image struct{ Src string } }
+	data(t, w, &portfolio)
+	if portfolio.Image.Src == "" {
+		t.Fatal("portfolio from an upload has no image")
+	}
+	if n := s.files(model.UploadPrefix); n != 0 {
+		t.Fatalf("%d upload parts left after use, want 0", n)
+	}
+	s.expectProblem(s.do(tus("HEAD", "/api/v1/uploads/"+id, token)), http.StatusNotFound, model.CodeNotFound)
+}
+
+func TestAppendUploadCutOff(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+	file := pngImage(90)
+	id := s.createUpload(token, len(file))
+	cut := len(file) / 3
+
+	// The bytes that arrived before the body broke off are kept
+	w := s.do(appendUpload(token, id, 0, file).cutOff(cut))
+	s.expectProblem(w, http.StatusBadRequest, "bad_request")
+	if v := w.Header().Get("Upload-Offset"); v != strconv.Itoa(cut) {
+		t.Fatalf("Upload-Offset = %q, want %d", v, cut)
+	}
+	w = s.expect(s.do(tus("HEAD", "/api/v1/uploads/"+id, token)), http.StatusOK)
+	if v := w.Header().Get("Upload-Offset"); v != strconv.Itoa(cut) {
+		t.Fatalf("HEAD Upload-Offset = %q, want %d", v, cut)
+	}
+
+	s.expectProblem(s.do(appendUpload(token, id, 0, file)), http.StatusConflict, model.CodeConflict)
+	s.expect(s.do(appendUpload(token, id, cut, file[cut:])), http.StatusNoContent)
+
+	// The parts add up to the whole image again
+	fields := url.Values{"title": {"Resumed"}, "date_project": {"2023-05-01"}, "skill_ids": {s.createSkill(token, "Go")}, "upload_id": {id}}
+	w = s.expect(s.do(newCall("POST", "/api/v1/portfolio").auth(token).multipart(fields, nil)), http.StatusOK)
+	var portfolio struct{ Image struct{ Src string } }
+	data(t, w, &portfolio)
+	if portfolio.Image.Src == "" {
+		t.Fatal("portfolio from a resumed upload has no image")
+	}
+}
+
+func TestUploadOwnership(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+	id := s.createUpload(token, 4)
+
+	s.register("Budi", "budi@example.com", "secret")
+	other := s.login("budi@example.com", "secret")
+
+	s.expectProblem(s.do(tus("HEAD", "/api/v1/uploads/"+id, other)), http.StatusForbidden, model.CodeForbidden)
+	s.expectProblem(s.do(appendUpload(other, id, 0, []byte("data"))), http.StatusForbidden, model.CodeForbidden)
+	s.expectProblem(s.do(tus("DELETE", "/api/v1/uploads/"+id, other)), http.StatusForbidden, model.CodeForbidden)
+	s.expectProblem(s.do(tus("HEAD", "/api/v1/uploads/missing", token)), http.StatusNotFound, model.CodeNotFound)
+}
+
+func TestTerminateUpload(t *testing.T) {
+	s := newServer(t)
+	token := s.user()
+	id := s.createUpload(token, 8)
+	s.expect(s.do(appendUpload(token, id, 0, []byte("data"))), http.StatusNoContent)
+	if n := s.files(model.UploadPrefix); n != 1 {
+		t.Fatalf("stored %d upload parts, want 1", n)
+	}
+
+	s.expect(s.do(tus("DELETE", "/api/v1/uploads/"+id, token)), http.StatusNoContent)
+	if n := s.files(model.UploadPrefix); n != 0 {
+		t.Fatalf("%d upload parts left after terminate, want 0", n)
+	}
+	s.expectProblem(s.do(tus("HEAD", "/api/v1/uploads/"+id, token)), http.StatusNotFound, model.CodeNotFound)
+	s.expectProblem(s.do(tus("DELETE", "/api/v1/uploads/"+id, token)), http.StatusNotFound, model.CodeNotFound)
+}
@@ -0,0 +1,431 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"portfolio/media"
+	"portfolio/metrics"
+	"portfolio/model"
+	"portfolio/publicurl"
+	"portfolio/repository"
+	"portfolio/storage"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// checkedImage is an upload that passed checkImage, with its metadata
+// stripped and ready to be stored
+type checkedImage struct {
+	Key   string
+	Width int
+	Data  []byte
+	// Upload is the resumable upload the image came from, consumed once
+	// the image is stored
+	Upload *model.Upload
+}
+
+// imageFromRequest returns the image sent with the request: either the
+// image form file or, by its upload_id, a finished resumable upload of
+// userID. It returns nil when the request carries neither and aborts
+// with the matching problem when the image is rejected.
+func imageFromRequest(c *gin.Context, db repository.DB, store storage.Storage, entity, userID string) (*checkedImage, bool) {
+	file, err := c.FormFile("image")
+	if err != nil && err != http.ErrMissingFile && err != http.ErrNotMultipart {
+		slog.ErrorContext(c.Request.Context(), "Error retrieving image file", "error", err)
+		writeProblem(c, http.StatusBadRequest, codeBadRequest, "Failed to retrieve image file")
+		return nil, false
+	}
+	if file != nil {
+		return checkImage(c, entity, file)
+	}
+
+	if uploadID := c.PostForm("upload_id"); uploadID != "" {
+		return checkUploadedImage(c, db, store, entity, uploadID, userID)
+	}
+	return nil, true
+}
+
+// checkImage sniffs an uploaded image against the limits configured for
+// entity and strips EXIF, XMP and ICC data from it. The result is keyed
+// by the SHA-256 of the sanitized bytes and the extension of its real
+// type. It aborts with the matching problem otherwise.
+func checkImage(c *gin.Context, entity string, file *multipart.FileHeader) (*checkedImage, bool) {
+	src, err := file.Open()
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error opening uploaded file", "error", err)
+		writeProblem(c, http.StatusBadRequest, codeBadRequest, "Failed to read uploaded file")
+		return nil, false
+	}
+	defer src.Close()
+
+	info, err := media.Inspect(src, file.Size, media.LimitsFor(entity))
+	if err != nil {
+		respondError(c, err, "Failed to read uploaded file")
+		return nil, false
+	}
+
+	// Inspect enforced MaxBytes, so the file fits in memory
+	data, err := io.ReadAll(src)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error reading uploaded file", "error", err)
+		writeProblem(c, http.StatusBadRequest, codeBadRequest, "Failed to read uploaded file")
+		return nil, false
+	}
+	return sanitizeImage(c, info, data)
+}
+
+// checkUploadedImage is checkImage for a finished resumable upload
+func checkUploadedImage(c *gin.Context, db repository.DB, store storage.Storage, entity, uploadID, userID string) (*checkedImage, bool) {
+	upload, ok := finishedUpload(c, db, uploadID, userID)
+	if !ok {
+		return nil, false
+	}
+
+	limits := media.LimitsFor(entity)
+	if upload.Length > limits.MaxBytes {
+		respondError(c, &media.TooLargeError{Size: upload.Length, Max: limits.MaxBytes}, "")
+		return nil, false
+	}
+
+	src, err := openUpload(c.Request.Context(), db, store, upload)
+	if err != nil {
+		respondError(c, err, "Failed to read upload")
+		return nil, false
+	}
+	data, err := io.ReadAll(src)
+	src.Close()
+	if err != nil {
+		respondError(c, err, "Failed to read upload")
+		return nil, false
+	}
+
+	img, ok := inspectImage(c, limits, data)
+	if ok {
+		img.Upload = upload
+	}
+	return img, ok
+}
+
+// finishedUpload returns the resumable upload of userID with uploadID,
+// aborting unless every byte of it was received
+func finishedUpload(c *gin.Context, db repository.DB, uploadID, userID string) (*model.Upload, bool) {
+	upload, err := db.Uploads().Get(c.Request.Context(), uploadID, userID)
+	if err != nil {
+		respondError(c, err, "Failed to retrieve upload")
+		return nil, false
+	}
+	if !upload.Done() {
+		writeProblem(c, http.StatusConflict, model.CodeConflict, fmt.Sprintf("upload %s has %d of %d bytes", upload.ID, upload.Offset, upload.Length))
+		return nil, false
+	}
+	return upload, true
+}
+
+// inspectImage is checkImage for bytes already in memory
+func inspectImage(c *gin.Context, limits media.Limits, data []byte) (*checkedImage, bool) {
+	info, err := media.Inspect(bytes.NewReader(data), int64(len(data)), limits)
+	if err != nil {
+		respondError(c, err, "Failed to read uploaded file")
+		return nil, false
+	}
+	return sanitizeImage(c, info, data)
+}
+
+func sanitizeImage(c *gin.Context, info *media.Info, data []byte) (*checkedImage, bool) {
+	data, err := media.Sanitize(data, info)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error sanitizing uploaded file", "error", err)
+		writeProblem(c, http.StatusUnprocessableEntity, model.CodeInvalid, "Image file is corrupt")
+		return nil, false
+	}
+
+	sum := sha256.Sum256(data)
+	return &checkedImage{
+		Key:   model.BlobPrefix + hex.EncodeToString(sum[:]) + info.Ext,
+		Width: info.Width,
+		Data:  data,
+	}, true
+}
+
+// stageUpload references the blob of img within tx, storing the file
+// and its resized variants first unless identical content is already
+// stored. If tx rolls back, the blob is collected again when nothing
+// else took a reference in the meantime. A resumable upload img came
+// from is discarded once tx commits.
+func stageUpload(ctx context.Context, db repository.DB, tx repository.Tx, store storage.Storage, img *checkedImage) error {
+	metrics.ObserveUpload(media.KindImage, int64(len(img.Data)))
+	return stageBlob(ctx, db, tx, store, img.Key, int64(len(img.Data)), img.Upload, func() error {
+		return storeUpload(ctx, store, img)
+	})
+}
+
+// stageBlob is stageUpload for any blob, stored by put
+func stageBlob(ctx context.Context, db repository.DB, tx repository.Tx, store storage.Storage, key string, size int64, upload *model.Upload, put func() error) error {
+	created, err := db.Blobs().Ensure(ctx, key, size)
+	if err != nil {
+		return err
+	}
+	if created {
+		if err := put(); err != nil {
+			collectBlob(ctx, db, store, key)
+			return err
+		}
+	}
+
+	tx.OnRollback(func() { collectBlob(ctx, db, store, key) })
+	if err := tx.Blobs().Retain(ctx, key); err != nil {
+		return err
+	}
+
+	if upload != nil {
+		if err := tx.Uploads().Delete(ctx, upload.ID); err != nil {
+			return err
+		}
+		tx.OnCommit(func() { deleteUploadParts(ctx, store, upload) })
+	}
+	return nil
+}
+
+func storeUpload(ctx context.Context, store storage.Storage, img *checkedImage) error {
+	// key carries the sniffed extension, unlike the client supplied header
+	contentType := mime.TypeByExtension(path.Ext(img.Key))
+	if err := store.Put(ctx, img.Key, bytes.NewReader(img.Data), int64(len(img.Data)), contentType); err != nil {
+		return err
+	}
+
+	renditions, err := media.Generate(bytes.NewReader(img.Data), img.Key)
+	if err != nil {
+		return err
+	}
+	for _, r := range renditions {
+		if err := store.Put(ctx, r.Key, bytes.NewReader(r.Data), int64(len(r.Data)), r.ContentType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkedMedia is a gallery file that passed mediaFromRequest. Images are
+// checked and stored like any other image; attachments are stored as sent
+// and streamed from the request or upload rather than held in memory.
+type checkedMedia struct {
+	Kind        string
+	Key         string
+	ContentType string
+	Size        int64
+	Width       int
+
+	image  *checkedImage
+	open   func() (io.ReadCloser, error)
+	upload *model.Upload
+}
+
+// mediaFromRequest is imageFromRequest for a gallery file, which comes as
+// the file form field and may also be a document or a video
+func mediaFromRequest(c *gin.Context, db repository.DB, store storage.Storage, userID string) (*checkedMedia, bool) {
+	m := &checkedMedia{}
+
+	file, err := c.FormFile("file")
+	if err != nil && err != http.ErrMissingFile && err != http.ErrNotMultipart {
+		slog.ErrorContext(c.Request.Context(), "Error retrieving media file", "error", err)
+		writeProblem(c, http.StatusBadRequest, codeBadRequest, "Failed to retrieve media file")
+		return nil, false
+	}
+	switch uploadID := c.PostForm("upload_id"); {
+	case file != nil:
+		m.Size = file.Size
+		m.open = func() (io.ReadCloser, error) { return file.Open() }
+	case uploadID != "":
+		upload, ok := finishedUpload(c, db, uploadID, userID)
+		if !ok {
+			return nil, false
+		}
+		m.Size, m.upload = upload.Length, upload
+		m.open = func() (io.ReadCloser, error) { return openUpload(c.Request.Context(), db, store, upload) }
+	default:
+		return nil, true
+	}
+
+	src, err := m.open()
+	if err != nil {
+		respondError(c, err, "Failed to read uploaded file")
+		return nil, false
+	}
+	defer src.Close()
+
+	head := make([]byte, media.SniffLen)
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		respondError(c, err, "Failed to read uploaded file")
+		return nil, false
+	}
+	head = head[:n]
+	whole := io.MultiReader(bytes.NewReader(head), src)
+
+	m.Kind, m.ContentType, err = media.Classify(head)
+	if err != nil {
+		respondError(c, err, "Failed to read uploaded file")
+		return nil, false
+	}
+
+	if m.Kind == media.KindImage {
+		limits := media.LimitsFor("portfolio")
+		if m.Size > limits.MaxBytes {
+			respondError(c, &media.TooLargeError{Size: m.Size, Max: limits.MaxBytes}, "")
+			return nil, false
+		}
+		data, err := io.ReadAll(whole)
+		if err != nil {
+			respondError(c, err, "Failed to read uploaded file")
+			return nil, false
+		}
+		img, ok := inspectImage(c, limits, data)
+		if !ok {
+			return nil, false
+		}
+		img.Upload = m.upload
+		m.image, m.Key, m.Size, m.Width = img, img.Key, int64(len(img.Data)), img.Width
+		return m, true
+	}
+
+	limits := media.LimitsFor("attachments")
+	if limits.MaxBytes > 0 && m.Size > limits.MaxBytes {
+		respondError(c, &media.TooLargeError{Size: m.Size, Max: limits.MaxBytes}, "")
+		return nil, false
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, whole); err != nil {
+		respondError(c, err, "Failed to read uploaded file")
+		return nil, false
+	}
+	m.Key = model.BlobPrefix + hex.EncodeToString(hash.Sum(nil)) + media.AttachmentExt(m.ContentType)
+	return m, true
+}
+
+// stageMedia is stageUpload for a gallery file
+func stageMedia(ctx context.Context, db repository.DB, tx repository.Tx, store storage.Storage, m *checkedMedia) error {
+	if m.image != nil {
+		return stageUpload(ctx, db, tx, store, m.image)
+	}
+	metrics.ObserveUpload(m.Kind, m.Size)
+	return stageBlob(ctx, db, tx, store, m.Key, m.Size, m.upload, func() error {
+		src, err := m.open()
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		return store.Put(ctx, m.Key, src, m.Size, m.ContentType)
+	})
+}
+
+// releaseUpload drops the reference a row held on its image within tx.
+// Blobs are collected once tx commits and nothing references them
+// anymore; images from before blobs belong to that row alone and are
+// simply deleted.
+func releaseUpload(ctx context.Context, db repository.DB, tx repository.Tx, store storage.Storage, dir, image string) error {
+	if image == "" {
+		return nil
+	}
+
+	key := model.ImageKey(dir, image)
+	if !model.IsBlobKey(key) {
+		tx.OnCommit(func() { deleteUploadFiles(ctx, store, key) })
+		return nil
+	}
+
+	if err := tx.Blobs().Release(ctx, key); err != nil {
+		return err
+	}
+	tx.OnCommit(func() { collectBlob(ctx, db, store, key) })
+	return nil
+}
+
+// collectBlob runs once the response is decided, so it keeps the log
+// attributes of ctx but is not cancelled together with the request.
+func collectBlob(ctx context.Context, db repository.DB, store storage.Storage, key string) {
+	ctx = context.WithoutCancel(ctx)
+	err := db.Blobs().Collect(ctx, key, func() error {
+		return deleteUploadFiles(ctx, store, key)
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error collecting blob", "key", key, "error", err)
+	}
+}
+
+// deleteUploadFiles removes key and its variants. It runs after the
+// response is decided, so it must not be cancelled together with the
+// request.
+func deleteUploadFiles(ctx context.Context, store storage.Storage, key string) error {
+	ctx = context.WithoutCancel(ctx)
+	var firstErr error
+	for _, k := range append([]string{key}, media.VariantKeys(key)...) {
+		if err := store.Delete(ctx, k); err != nil {
+			slog.ErrorContext(ctx, "Error deleting file", "key", k, "error", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// imageSet expands an image column into the URLs of the
+// original and of the variants generated for its width
+func imageSet(c *gin.Context, store storage.Storage, dir, filename string, width int) *model.ImageSet {
+	if filename == "" {
+		return nil
+	}
+
+	key := model.ImageKey(dir, filename)
+	set := &model.ImageSet{Src: imageURL(c, store, key), Width: width}
+
+	fallback := media.FallbackExt(key)
+	var srcset, srcsetWebP []string
+	for _, v := range media.VariantsFor(width) {
+		variant := &model.ImageVariant{
+			URL:   imageURL(c, store, media.VariantKey(key, v.Name, fallback)),
+			WebP:  imageURL(c, store, media.VariantKey(key, v.Name, ".webp")),
+			Width: v.Width,
+		}
+		switch v.Name {
+		case "thumbnail":
+			set.Thumbnail = variant
+		case "medium":
+			set.Medium = variant
+		case "large":
+			set.Large = variant
+		}
+
+		descriptor := " " + strconv.Itoa(v.Width) + "w"
+		srcset = append(srcset, variant.URL+descriptor)
+		srcsetWebP = append(srcsetWebP, variant.WebP+descriptor)
+	}
+
+	if len(srcset) > 0 {
+		original := set.Src + " " + strconv.Itoa(width) + "w"
+		set.Srcset = strings.Join(append(srcset, original), ", ")
+		set.SrcsetWebP = strings.Join(srcsetWebP, ", ")
+	}
+	return set
+}
+
+// imageURL returns the download URL of key, resolving URLs served by the
+// API itself against its public asset URL
+func imageURL(c *gin.Context, store storage.Storage, key string) string {
+	url := store.URL(key)
+	if !strings.HasPrefix(url, "/") {
+		return url
+	}
+	return publicurl.Asset(c, url)
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"net/http"
+	"portfolio/model"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const dateLayout = "2006-01-02"
+
+// validationErrorResponse replies 422 with every failing field
+func validationErrorResponse(c *gin.Context, errs model.ValidationErrors) {
+	abortWithProblem(c, Problem{
+		Status: http.StatusUnprocessableEntity,
+		Code:   model.CodeValidation,
+		Detail: "One or more fields are invalid",
+		Errors: errs,
+	})
+}
+
+// parseDateField parses a yyyy-mm-dd form value, recording a field error
+// instead of failing so that every invalid field can be reported at once
+func parseDateField(errs *model.ValidationErrors, field, value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+
+	date, err := time.Parse(dateLayout, value)
+	if err != nil {
+		*errs = append(*errs, model.FieldError{
+			Field:   field,
+			Code:    model.CodeInvalidDate,
+			Message: "must be a date in yyyy-mm-dd format",
+		})
+	}
+	return date
+}
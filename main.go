@@ -1,153 +1,316 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io/fs"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"portfolio/config"
 	"portfolio/handler"
+	"portfolio/logging"
+	"portfolio/media"
+	"portfolio/metrics"
+	"portfolio/migrations"
+	"portfolio/model"
+	"portfolio/publicurl"
+	"portfolio/repository"
+	"portfolio/sshtunnel"
+	"portfolio/storage"
+	"portfolio/tracing"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/joho/godotenv"
-	"golang.org/x/crypto/ssh"
 )
 
-func CORSMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+// tracingFlushTimeout bounds sending the last spans on shutdown
+const tracingFlushTimeout = 5 * time.Second
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(http.StatusOK)
-			return
-		}
+// imageCachePruneInterval is how often the image cache is pruned
+const imageCachePruneInterval = 10 * time.Minute
+
+func main() {
+	// Until the logger is set up, errors go to stderr as plain text
 
-		c.Next()
+	// Muat file .env bila ada
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "Cannot load .env: %v\n", err)
+		os.Exit(1)
 	}
-}
 
-func main() {
-	// Muat file .env
-	err := godotenv.Load()
+	cfg, args, printConfig, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
 	if err != nil {
-		fmt.Printf("Error loading env file %v\n", err)
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "Cannot load the configuration: %v\n", err)
+		os.Exit(2)
+	}
+	if printConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot print the configuration: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(2)
 	}
 
-	// Konfigurasi koneksi SSH
-	config := &ssh.ClientConfig{
-		User: os.Getenv("HOST_USER"),
-		Auth: []ssh.AuthMethod{
+	if len(args) > 0 && args[0] != "migrate" && args[0] != "gc" {
+		fmt.Fprintf(os.Stderr, "Unknown command %q, expected migrate or gc\n", args[0])
+		os.Exit(2)
+	}
 
-			ssh.Password(os.Getenv("HOST_PASSWORD")),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	// Subcommands keep stdout for their output
+	logOutput := os.Stdout
+	if len(args) > 0 {
+		logOutput = os.Stderr
+	}
+	level, _ := logging.ParseLevel(cfg.Log.Level)
+	logging.Setup(logging.New(logOutput, level, cfg.Log.Format))
+	if level > slog.LevelDebug {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	gin.DebugPrintFunc = func(format string, values ...interface{}) {
+		slog.Debug("gin: " + strings.TrimSpace(fmt.Sprintf(format, values...)))
 	}
 
-	// Buat koneksi SSH ke server
-	conn, err := ssh.Dial("tcp", os.Getenv("HOST_SERVER"), config)
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		SampleRatio: cfg.Tracing.SampleRatio,
+		ServiceName: cfg.Tracing.ServiceName,
+		Version:     version,
+	})
 	if err != nil {
-		log.Fatalf("Gagal terhubung ke server SSH: %v", err)
+		fatal("Cannot set up tracing", err)
 	}
-	defer conn.Close()
 
-	connStr, err := loadPostgresConfig()
+	pgConfig, err := pgx.ParseConfig(cfg.Database.DSN())
 	if err != nil {
-		fmt.Printf("Gagal membuat koneksi database %v\n", err)
-		os.Exit(1)
+		fatal("Invalid database configuration", err)
 	}
-	// db, err := sql.Open("pgx", os.Getenv("DB_URI"))
-	db, err := sql.Open("pgx", connStr)
-	if err != nil {
-		fmt.Printf("Gagal membuat koneksi database %v\n", err)
-		os.Exit(1)
+
+	// Opsional: akses database lewat tunnel SSH
+	var tunnel *sshtunnel.Tunnel
+	if cfg.SSH.Enabled {
+		tunnel, err = sshtunnel.Open(sshtunnel.Config{
+			Addr:           cfg.SSH.Server,
+			User:           cfg.SSH.User,
+			Password:       cfg.SSH.Password,
+			KeyFile:        cfg.SSH.KeyFile,
+			KeyPassphrase:  cfg.SSH.KeyPassphrase,
+			KnownHostsFile: cfg.SSH.KnownHostsFile,
+		})
+		if err != nil {
+			fatal("Cannot connect to the SSH server", err)
+		}
+		pgConfig.DialFunc = tunnel.DialContext
 	}
-	defer db.Close()
+
+	db := stdlib.OpenDB(*pgConfig)
+	metrics.RegisterDB(db)
+	model.SetQueryTimeout(cfg.Database.QueryTimeout)
+	media.SetLimits(uploadLimits(cfg.Uploads))
 
 	if err = db.Ping(); err != nil {
-		fmt.Printf("Gagal memverifikasi koneksi database : %v\n", err)
-		os.Exit(1)
+		fatal("Cannot reach the database", err)
 	}
 
-	if _, err = migrate(db); err != nil {
-		fmt.Printf("Gagal melakukan migrasi databse : %v\n", err)
-		os.Exit(1)
+	if len(args) > 0 && args[0] == "migrate" {
+		os.Exit(runMigrate(db, args[1:]))
+	}
+
+	// Bring the schema up to date before serving
+	if _, err = migrations.Up(context.Background(), db); err != nil {
+		fatal("Database migration failed", err)
 	}
 
-	r := gin.Default()
-	r.Use(CORSMiddleware())
+	store, err := storage.New(storage.Config{
+		Driver:    cfg.Storage.Driver,
+		LocalRoot: cfg.Storage.LocalRoot,
+		S3: storage.S3Config{
+			Endpoint:  cfg.Storage.S3.Endpoint,
+			AccessKey: cfg.Storage.S3.AccessKey,
+			SecretKey: cfg.Storage.S3.SecretKey,
+			Bucket:    cfg.Storage.S3.Bucket,
+			Region:    cfg.Storage.S3.Region,
+			UseSSL:    cfg.Storage.S3.UseSSL,
+			PublicURL: cfg.Storage.S3.PublicURL,
+		},
+	})
+	if err != nil {
+		fatal("Cannot set up storage", err)
+	}
 
-	r.POST("/api/v1/auth/register", handler.RegisterAuth(db))
-	r.POST("/api/v1/auth/login", handler.LoginAuth(db, os.Getenv("JWT_SECRET")))
-	r.GET("/api/v1/user", handler.GetUserWithJWT(db, os.Getenv("JWT_SECRET")))
-	r.DELETE("/api/v1/user", handler.DeleteUser(db, os.Getenv("JWT_SECRET")))
+	repo := repository.NewPostgres(db)
 
-	//skills
-	r.POST("/api/v1/skills", handler.AddSkills(db, os.Getenv("JWT_SECRET")))
-	r.GET("/api/v1/skills", handler.GetSkill(db))
-	r.GET("/api/v1/skills/:id", handler.GetSkillByID(db))
-	r.PUT("/api/v1/skills/:id", handler.UpdateSkill(db, os.Getenv("JWT_SECRET")))
-	r.DELETE("/api/v1/skills/:id", handler.DeleteSkill(db, os.Getenv("JWT_SECRET")))
+	if len(args) > 0 && args[0] == "gc" {
+		os.Exit(runGC(repo, store, cfg.GC, args[1:]))
+	}
 
-	//portfolio
-	r.POST("/api/v1/portfolio", handler.AddPortfolioWithSkills(db, os.Getenv("JWT_SECRET")))
-	r.GET("/api/v1/portfolio", handler.GetPortfolioAndSkillsPaginated(db))
-	r.GET("/api/v1/portfolio/:id", handler.GetPortfolioAndSkillsByID(db))
-	r.DELETE("/api/v1/portfolio/:id", handler.DeletePortfolioHandler(db, os.Getenv("JWT_SECRET")))
-	r.PUT("/api/v1/portfolio/:id", handler.UpdatePortfolioHandler(db, os.Getenv("JWT_SECRET")))
+	// Background workers get their own context so they keep running while
+	// requests drain
+	workers, stopWorkers := context.WithCancel(context.Background())
+	gcDone := startGC(workers, repo, store, cfg.GC)
+	pruneDone := startImageCachePruning(workers, cfg.Images)
 
-	//experience
-	r.POST("/api/v1/experience", handler.AddExperiance(db, os.Getenv("JWT_SECRET")))
-	r.GET("/api/v1/experience", handler.GetExperience(db))
-	r.GET("/api/v1/experience/:id", handler.GetExperienceByID(db))
-	r.PUT("/api/v1/experience/:id", handler.UpdateExperience(db, os.Getenv("JWT_SECRET")))
-	r.DELETE("/api/v1/experience/:id", handler.DeleteExperience(db, os.Getenv("JWT_SECRET")))
+	// Storage operations of requests become spans of their trace; the
+	// collector above runs outside of any request
+	store = storage.Traced(store)
 
-	r.PUT("/api/v1/portfolio-skill/:id", handler.AddSkillsToPortfolio(db, os.Getenv("JWT_SECRET")))
-	r.PUT("/api/v1/experience-skill/:id", handler.AddSkillsToExperience(db, os.Getenv("JWT_SECRET")))
+	urls, err := publicurl.New(cfg.URLs.PublicBaseURL, cfg.URLs.AssetBaseURL, cfg.URLs.TrustedProxies)
+	if err != nil {
+		fatal("Invalid public URL configuration", err)
+	}
 
-	r.POST("/api/v1/portfolio-skill/:id", handler.DeleteSkillWithRelationsHandler(db, os.Getenv("JWT_SECRET")))
-	r.POST("/api/v1/experience-skill/:id", handler.DeleteSkillExperienceWithRelationsHandler(db, os.Getenv("JWT_SECRET")))
+	// Probes for the orchestrator
+	checks := []handler.HealthCheck{
+		handler.DatabaseCheck(db),
+		handler.MigrationsCheck(db),
+		handler.StorageCheck(store),
+	}
+	if tunnel != nil {
+		checks = append(checks, handler.HealthCheck{Name: "ssh_tunnel", Check: tunnel.Ping})
+	}
+	var draining atomic.Bool
 
-	// Serve static files for images
-	r.Static("/uploads", "./uploads")
+	r := gin.New()
+	err = handler.Routes(r, repo, store, handler.RouteConfig{
+		JWTKey:        cfg.Auth.JWTSecret,
+		SigningKey:    cfg.Images.SigningKey,
+		ImageCacheDir: cfg.Images.CacheDir,
+		Tus:           handler.TusConfig{MaxSize: cfg.Uploads.TusMaxSize, Expiry: cfg.Uploads.TusExpiry},
+		URLs:          urls,
+		Checks:        checks,
+		Draining:      &draining,
+		Build:         buildInfo(),
+	})
+	if err != nil {
+		fatal("Invalid route configuration", err)
+	}
 
 	server := &http.Server{
-		Addr:    ":8080",
+		Addr:    cfg.Server.Addr,
 		Handler: r,
 	}
 
-	if err = server.ListenAndServe(); err != nil {
-		fmt.Printf("Gagal menjalankan server %v\n", err)
-		os.Exit(1)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+	slog.Info("Server listening", "addr", cfg.Server.Addr, "version", version)
+
+	exitCode := 0
+	select {
+	case err = <-serveErr:
+		slog.Error("Server failed", "error", err)
+		exitCode = 1
+	case <-ctx.Done():
+		slog.Info("Shutting down, draining in-flight requests", "timeout", cfg.Server.ShutdownTimeout)
 	}
-}
+	// A second signal kills the process right away
+	stop()
+
+	// Fail readiness and keep serving a little, so the load balancer stops
+	// sending new requests before the listener closes
+	draining.Store(true)
+	if exitCode == 0 && cfg.Server.ShutdownDelay > 0 {
+		time.Sleep(cfg.Server.ShutdownDelay)
+	}
+
+	// Stop accepting connections and let in-flight requests finish
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	if err = server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Requests still running after the shutdown timeout, closing their connections", "timeout", cfg.Server.ShutdownTimeout, "error", err)
+		server.Close()
+		exitCode = 1
+	}
+	cancel()
 
-func loadPostgresConfig() (string, error) {
-	if os.Getenv("DB_HOST") == "" {
-		return "", fmt.Errorf("environment variable DB_HOST must be set")
+	stopWorkers()
+	<-gcDone
+	<-pruneDone
+
+	// The pool dials through the tunnel, so it is closed first
+	if err = db.Close(); err != nil {
+		slog.Error("Error closing the database pool", "error", err)
+	}
+	if tunnel != nil {
+		if err = tunnel.Close(); err != nil {
+			slog.Error("Error closing the SSH tunnel", "error", err)
+		}
 	}
-	if os.Getenv("DB_PORT") == "" {
-		return "", fmt.Errorf("environment variable DB_PORT must be set")
+
+	// Send the spans still buffered
+	flushCtx, cancel := context.WithTimeout(context.Background(), tracingFlushTimeout)
+	if err = shutdownTracing(flushCtx); err != nil {
+		slog.Error("Error flushing traces", "error", err)
 	}
-	if os.Getenv("DB_USER") == "" {
-		return "", fmt.Errorf("environment variable DB_USER must be set")
+	cancel()
+	slog.Info("Server stopped")
+	os.Exit(exitCode)
+}
+
+// fatal logs err and exits, for failures while starting up
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// startImageCachePruning keeps the cache of transformed images within its
+// configured limits until ctx is cancelled. The returned channel is closed
+// once it has stopped.
+func startImageCachePruning(ctx context.Context, cfg config.Images) <-chan struct{} {
+	done := make(chan struct{})
+	if cfg.SigningKey == "" || (cfg.CacheMaxBytes == 0 && cfg.CacheMaxAge == 0) {
+		close(done)
+		return done
 	}
-	if os.Getenv("DB_DATABASE") == "" {
-		return "", fmt.Errorf("environment variable DB_DATABASE must be set")
+
+	prune := func() {
+		removed, freed, err := handler.PruneImageCache(cfg.CacheDir, cfg.CacheMaxBytes, cfg.CacheMaxAge)
+		if err != nil {
+			slog.Error("Error pruning the image cache", "dir", cfg.CacheDir, "error", err)
+		}
+		if removed > 0 {
+			slog.Info("Pruned the image cache", "dir", cfg.CacheDir, "files", removed, "bytes", freed)
+		}
 	}
-	if os.Getenv("DB_PASSWORD") == "" {
-		return "", fmt.Errorf("environment variable DB_PASSWORD must be set")
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(imageCachePruneInterval)
+		defer ticker.Stop()
+		for {
+			prune()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return done
+}
+
+// uploadLimits converts the configured limits for package media
+func uploadLimits(cfg config.Uploads) map[string]media.Limits {
+	limits := make(map[string]media.Limits)
+	for kind, l := range cfg.Limits() {
+		limits[kind] = media.Limits(l)
 	}
-	connStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
-		os.Getenv("DB_USER"),
-		os.Getenv("DB_PASSWORD"),
-		os.Getenv("DB_HOST"),
-		os.Getenv("DB_PORT"),
-		os.Getenv("DB_DATABASE"),
-	)
-	return connStr, nil
+	return limits
 }
@@ -0,0 +1,55 @@
+package media
+
+import (
+	"fmt"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// Kinds of file a portfolio gallery holds
+const (
+	KindImage    = "image"
+	KindDocument = "document"
+	KindVideo    = "video"
+)
+
+// Accepted attachment types, stored as sent, and the extension and kind
+// they are stored under
+var attachments = map[string]struct{ ext, kind string }{
+	"application/pdf": {".pdf", KindDocument},
+	"video/mp4":       {".mp4", KindVideo},
+	"video/webm":      {".webm", KindVideo},
+	"video/quicktime": {".mov", KindVideo},
+}
+
+// SniffLen is how many leading bytes of a file Classify looks at.
+const SniffLen = 3072
+
+// UnsupportedAttachmentError reports a gallery upload that is neither an
+// accepted image nor an accepted attachment.
+type UnsupportedAttachmentError struct {
+	MIME string
+}
+
+func (e *UnsupportedAttachmentError) Error() string {
+	return fmt.Sprintf("unsupported file type %s, expected an image, a PDF or an MP4, WebM or QuickTime video", e.MIME)
+}
+
+// Classify sniffs the leading bytes of a gallery upload and returns its
+// kind and MIME type. Images still have to pass Inspect.
+func Classify(head []byte) (kind, mime string, err error) {
+	mtype := mimetype.Detect(head).String()
+	if _, ok := extensions[mtype]; ok {
+		return KindImage, mtype, nil
+	}
+	if a, ok := attachments[mtype]; ok {
+		return a.kind, mtype, nil
+	}
+	return "", "", &UnsupportedAttachmentError{MIME: mtype}
+}
+
+// AttachmentExt returns the extension an attachment of type mime is
+// stored under.
+func AttachmentExt(mime string) string {
+	return attachments[mime].ext
+}
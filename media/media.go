@@ -0,0 +1,131 @@
+// Package media inspects uploaded images by their content rather than by
+// what the client claims they are.
+package media
+
+import (
+	"fmt"
+	"image"
+	"io"
+
+	// Decoders for the formats accepted below
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/gabriel-vasile/mimetype"
+	_ "golang.org/x/image/webp"
+)
+
+// Limits bounds the size of an upload. Zero fields are not enforced.
+type Limits struct {
+	MaxBytes  int64
+	MaxWidth  int
+	MaxHeight int
+}
+
+// Info describes an image that passed Inspect.
+type Info struct {
+	MIME   string
+	Ext    string
+	Width  int
+	Height int
+}
+
+// Accepted image types and the extension they are stored under
+var extensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// limits per kind of upload, from the configuration
+var limits map[string]Limits
+
+// SetLimits sets the limits of each kind of upload: portfolio, experience,
+// skills and users images, and attachments for the documents and videos of
+// a portfolio gallery. A kind without limits accepts any upload. It must
+// be called before serving requests.
+func SetLimits(l map[string]Limits) {
+	limits = l
+}
+
+// UnsupportedTypeError reports an upload that is not an accepted image.
+type UnsupportedTypeError struct {
+	MIME string
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return fmt.Sprintf("unsupported file type %s, expected a JPEG, PNG, GIF or WebP image", e.MIME)
+}
+
+// TooLargeError reports an upload above Limits.MaxBytes.
+type TooLargeError struct {
+	Size int64
+	Max  int64
+}
+
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("file is %d bytes, the limit is %d bytes", e.Size, e.Max)
+}
+
+// DimensionsError reports an image wider or taller than allowed.
+type DimensionsError struct {
+	Width, Height       int
+	MaxWidth, MaxHeight int
+}
+
+func (e *DimensionsError) Error() string {
+	return fmt.Sprintf("image is %dx%d pixels, the limit is %dx%d", e.Width, e.Height, e.MaxWidth, e.MaxHeight)
+}
+
+// LimitsFor returns the limits set for entity.
+func LimitsFor(entity string) Limits {
+	return limits[entity]
+}
+
+// Sniff returns the MIME type of the image whose leading bytes are head,
+// or an UnsupportedTypeError when they are not an accepted image.
+func Sniff(head []byte) (string, error) {
+	mtype := mimetype.Detect(head).String()
+	if _, ok := extensions[mtype]; !ok {
+		return "", &UnsupportedTypeError{MIME: mtype}
+	}
+	return mtype, nil
+}
+
+// Inspect sniffs the size bytes in r by their magic bytes and checks them
+// against limits. Only the image header is decoded, so oversized images
+// are rejected without being loaded into memory. r is rewound on return.
+func Inspect(r io.ReadSeeker, size int64, limits Limits) (*Info, error) {
+	if limits.MaxBytes > 0 && size > limits.MaxBytes {
+		return nil, &TooLargeError{Size: size, Max: limits.MaxBytes}
+	}
+
+	mtype, err := mimetype.DetectReader(r)
+	if err != nil {
+		return nil, err
+	}
+	ext, ok := extensions[mtype.String()]
+	if !ok {
+		return nil, &UnsupportedTypeError{MIME: mtype.String()}
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		// Right magic bytes but no readable header
+		return nil, &UnsupportedTypeError{MIME: mtype.String()}
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	if (limits.MaxWidth > 0 && cfg.Width > limits.MaxWidth) || (limits.MaxHeight > 0 && cfg.Height > limits.MaxHeight) {
+		return nil, &DimensionsError{Width: cfg.Width, Height: cfg.Height, MaxWidth: limits.MaxWidth, MaxHeight: limits.MaxHeight}
+	}
+
+	return &Info{MIME: mtype.String(), Ext: ext, Width: cfg.Width, Height: cfg.Height}, nil
+}
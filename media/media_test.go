@@ -0,0 +1,66 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func pngOf(w, h int) []byte {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h)))
+	return buf.Bytes()
+}
+
+func TestInspect(t *testing.T) {
+	small := pngOf(10, 20)
+
+	tests := []struct {
+		name   string
+		data   []byte
+		limits Limits
+		want   error
+	}{
+		{"within limits", small, Limits{MaxBytes: 1 << 10, MaxWidth: 10, MaxHeight: 20}, nil},
+		{"no limits", small, Limits{}, nil},
+		{"too many bytes", small, Limits{MaxBytes: int64(len(small)) - 1}, &TooLargeError{}},
+		{"too wide", small, Limits{MaxWidth: 9}, &DimensionsError{}},
+		{"too tall", small, Limits{MaxHeight: 19}, &DimensionsError{}},
+		{"not an image", []byte("just some text"), Limits{}, &UnsupportedTypeError{}},
+		{"pdf", []byte("%PDF-1.4\n%%EOF\n"), Limits{}, &UnsupportedTypeError{}},
+		{"png magic only", small[:16], Limits{}, &UnsupportedTypeError{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bytes.NewReader(tt.data)
+			info, err := Inspect(r, int64(len(tt.data)), tt.limits)
+
+			switch want := tt.want.(type) {
+			case nil:
+				if err != nil {
+					t.Fatalf("Inspect() error = %v", err)
+				}
+				if info.MIME != "image/png" || info.Ext != ".png" || info.Width != 10 || info.Height != 20 {
+					t.Fatalf("Inspect() = %+v, want a 10x20 PNG", info)
+				}
+				if pos, _ := r.Seek(0, 1); pos != 0 {
+					t.Fatalf("reader left at %d, want it rewound", pos)
+				}
+			case *TooLargeError:
+				if !errors.As(err, &want) {
+					t.Fatalf("Inspect() error = %v, want %T", err, want)
+				}
+			case *DimensionsError:
+				if !errors.As(err, &want) || want.Width != 10 || want.Height != 20 {
+					t.Fatalf("Inspect() error = %v, want %T for 10x20", err, want)
+				}
+			case *UnsupportedTypeError:
+				if !errors.As(err, &want) {
+					t.Fatalf("Inspect() error = %v, want %T", err, want)
+				}
+			}
+		})
+	}
+}
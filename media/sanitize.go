@@ -0,0 +1,382 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+)
+
+// ErrMalformed is returned by Sanitize for files whose container cannot be
+// walked safely.
+var ErrMalformed = errors.New("malformed image container")
+
+// Sanitize removes EXIF, XMP, IPTC, comments and text chunks from a JPEG,
+// PNG or WebP image without re-encoding it, as well as images appended
+// after the end of a JPEG. ICC colour profiles are kept so wide gamut
+// photos keep their colours. Only an image whose EXIF orientation is not
+// the default is decoded, turned upright and encoded again with its
+// profile, since the tag that told viewers to rotate it is gone. info is
+// updated when that swaps width and height. Other formats are returned
+// unchanged.
+func Sanitize(data []byte, info *Info) ([]byte, error) {
+	var (
+		clean       []byte
+		orientation int
+		profile     [][]byte
+		err         error
+	)
+	switch info.MIME {
+	case "image/jpeg":
+		clean, orientation, profile, err = sanitizeJPEG(data)
+	case "image/png":
+		clean, orientation, profile, err = sanitizePNG(data)
+	case "image/webp":
+		clean, orientation, profile, err = sanitizeWebP(data)
+	default:
+		return data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if orientation <= 1 || orientation > 8 {
+		return clean, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(clean))
+	if err != nil {
+		return nil, fmt.Errorf("decoding image to apply orientation: %w", err)
+	}
+	upright := orient(img, orientation)
+	info.Width, info.Height = upright.Bounds().Dx(), upright.Bounds().Dy()
+	encoded, err := encode(upright, info.Ext)
+	if err != nil || len(profile) == 0 {
+		return encoded, err
+	}
+	return withProfile(encoded, info.Ext, upright.Bounds(), profile), nil
+}
+
+// JPEG markers kept in the header: JFIF (APP0) and Adobe (APP14), which
+// decoders need for colour conversion, plus everything that is not an
+// application segment or a comment. ICC profiles (APP2) are checked by
+// isICCSegment.
+func keepJPEGSegment(marker byte) bool {
+	switch {
+	case marker == 0xE0 || marker == 0xEE:
+		return true
+	case marker >= 0xE1 && marker <= 0xEF, marker == 0xFE:
+		return false
+	default:
+		return true
+	}
+}
+
+// isICCSegment reports whether segment is part of an ICC profile, which
+// may be split over several APP2 segments
+func isICCSegment(segment []byte) bool {
+	return segment[1] == 0xE2 && bytes.HasPrefix(segment[4:], []byte("ICC_PROFILE\x00"))
+}
+
+func sanitizeJPEG(data []byte) ([]byte, int, [][]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, 0, nil, ErrMalformed
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+	orientation := 0
+	var profile [][]byte
+
+	pos := 2
+	for pos < len(data) {
+		if data[pos] != 0xFF {
+			return nil, 0, nil, ErrMalformed
+		}
+		// Markers may be padded with any number of 0xFF fill bytes
+		for pos+1 < len(data) && data[pos+1] == 0xFF {
+			pos++
+		}
+		if pos+1 >= len(data) {
+			return nil, 0, nil, ErrMalformed
+		}
+		marker := data[pos+1]
+
+		switch {
+		case marker == 0xD9:
+			// Anything after the end of the primary image is dropped, such
+			// as the appended images of MPF files, which carry EXIF too
+			return append(out, 0xFF, 0xD9), orientation, profile, nil
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			out = append(out, 0xFF, marker)
+			pos += 2
+			continue
+		}
+
+		if pos+4 > len(data) {
+			return nil, 0, nil, ErrMalformed
+		}
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+		if end > len(data) || end < pos+4 {
+			return nil, 0, nil, ErrMalformed
+		}
+		segment := data[pos:end]
+
+		// Entropy coded data follows the start of scan and is copied
+		// verbatim up to the next marker. Inside it 0xFF is only followed
+		// by a stuffed zero or a restart marker.
+		if marker == 0xDA {
+			out = append(out, segment...)
+			scan := end
+			for {
+				i := bytes.IndexByte(data[scan:], 0xFF)
+				if i < 0 || scan+i+1 >= len(data) {
+					// Files cut short after the scan are still decodable
+					return append(out, data[end:]...), orientation, profile, nil
+				}
+				scan += i
+				if next := data[scan+1]; next != 0x00 && (next < 0xD0 || next > 0xD7) {
+					break
+				}
+				scan += 2
+			}
+			out = append(out, data[end:scan]...)
+			pos = scan
+			continue
+		}
+
+		if marker == 0xE1 && bytes.HasPrefix(segment[4:], []byte("Exif\x00\x00")) {
+			orientation = exifOrientation(segment[10:])
+		}
+		switch {
+		case isICCSegment(segment):
+			out = append(out, segment...)
+			profile = append(profile, segment)
+		case keepJPEGSegment(marker):
+			out = append(out, segment...)
+		}
+		pos = end
+	}
+	return nil, 0, nil, ErrMalformed
+}
+
+// PNG chunks needed to display the image faithfully, including its ICC
+// profile; text, time and EXIF chunks are dropped
+var keepPNGChunk = map[string]bool{
+	"IHDR": true, "PLTE": true, "IDAT": true, "IEND": true,
+	"tRNS": true, "gAMA": true, "cHRM": true, "sRGB": true, "sBIT": true,
+	"bKGD": true, "pHYs": true, "iCCP": true,
+	// Animated PNG
+	"acTL": true, "fcTL": true, "fdAT": true,
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+func sanitizePNG(data []byte) ([]byte, int, [][]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, 0, nil, ErrMalformed
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+	orientation := 0
+	var profile [][]byte
+
+	pos := len(pngSignature)
+	for pos+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, 0, nil, ErrMalformed
+		}
+		kind := string(data[pos+4 : pos+8])
+
+		switch kind {
+		case "eXIf":
+			orientation = exifOrientation(data[pos+8 : pos+8+length])
+		case "iCCP":
+			profile = append(profile, data[pos:end])
+		}
+		if keepPNGChunk[kind] {
+			out = append(out, data[pos:end]...)
+		}
+		pos = end
+
+		if kind == "IEND" {
+			return out, orientation, profile, nil
+		}
+	}
+	return nil, 0, nil, ErrMalformed
+}
+
+// VP8X feature flags announcing optional chunks; the EXIF and XMP ones
+// are cleared along with their chunks
+const (
+	webpFlagICC   = 0x20
+	webpFlagAlpha = 0x10
+	webpFlagEXIF  = 0x08
+	webpFlagXMP   = 0x04
+)
+
+func sanitizeWebP(data []byte) ([]byte, int, [][]byte, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, 0, nil, ErrMalformed
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:12]...)
+	orientation := 0
+	var profile [][]byte
+
+	pos := 12
+	for pos+8 <= len(data) {
+		kind := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		end := pos + 8 + size + size&1
+		if size < 0 || pos+8+size > len(data) {
+			return nil, 0, nil, ErrMalformed
+		}
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := data[pos:end]
+
+		switch kind {
+		case "EXIF":
+			payload := bytes.TrimPrefix(chunk[8:8+size], []byte("Exif\x00\x00"))
+			orientation = exifOrientation(payload)
+		case "XMP ":
+		case "ICCP":
+			profile = append(profile, chunk)
+			out = append(out, chunk...)
+		case "VP8X":
+			chunk = append([]byte(nil), chunk...)
+			if len(chunk) > 8 {
+				chunk[8] &^= webpFlagEXIF | webpFlagXMP
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, chunk...)
+		}
+		pos = end
+	}
+
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, orientation, profile, nil
+}
+
+// withProfile puts the ICC profile segments or chunks taken from the
+// original upload back into an image encoded by encode
+func withProfile(data []byte, ext string, bounds image.Rectangle, profile [][]byte) []byte {
+	var out []byte
+	switch ext {
+	case ".jpg":
+		// Right after the start of image marker
+		out = append(out, data[:2]...)
+		out = append(out, bytes.Join(profile, nil)...)
+		out = append(out, data[2:]...)
+	case ".png":
+		// Right after IHDR, before any image data
+		ihdrEnd := len(pngSignature) + 12 + 13
+		out = append(out, data[:ihdrEnd]...)
+		out = append(out, profile[0]...)
+		out = append(out, data[ihdrEnd:]...)
+	case ".webp":
+		// A profile needs the extended format: VP8X, ICCP, then the
+		// bitstream. The VP8L header tells whether alpha is used.
+		if len(data) < 16 || (string(data[12:16]) != "VP8L" && string(data[12:16]) != "VP8 ") {
+			return data
+		}
+		vp8x := make([]byte, 18)
+		copy(vp8x, "VP8X")
+		binary.LittleEndian.PutUint32(vp8x[4:], 10)
+		vp8x[8] = webpFlagICC
+		if len(data) >= 25 && string(data[12:16]) == "VP8L" && data[24]&0x10 != 0 {
+			vp8x[8] |= webpFlagAlpha
+		}
+		w, h := uint32(bounds.Dx()-1), uint32(bounds.Dy()-1)
+		vp8x[12], vp8x[13], vp8x[14] = byte(w), byte(w>>8), byte(w>>16)
+		vp8x[15], vp8x[16], vp8x[17] = byte(h), byte(h>>8), byte(h>>16)
+
+		out = append(out, data[:12]...)
+		out = append(out, vp8x...)
+		out = append(out, profile[0]...)
+		out = append(out, data[12:]...)
+		binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	default:
+		return data
+	}
+	return out
+}
+
+// exifOrientation reads the orientation tag (0x0112) from the first IFD
+// of a TIFF structured EXIF block, or returns 0 when there is none
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 0
+}
+
+// orient turns img upright according to an EXIF orientation value
+func orient(img image.Image, orientation int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			default:
+				dx, dy = x, y
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
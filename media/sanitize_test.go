@@ -0,0 +1,346 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/HugoSmits86/nativewebp"
+)
+
+// tiff returns an EXIF block in the given byte order whose first IFD has
+// an orientation tag, preceded by another tag so the walk is exercised
+func tiff(order binary.ByteOrder, orientation uint16) []byte {
+	b := make([]byte, 8+2+2*12+4)
+	if order == binary.LittleEndian {
+		copy(b, "II")
+	} else {
+		copy(b, "MM")
+	}
+	order.PutUint16(b[2:], 42)
+	order.PutUint32(b[4:], 8)
+	order.PutUint16(b[8:], 2)
+	order.PutUint16(b[10:], 0x010F) // Make
+	order.PutUint16(b[22:], 0x0112)
+	order.PutUint16(b[24:], 3)
+	order.PutUint32(b[26:], 1)
+	order.PutUint16(b[30:], orientation)
+	return b
+}
+
+// testImage is 16x8 with a red top left quarter, so rotations can be told
+// apart even after lossy compression
+func testImage() image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			c := color.NRGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF}
+			if x < 8 && y < 4 {
+				c = color.NRGBA{R: 0xFF, A: 0xFF}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func isRed(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r > 0xC000 && g < 0x4000 && b < 0x4000
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	seg := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+// jpegWith inserts segments right after the start of image marker
+func jpegWith(t *testing.T, segments ...[]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(), &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	out := append([]byte(nil), buf.Bytes()[:2]...)
+	for _, s := range segments {
+		out = append(out, s...)
+	}
+	return append(out, buf.Bytes()[2:]...)
+}
+
+func pngChunk(kind string, payload []byte) []byte {
+	c := make([]byte, 8, 12+len(payload))
+	binary.BigEndian.PutUint32(c, uint32(len(payload)))
+	copy(c[4:], kind)
+	c = append(c, payload...)
+	return binary.BigEndian.AppendUint32(c, crc32.ChecksumIEEE(c[4:]))
+}
+
+// pngWith inserts chunks right after IHDR
+func pngWith(t *testing.T, chunks ...[]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	ihdrEnd := len(pngSignature) + 12 + 13
+	out := append([]byte(nil), data[:ihdrEnd]...)
+	for _, c := range chunks {
+		out = append(out, c...)
+	}
+	return append(out, data[ihdrEnd:]...)
+}
+
+func riffChunk(kind string, payload []byte) []byte {
+	c := make([]byte, 8, 9+len(payload))
+	copy(c, kind)
+	binary.LittleEndian.PutUint32(c[4:], uint32(len(payload)))
+	c = append(c, payload...)
+	if len(payload)%2 == 1 {
+		c = append(c, 0)
+	}
+	return c
+}
+
+func riff(chunks ...[]byte) []byte {
+	out := []byte("RIFF\x00\x00\x00\x00WEBP")
+	for _, c := range chunks {
+		out = append(out, c...)
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out
+}
+
+// webpWith prepends chunks to the bitstream of an encoded WebP
+func webpWith(t *testing.T, chunks ...[]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := nativewebp.Encode(&buf, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	return riff(append(chunks, buf.Bytes()[12:])...)
+}
+
+func TestSanitize(t *testing.T) {
+	exif := func(orientation uint16) []byte {
+		return append([]byte("Exif\x00\x00"), tiff(binary.BigEndian, orientation)...)
+	}
+
+	tests := []struct {
+		name    string
+		mime    string
+		data    []byte
+		dropped [][]byte
+		kept    [][]byte
+		// rotated images are 8x16; redX, redY lies inside the red quarter
+		rotated    bool
+		redX, redY int
+	}{
+		{
+			name:    "jpeg metadata",
+			mime:    "image/jpeg",
+			data:    jpegWith(t, jpegSegment(0xE1, exif(1)), jpegSegment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00<x/>")), jpegSegment(0xE2, []byte("ICC_PROFILE\x00display p3")), jpegSegment(0xFE, []byte("a comment"))),
+			dropped: [][]byte{[]byte("Exif"), []byte("ns.adobe.com"), []byte("a comment")},
+			kept:    [][]byte{[]byte("ICC_PROFILE\x00display p3")},
+		},
+		{
+			name:    "jpeg with an appended image",
+			mime:    "image/jpeg",
+			data:    append(jpegWith(t), jpegWith(t, jpegSegment(0xE1, exif(1)))...),
+			dropped: [][]byte{[]byte("Exif")},
+		},
+		{
+			name:    "jpeg rotated 90 clockwise",
+			mime:    "image/jpeg",
+			data:    jpegWith(t, jpegSegment(0xE1, exif(6)), jpegSegment(0xE2, []byte("ICC_PROFILE\x00display p3"))),
+			dropped: [][]byte{[]byte("Exif")},
+			kept:    [][]byte{[]byte("ICC_PROFILE\x00display p3")},
+			rotated: true, redX: 6, redY: 2,
+		},
+		{
+			name:    "png metadata",
+			mime:    "image/png",
+			data:    pngWith(t, pngChunk("tEXt", []byte("Comment\x00secret")), pngChunk("iCCP", []byte("icc\x00\x00")), pngChunk("eXIf", tiff(binary.LittleEndian, 1))),
+			dropped: [][]byte{[]byte("secret"), []byte("eXIf")},
+			kept:    [][]byte{[]byte("iCCPicc")},
+		},
+		{
+			name:    "png rotated 270 clockwise",
+			mime:    "image/png",
+			data:    pngWith(t, pngChunk("eXIf", tiff(binary.LittleEndian, 8)), pngChunk("iCCP", []byte("icc\x00\x00"))),
+			dropped: [][]byte{[]byte("eXIf")},
+			kept:    [][]byte{[]byte("iCCPicc")},
+			rotated: true, redX: 1, redY: 13,
+		},
+		{
+			name:    "webp metadata",
+			mime:    "image/webp",
+			data:    webpWith(t, riffChunk("XMP ", []byte("<x:xmpmeta/>")), riffChunk("ICCP", []byte("icc"))),
+			dropped: [][]byte{[]byte("xmpmeta")},
+			kept:    [][]byte{[]byte("ICCP\x03\x00\x00\x00icc")},
+		},
+		{
+			name:    "webp transposed",
+			mime:    "image/webp",
+			data:    webpWith(t, riffChunk("EXIF", exif(5)), riffChunk("ICCP", []byte("icc"))),
+			dropped: [][]byte{[]byte("EXIF")},
+			kept:    [][]byte{[]byte("VP8X\x0a\x00\x00\x00"), []byte("ICCP\x03\x00\x00\x00icc")},
+			rotated: true, redX: 1, redY: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := &Info{MIME: tt.mime, Ext: extensions[tt.mime], Width: 16, Height: 8}
+			clean, err := Sanitize(tt.data, info)
+			if err != nil {
+				t.Fatalf("Sanitize() error = %v", err)
+			}
+			for _, d := range tt.dropped {
+				if bytes.Contains(clean, d) {
+					t.Errorf("output still contains %q", d)
+				}
+			}
+			for _, k := range tt.kept {
+				if !bytes.Contains(clean, k) {
+					t.Errorf("output lost %q", k)
+				}
+			}
+
+			img, _, err := image.Decode(bytes.NewReader(clean))
+			if err != nil {
+				t.Fatalf("decoding output: %v", err)
+			}
+			w, h := 16, 8
+			if tt.rotated {
+				w, h = 8, 16
+			}
+			if b := img.Bounds(); b.Dx() != w || b.Dy() != h || info.Width != w || info.Height != h {
+				t.Fatalf("output is %dx%d and info %dx%d, want %dx%d", b.Dx(), b.Dy(), info.Width, info.Height, w, h)
+			}
+			if !tt.rotated {
+				tt.redX, tt.redY = 2, 1
+			}
+			if !isRed(img.At(tt.redX, tt.redY)) {
+				t.Errorf("pixel %d,%d is %v, want the red corner", tt.redX, tt.redY, img.At(tt.redX, tt.redY))
+			}
+		})
+	}
+}
+
+func TestSanitizeJPEGAppendedImage(t *testing.T) {
+	primary := jpegWith(t)
+	gps := append([]byte("Exif\x00\x00"), tiff(binary.BigEndian, 1)...)
+	data := append(append([]byte(nil), primary...), jpegWith(t, jpegSegment(0xE1, gps))...)
+
+	clean, err := Sanitize(data, &Info{MIME: "image/jpeg", Ext: ".jpg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(clean, primary) {
+		t.Fatalf("Sanitize() kept %d bytes, want the %d bytes of the primary image", len(clean), len(primary))
+	}
+}
+
+func TestSanitizeWebPHeader(t *testing.T) {
+	vp8x := make([]byte, 10)
+	vp8x[0] = webpFlagICC | webpFlagEXIF | webpFlagXMP | 0x10 // alpha stays
+	data := riff(riffChunk("VP8X", vp8x), riffChunk("ICCP", []byte("icc")), riffChunk("VP8L", []byte("odd")), riffChunk("EXIF", tiff(binary.LittleEndian, 1)))
+
+	clean, err := Sanitize(data, &Info{MIME: "image/webp", Ext: ".webp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := riff(riffChunk("VP8X", append([]byte{webpFlagICC | 0x10}, vp8x[1:]...)), riffChunk("ICCP", []byte("icc")), riffChunk("VP8L", []byte("odd")))
+	if !bytes.Equal(clean, want) {
+		t.Fatalf("Sanitize() =\n%q\nwant\n%q", clean, want)
+	}
+}
+
+func TestSanitizeMalformed(t *testing.T) {
+	validJPEG := jpegWith(t)
+	validPNG := pngWith(t)
+
+	tests := []struct {
+		name string
+		mime string
+		data []byte
+	}{
+		{"jpeg without start of image", "image/jpeg", validJPEG[2:]},
+		{"jpeg too short", "image/jpeg", []byte{0xFF, 0xD8, 0xFF}},
+		{"jpeg cut inside a segment", "image/jpeg", jpegWith(t, jpegSegment(0xE1, make([]byte, 100)))[:50]},
+		{"jpeg segment length below two", "image/jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x01, 0xFF, 0xD9}},
+		{"jpeg garbage between segments", "image/jpeg", []byte{0xFF, 0xD8, 0x00, 0xFF, 0xD9}},
+		{"jpeg fill bytes to the end", "image/jpeg", []byte{0xFF, 0xD8, 0xFF, 0xFF, 0xFF}},
+		{"jpeg without scan or end", "image/jpeg", append([]byte{0xFF, 0xD8}, jpegSegment(0xE0, []byte("JFIF\x00"))...)},
+		{"png without signature", "image/png", validPNG[1:]},
+		{"png cut inside a chunk", "image/png", validPNG[:len(pngSignature)+20]},
+		{"png without end", "image/png", validPNG[:len(validPNG)-12]},
+		{"png chunk longer than the file", "image/png", append(append([]byte(nil), pngSignature...), 0x7F, 0xFF, 0xFF, 0xFF, 'I', 'H', 'D', 'R', 0, 0, 0, 0)},
+		{"webp too short", "image/webp", []byte("RIFF\x00\x00\x00\x00WEB")},
+		{"webp not webp", "image/webp", []byte("RIFF\x04\x00\x00\x00AVI ")},
+		{"webp chunk longer than the file", "image/webp", append(riff(), 'E', 'X', 'I', 'F', 0xFF, 0xFF, 0xFF, 0x7F, 'M', 'M')},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Sanitize(tt.data, &Info{MIME: tt.mime, Ext: extensions[tt.mime]}); !errors.Is(err, ErrMalformed) {
+				t.Fatalf("Sanitize() error = %v, want ErrMalformed", err)
+			}
+		})
+	}
+}
+
+func TestSanitizeOtherFormats(t *testing.T) {
+	data := []byte("GIF89a anything")
+	clean, err := Sanitize(data, &Info{MIME: "image/gif", Ext: ".gif"})
+	if err != nil || !bytes.Equal(clean, data) {
+		t.Fatalf("Sanitize() = %q, %v, want the input unchanged", clean, err)
+	}
+}
+
+func TestExifOrientation(t *testing.T) {
+	valid := tiff(binary.BigEndian, 6)
+	pointAt := func(offset uint32) []byte {
+		b := append([]byte(nil), valid...)
+		binary.BigEndian.PutUint32(b[4:], offset)
+		return b
+	}
+	manyEntries := append([]byte(nil), valid...)
+	binary.BigEndian.PutUint16(manyEntries[8:], 0xFFFF)
+
+	tests := []struct {
+		name string
+		tiff []byte
+		want int
+	}{
+		{"big endian", valid, 6},
+		{"little endian", tiff(binary.LittleEndian, 3), 3},
+		{"empty", nil, 0},
+		{"shorter than a header", valid[:7], 0},
+		{"unknown byte order", append([]byte("XX"), valid[2:]...), 0},
+		{"ifd inside the header", pointAt(4), 0},
+		{"ifd past the end", pointAt(uint32(len(valid))), 0},
+		{"ifd offset overflowing", pointAt(0xFFFFFFFF), 0},
+		{"entry count past the end", manyEntries[:20], 0},
+		{"entries past the end", manyEntries, 6},
+		{"no orientation tag", func() []byte {
+			b := append([]byte(nil), valid...)
+			binary.BigEndian.PutUint16(b[22:], 0x0110)
+			return b
+		}(), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exifOrientation(tt.tiff); got != tt.want {
+				t.Fatalf("exifOrientation() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
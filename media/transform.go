@@ -0,0 +1,170 @@
+package media
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"net/url"
+	"strconv"
+
+	"golang.org/x/image/draw"
+)
+
+// Bounds for on-the-fly transformations
+const maxTransformSize = 4096
+
+// Fit modes for a transformation with both a width and a height
+const (
+	FitContain = "contain"
+	FitCover   = "cover"
+)
+
+// Transform describes how to render an image on the fly. A zero Width or
+// Height is derived from the other one, keeping the aspect ratio.
+type Transform struct {
+	Width  int
+	Height int
+	Fit    string
+	// Format is the output extension: ".jpg", ".png" or ".webp". Empty keeps
+	// the variant fallback format of the source.
+	Format string
+}
+
+// ParseTransform reads w, h, fit and fmt from query.
+func ParseTransform(query url.Values) (Transform, error) {
+	var t Transform
+	var err error
+
+	if t.Width, err = parseSize(query.Get("w")); err != nil {
+		return t, fmt.Errorf("w %w", err)
+	}
+	if t.Height, err = parseSize(query.Get("h")); err != nil {
+		return t, fmt.Errorf("h %w", err)
+	}
+	if t.Width == 0 && t.Height == 0 {
+		return t, fmt.Errorf("w or h is required")
+	}
+
+	switch fit := query.Get("fit"); fit {
+	case "", FitContain:
+		t.Fit = FitContain
+	case FitCover:
+		t.Fit = FitCover
+	default:
+		return t, fmt.Errorf("fit must be %s or %s", FitContain, FitCover)
+	}
+
+	switch f := query.Get("fmt"); f {
+	case "":
+	case "jpg", "jpeg":
+		t.Format = ".jpg"
+	case "png", "webp":
+		t.Format = "." + f
+	default:
+		return t, fmt.Errorf("fmt must be jpg, png or webp")
+	}
+	return t, nil
+}
+
+func parseSize(v string) (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > maxTransformSize {
+		return 0, fmt.Errorf("must be a number between 1 and %d", maxTransformSize)
+	}
+	return n, nil
+}
+
+// Canonical is the stable text form of t, used for signing and caching.
+func (t Transform) Canonical() string {
+	return fmt.Sprintf("w=%d&h=%d&fit=%s&fmt=%s", t.Width, t.Height, t.Fit, t.Format)
+}
+
+// Query is the query string that ParseTransform reads back as t.
+func (t Transform) Query() url.Values {
+	q := url.Values{}
+	if t.Width > 0 {
+		q.Set("w", strconv.Itoa(t.Width))
+	}
+	if t.Height > 0 {
+		q.Set("h", strconv.Itoa(t.Height))
+	}
+	if t.Fit != "" && t.Fit != FitContain {
+		q.Set("fit", t.Fit)
+	}
+	if t.Format != "" {
+		q.Set("fmt", t.Format[1:])
+	}
+	return q
+}
+
+// Sign returns the signature authorising t on the image stored under key.
+func Sign(secret []byte, key string, t Transform) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(key + "?" + t.Canonical()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether sig was produced by Sign for key and t.
+func Verify(secret []byte, key string, t Transform, sig string) bool {
+	return hmac.Equal([]byte(Sign(secret, key, t)), []byte(sig))
+}
+
+// Apply renders img according to t. Images are never scaled up.
+func (t Transform) Apply(img image.Image) image.Image {
+	b := img.Bounds()
+	srcW, srcH := b.Dx(), b.Dy()
+
+	w, h := t.Width, t.Height
+	switch {
+	case w == 0:
+		w = srcW * h / srcH
+	case h == 0:
+		h = srcH * w / srcW
+	}
+
+	src := b
+	if t.Fit == FitCover && t.Width > 0 && t.Height > 0 {
+		// Crop the centre of the source to the target aspect ratio
+		if srcW*h > srcH*w {
+			cropW := srcH * w / h
+			src.Min.X += (srcW - cropW) / 2
+			src.Max.X = src.Min.X + cropW
+		} else {
+			cropH := srcW * h / w
+			src.Min.Y += (srcH - cropH) / 2
+			src.Max.Y = src.Min.Y + cropH
+		}
+	} else if t.Width > 0 && t.Height > 0 {
+		// Contain: shrink the box to the source aspect ratio
+		if srcW*h > srcH*w {
+			h = srcH * w / srcW
+		} else {
+			w = srcW * h / srcH
+		}
+	}
+
+	// Never upscale, the client can do that for free
+	if w > src.Dx() || h > src.Dy() {
+		w, h = src.Dx(), src.Dy()
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Src, nil)
+	return dst
+}
+
+// Encode writes img in the format of ext: ".jpg", ".png" or ".webp".
+func Encode(img image.Image, ext string) ([]byte, error) {
+	return encode(img, ext)
+}
@@ -0,0 +1,134 @@
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime"
+	"path"
+	"strings"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+)
+
+// Variant is a preset width generated for every uploaded image.
+type Variant struct {
+	Name  string
+	Width int
+}
+
+// Variants lists the presets from smallest to largest.
+var Variants = []Variant{
+	{Name: "thumbnail", Width: 320},
+	{Name: "medium", Width: 800},
+	{Name: "large", Width: 1600},
+}
+
+// Rendition is an encoded variant ready to be stored.
+type Rendition struct {
+	Key         string
+	ContentType string
+	Data        []byte
+}
+
+// VariantsFor returns the presets generated for an image width pixels
+// wide. Presets at or above the original width are skipped since
+// upscaling only adds bytes.
+func VariantsFor(width int) []Variant {
+	var out []Variant
+	for _, v := range Variants {
+		if v.Width < width {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// VariantKey names a variant of key, e.g. "portfolio/a.png" becomes
+// "portfolio/a_medium.webp" for the medium WebP variant.
+func VariantKey(key, name, ext string) string {
+	return strings.TrimSuffix(key, path.Ext(key)) + "_" + name + ext
+}
+
+// VariantKeys lists every variant key that may exist for key.
+func VariantKeys(key string) []string {
+	fallback := FallbackExt(key)
+	keys := make([]string, 0, 2*len(Variants))
+	for _, v := range Variants {
+		keys = append(keys, VariantKey(key, v.Name, fallback), VariantKey(key, v.Name, ".webp"))
+	}
+	return keys
+}
+
+// FallbackExt is the format variants of key are encoded in for clients
+// without WebP support: JPEG stays JPEG, everything else becomes PNG.
+func FallbackExt(key string) string {
+	if ext := path.Ext(key); ext == ".jpg" || ext == ".jpeg" {
+		return ".jpg"
+	}
+	return ".png"
+}
+
+// Generate decodes the image stored under key and encodes every preset
+// from VariantsFor, once in its fallback format and once as WebP.
+func Generate(r io.Reader, key string) ([]Rendition, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", key, err)
+	}
+
+	// Scale down from the previous, larger variant rather than the original
+	// each time: the result is indistinguishable and much cheaper
+	fallback := FallbackExt(key)
+	variants := VariantsFor(img.Bounds().Dx())
+	var out []Rendition
+	for i := len(variants) - 1; i >= 0; i-- {
+		v := variants[i]
+		resized := resize(img, v.Width)
+		img = resized
+
+		for _, ext := range []string{fallback, ".webp"} {
+			data, err := encode(resized, ext)
+			if err != nil {
+				return nil, fmt.Errorf("encoding %s variant of %s: %w", v.Name, key, err)
+			}
+			out = append(out, Rendition{
+				Key:         VariantKey(key, v.Name, ext),
+				ContentType: mime.TypeByExtension(ext),
+				Data:        data,
+			})
+		}
+	}
+	return out, nil
+}
+
+// resize scales img to width pixels wide, keeping its aspect ratio
+func resize(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+func encode(img image.Image, ext string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch ext {
+	case ".jpg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	case ".webp":
+		err = nativewebp.Encode(&buf, img, nil)
+	default:
+		err = png.Encode(&buf, img)
+	}
+	return buf.Bytes(), err
+}
@@ -0,0 +1,153 @@
+// Package metrics exposes Prometheus metrics of the API: HTTP traffic per
+// route, the database pool, timing of model queries, uploads and
+// authentication outcomes.
+package metrics
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "portfolio"
+
+// unmatchedRoute labels requests no route matched, so random paths do not
+// each get a series of their own
+const unmatchedRoute = "unmatched"
+
+// Outcomes of an authentication attempt
+const (
+	AuthSuccess = "success"
+	AuthFailure = "failure"
+)
+
+// registry holds the metrics of this package only, instead of the global
+// default registry libraries may register into
+var registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route template and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to answer HTTP requests by method and route template.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	httpInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "HTTP requests being answered.",
+	})
+
+	queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Time taken by model functions querying the database.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"function"})
+
+	uploadBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upload_bytes_total",
+		Help:      "Bytes of accepted uploads by kind of file.",
+	}, []string{"kind"})
+
+	uploadSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upload_size_bytes",
+		Help:      "Size of accepted uploads by kind of file.",
+		// 16KB up to 1GB
+		Buckets: prometheus.ExponentialBuckets(16<<10, 4, 9),
+	}, []string{"kind"})
+
+	tusBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tus_received_bytes_total",
+		Help:      "Bytes received for resumable uploads.",
+	})
+
+	authAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_attempts_total",
+		Help:      "Authentication attempts by method (login or token) and result.",
+	}, []string{"method", "result"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration, httpInFlight,
+		queryDuration,
+		uploadBytes, uploadSize, tusBytes,
+		authAttempts,
+	)
+}
+
+// RegisterDB exports the connection pool statistics of db.
+func RegisterDB(db *sql.DB) {
+	registry.MustRegister(collectors.NewDBStatsCollector(db, "portfolio"))
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() gin.HandlerFunc {
+	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	return gin.WrapH(h)
+}
+
+// Middleware counts and times every request by its route template.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		httpInFlight.Inc()
+		defer httpInFlight.Dec()
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		method := c.Request.Method
+		httpRequests.WithLabelValues(method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		httpDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// ObserveQuery records the time a model function took since start.
+func ObserveQuery(function string, start time.Time) {
+	queryDuration.WithLabelValues(function).Observe(time.Since(start).Seconds())
+}
+
+// ObserveUpload records an accepted upload of size bytes. kind is one of
+// the media kinds.
+func ObserveUpload(kind string, size int64) {
+	uploadBytes.WithLabelValues(kind).Add(float64(size))
+	uploadSize.WithLabelValues(kind).Observe(float64(size))
+}
+
+// AddTusBytes records n bytes received for a resumable upload.
+func AddTusBytes(n int64) {
+	tusBytes.Add(float64(n))
+}
+
+// ObserveAuth records the result of an authentication attempt by method,
+// either "login" or "token".
+func ObserveAuth(method string, err error) {
+	result := AuthSuccess
+	if err != nil {
+		result = AuthFailure
+	}
+	authAttempts.WithLabelValues(method, result).Inc()
+}
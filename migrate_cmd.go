@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"portfolio/migrations"
+	"strconv"
+	"time"
+)
+
+const migrateUsage = "usage: migrate up | down [steps] | status"
+
+// runMigrate implements the "migrate" subcommand and returns the exit code
+func runMigrate(db *sql.DB, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrations.Up(ctx, db)
+		if err != nil {
+			slog.Error("Database migration failed", "error", err)
+			return 1
+		}
+		fmt.Printf("%d migrations applied\n", len(applied))
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				fmt.Fprintln(os.Stderr, migrateUsage)
+				return 2
+			}
+			steps = n
+		}
+		reverted, err := migrations.Down(ctx, db, steps)
+		if err != nil {
+			slog.Error("Reverting database migrations failed", "error", err)
+			return 1
+		}
+		fmt.Printf("%d migrations reverted\n", len(reverted))
+	case "status":
+		statuses, err := migrations.List(ctx, db)
+		if err != nil {
+			slog.Error("Cannot read the migration status", "error", err)
+			return 1
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	return 0
+}
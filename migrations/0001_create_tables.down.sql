@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS portfolio_experience;
+DROP TABLE IF EXISTS portfolio_skills;
+DROP TABLE IF EXISTS experiance_skills;
+DROP TABLE IF EXISTS experiance;
+DROP TABLE IF EXISTS portfolio;
+DROP TABLE IF EXISTS skills;
+DROP TABLE IF EXISTS users;
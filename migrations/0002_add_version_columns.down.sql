@@ -0,0 +1,3 @@
+ALTER TABLE portfolio DROP COLUMN IF EXISTS version;
+ALTER TABLE experiance DROP COLUMN IF EXISTS version;
+ALTER TABLE skills DROP COLUMN IF EXISTS version;
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS blobs (
+	key TEXT PRIMARY KEY,
+	size BIGINT NOT NULL,
+	ref_count INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,4 @@
+ALTER TABLE users DROP COLUMN IF EXISTS image_width;
+ALTER TABLE skills DROP COLUMN IF EXISTS image_width;
+ALTER TABLE portfolio DROP COLUMN IF EXISTS image_width;
+ALTER TABLE experiance DROP COLUMN IF EXISTS image_width;
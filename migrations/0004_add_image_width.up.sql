@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS image_width INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE skills ADD COLUMN IF NOT EXISTS image_width INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE portfolio ADD COLUMN IF NOT EXISTS image_width INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE experiance ADD COLUMN IF NOT EXISTS image_width INTEGER NOT NULL DEFAULT 0;
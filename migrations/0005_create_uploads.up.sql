@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS uploads (
+	id VARCHAR(36) PRIMARY KEY,
+	user_id VARCHAR(36) NOT NULL,
+	upload_length BIGINT NOT NULL,
+	upload_offset BIGINT NOT NULL DEFAULT 0,
+	metadata TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	expires_at TIMESTAMPTZ NOT NULL
+);
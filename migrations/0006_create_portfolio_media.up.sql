@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS portfolio_media (
+	id VARCHAR(36) PRIMARY KEY,
+	portfolio_id VARCHAR(36) NOT NULL,
+	type VARCHAR(16) NOT NULL,
+	key TEXT NOT NULL,
+	content_type VARCHAR(255) NOT NULL,
+	size BIGINT NOT NULL,
+	width INTEGER NOT NULL DEFAULT 0,
+	caption TEXT NOT NULL DEFAULT '',
+	alt_text VARCHAR(255) NOT NULL DEFAULT '',
+	position INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	FOREIGN KEY (portfolio_id) REFERENCES portfolio(id)
+);
+CREATE INDEX IF NOT EXISTS portfolio_media_portfolio_id_idx ON portfolio_media (portfolio_id, position);
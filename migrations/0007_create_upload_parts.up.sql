@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS upload_parts (
+	upload_id VARCHAR(36) NOT NULL,
+	part_offset BIGINT NOT NULL,
+	key TEXT NOT NULL,
+	PRIMARY KEY (upload_id, part_offset),
+	FOREIGN KEY (upload_id) REFERENCES uploads(id) ON DELETE CASCADE
+);
@@ -0,0 +1,281 @@
+// Package migrations applies the versioned SQL files embedded next to it.
+// A file is named <version>_<name>.up.sql or .down.sql; every applied
+// version is recorded in schema_migrations. A Postgres advisory lock keeps
+// two instances starting at once from migrating concurrently.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// lockID is the advisory lock key held while migrating, arbitrary but fixed
+const lockID = 7_362_145_001
+
+// Migration is one version of the schema.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration and when it was applied, if it was.
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+var filename = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// All returns the embedded migrations ordered by version.
+func All() ([]Migration, error) {
+	return load(files)
+}
+
+// load reads the migration files at the root of fsys
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		m := filename.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		data, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(data)
+		} else {
+			mig.Down = string(data)
+		}
+	}
+
+	all := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", mig.Version, mig.Name)
+		}
+		all = append(all, *mig)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Version < all[j].Version })
+	return all, nil
+}
+
+// Up applies every pending migration in order and returns the ones applied.
+func Up(ctx context.Context, db *sql.DB) ([]Migration, error) {
+	var applied []Migration
+	err := withLock(ctx, db, func(conn *sql.Conn, done map[int]time.Time) error {
+		all, err := All()
+		if err != nil {
+			return err
+		}
+		for _, mig := range notApplied(all, done) {
+			slog.InfoContext(ctx, "migrate: applying", "version", mig.Version, "name", mig.Name)
+			err := run(ctx, conn, mig.Up, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, mig.Version, mig.Name)
+			if err != nil {
+				return fmt.Errorf("applying %d_%s: %w", mig.Version, mig.Name, err)
+			}
+			applied = append(applied, mig)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down rolls back the last steps applied migrations, newest first, and
+// returns the ones rolled back.
+func Down(ctx context.Context, db *sql.DB, steps int) ([]Migration, error) {
+	var reverted []Migration
+	err := withLock(ctx, db, func(conn *sql.Conn, done map[int]time.Time) error {
+		all, err := All()
+		if err != nil {
+			return err
+		}
+		revert, err := toRevert(all, done, steps)
+		if err != nil {
+			return err
+		}
+		for _, mig := range revert {
+			slog.InfoContext(ctx, "migrate: reverting", "version", mig.Version, "name", mig.Name)
+			err := run(ctx, conn, mig.Down, `DELETE FROM schema_migrations WHERE version = $1`, mig.Version)
+			if err != nil {
+				return fmt.Errorf("reverting %d_%s: %w", mig.Version, mig.Name, err)
+			}
+			reverted = append(reverted, mig)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// List returns every embedded migration with the time it was applied.
+func List(ctx context.Context, db *sql.DB) ([]Status, error) {
+	var statuses []Status
+	err := withLock(ctx, db, func(conn *sql.Conn, done map[int]time.Time) error {
+		all, err := All()
+		if err != nil {
+			return err
+		}
+		for _, mig := range all {
+			status := Status{Migration: mig}
+			if at, ok := done[mig.Version]; ok {
+				status.AppliedAt = &at
+			}
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// Pending returns the embedded migrations that are not applied yet. It does
+// not take the migration lock, so it is cheap enough for a readiness probe.
+func Pending(ctx context.Context, db *sql.DB) ([]Migration, error) {
+	all, err := All()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	done := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		done[version] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return notApplied(all, done), nil
+}
+
+// notApplied returns the migrations of all whose version is not in done,
+// oldest first
+func notApplied[T any](all []Migration, done map[int]T) []Migration {
+	var pending []Migration
+	for _, mig := range all {
+		if _, ok := done[mig.Version]; !ok {
+			pending = append(pending, mig)
+		}
+	}
+	return pending
+}
+
+// toRevert returns the last steps migrations of all whose version is in
+// done, newest first
+func toRevert(all []Migration, done map[int]time.Time, steps int) ([]Migration, error) {
+	var revert []Migration
+	for i := len(all) - 1; i >= 0 && len(revert) < steps; i-- {
+		mig := all[i]
+		if _, ok := done[mig.Version]; !ok {
+			continue
+		}
+		if mig.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s has no down file", mig.Version, mig.Name)
+		}
+		revert = append(revert, mig)
+	}
+	return revert, nil
+}
+
+// withLock runs fn on one connection holding the migration lock, with the
+// versions applied so far
+func withLock(ctx context.Context, db *sql.DB, fn func(conn *sql.Conn, done map[int]time.Time) error) error {
+	// Session level advisory locks belong to a connection, so keep one
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID); err != nil {
+		return fmt.Errorf("acquiring migration lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID); err != nil {
+			slog.ErrorContext(ctx, "migrate: error releasing lock", "error", err)
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+	done := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			rows.Close()
+			return err
+		}
+		done[version] = at
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return fn(conn, done)
+}
+
+// run executes script and records the result in one transaction, so a
+// migration is either applied and recorded or not at all
+func run(ctx context.Context, conn *sql.Conn, script, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,184 @@
+package migrations
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	file := func(body string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(body)} }
+
+	tests := []struct {
+		name     string
+		files    fstest.MapFS
+		versions []int
+		wantErr  string
+	}{
+		{
+			name: "ordered by version, not by name",
+			files: fstest.MapFS{
+				"10_later.up.sql":     file("CREATE TABLE c ()"),
+				"2_second.up.sql":     file("CREATE TABLE b ()"),
+				"2_second.down.sql":   file("DROP TABLE b"),
+				"0001_first.up.sql":   file("CREATE TABLE a ()"),
+				"0001_first.down.sql": file("DROP TABLE a"),
+			},
+			versions: []int{1, 2, 10},
+		},
+		{name: "none", files: fstest.MapFS{}},
+		{
+			name:    "unexpected file",
+			files:   fstest.MapFS{"0001_first.up.sql": file("x"), "README.md": file("x")},
+			wantErr: "unexpected migration file README.md",
+		},
+		{
+			name:    "no version",
+			files:   fstest.MapFS{"first.up.sql": file("x")},
+			wantErr: "unexpected migration file",
+		},
+		{
+			name:    "neither up nor down",
+			files:   fstest.MapFS{"0001_first.sideways.sql": file("x")},
+			wantErr: "unexpected migration file",
+		},
+		{
+			name:    "only down",
+			files:   fstest.MapFS{"0001_first.down.sql": file("x")},
+			wantErr: "migration 1_first has no up file",
+		},
+		{
+			name:    "names differ",
+			files:   fstest.MapFS{"0001_first.up.sql": file("x"), "0001_other.down.sql": file("x")},
+			wantErr: "migration 1 is named both",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			all, err := load(tt.files)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("load() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("load() error = %v", err)
+			}
+			if got := versions(all); !equal(got, tt.versions) {
+				t.Fatalf("load() versions = %v, want %v", got, tt.versions)
+			}
+		})
+	}
+}
+
+func TestLoadContents(t *testing.T) {
+	all, err := load(fstest.MapFS{
+		"0001_create_users.up.sql":   {Data: []byte("CREATE TABLE users ()")},
+		"0001_create_users.down.sql": {Data: []byte("DROP TABLE users")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Migration{Version: 1, Name: "create_users", Up: "CREATE TABLE users ()", Down: "DROP TABLE users"}
+	if len(all) != 1 || all[0] != want {
+		t.Fatalf("load() = %+v, want %+v", all, want)
+	}
+}
+
+// TestAll checks the embedded files themselves
+func TestAll(t *testing.T) {
+	all, err := All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, mig := range all {
+		if mig.Version != i+1 {
+			t.Errorf("migration %d_%s, want version %d so none is skipped", mig.Version, mig.Name, i+1)
+		}
+		if strings.TrimSpace(mig.Down) == "" {
+			t.Errorf("migration %d_%s has no down file", mig.Version, mig.Name)
+		}
+	}
+}
+
+func TestNotApplied(t *testing.T) {
+	all := []Migration{{Version: 1, Up: "a"}, {Version: 2, Up: "b"}, {Version: 3, Up: "c"}}
+	tests := []struct {
+		name string
+		done map[int]bool
+		want []int
+	}{
+		{"fresh database", nil, []int{1, 2, 3}},
+		{"partly applied", map[int]bool{1: true}, []int{2, 3}},
+		{"gap is filled", map[int]bool{1: true, 3: true}, []int{2}},
+		{"up to date", map[int]bool{1: true, 2: true, 3: true}, nil},
+		{"unknown version applied", map[int]bool{1: true, 2: true, 3: true, 4: true}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := versions(notApplied(all, tt.done)); !equal(got, tt.want) {
+				t.Fatalf("notApplied() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToRevert(t *testing.T) {
+	all := []Migration{{Version: 1, Down: "a"}, {Version: 2}, {Version: 3, Down: "c"}, {Version: 4, Down: "d"}}
+	applied := func(versions ...int) map[int]time.Time {
+		done := make(map[int]time.Time)
+		for _, v := range versions {
+			done[v] = time.Now()
+		}
+		return done
+	}
+
+	tests := []struct {
+		name    string
+		done    map[int]time.Time
+		steps   int
+		want    []int
+		wantErr bool
+	}{
+		{"newest first", applied(1, 2, 3, 4), 2, []int{4, 3}, false},
+		{"skips pending", applied(1, 3), 2, []int{3, 1}, false},
+		{"fewer applied than steps", applied(4), 3, []int{4}, false},
+		{"nothing applied", applied(), 1, nil, false},
+		{"no steps", applied(1, 3, 4), 0, nil, false},
+		{"missing down file", applied(1, 2, 3, 4), 3, nil, true},
+		{"missing down file beyond steps", applied(1, 2, 3, 4), 2, []int{4, 3}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			revert, err := toRevert(all, tt.done, tt.steps)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("toRevert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := versions(revert); !equal(got, tt.want) {
+				t.Fatalf("toRevert() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func versions(all []Migration) []int {
+	var v []int
+	for _, mig := range all {
+		v = append(v, mig.Version)
+	}
+	return v
+}
+
+func equal(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,153 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// BlobPrefix starts the storage key of every content-addressed upload.
+// Image columns holding a bare filename predate blobs and belong to a
+// single row.
+const BlobPrefix = "blobs/"
+
+// IsBlobKey reports whether key names a reference counted blob.
+func IsBlobKey(key string) bool {
+	return strings.HasPrefix(key, BlobPrefix)
+}
+
+// ImageKey returns the storage key of an image column: blobs are stored
+// under their own key, older uploads by filename below dir.
+func ImageKey(dir, image string) string {
+	if IsBlobKey(image) {
+		return image
+	}
+	return dir + "/" + image
+}
+
+// EnsureBlob records the blob stored under key, reporting whether the
+// caller created the row and so has to store its bytes. A new row starts
+// without references; RetainBlob adds the caller's.
+func EnsureBlob(ctx context.Context, db Querier, key string, size int64) (_ bool, err error) {
+	ctx, end := begin(ctx, "EnsureBlob")
+	defer func() { end(err) }()
+
+	query := `INSERT INTO blobs (key, size) VALUES ($1, $2) ON CONFLICT (key) DO NOTHING`
+	result, err := db.ExecContext(ctx, query, key, size)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error recording blob", "error", err)
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// RetainBlob adds a reference to the blob under key.
+func RetainBlob(ctx context.Context, db Querier, key string) (err error) {
+	ctx, end := begin(ctx, "RetainBlob")
+	defer func() { end(err) }()
+
+	result, err := db.ExecContext(ctx, `UPDATE blobs SET ref_count = ref_count + 1 WHERE key = $1`, key)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error retaining blob", "error", err)
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("blob %s was removed while it was being referenced", key)
+	}
+	return nil
+}
+
+// ReleaseBlob drops a reference to the blob under key. The blob itself is
+// only removed by CollectBlob once nothing references it.
+func ReleaseBlob(ctx context.Context, db Querier, key string) (err error) {
+	ctx, end := begin(ctx, "ReleaseBlob")
+	defer func() { end(err) }()
+
+	_, err = db.ExecContext(ctx, `UPDATE blobs SET ref_count = ref_count - 1 WHERE key = $1 AND ref_count > 0`, key)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error releasing blob", "error", err)
+		return err
+	}
+	return nil
+}
+
+// CollectBlob removes the blob under key when nothing references it. The
+// row stays locked while remove deletes the stored bytes, so a concurrent
+// upload of the same content waits and then stores them again instead of
+// referencing bytes that are about to disappear.
+func CollectBlob(ctx context.Context, db *sql.DB, key string, remove func() error) error {
+	return inTx(ctx, db, func(tx Querier) error {
+		result, err := tx.ExecContext(ctx, `DELETE FROM blobs WHERE key = $1 AND ref_count = 0`, key)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error collecting blob", "error", err)
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+		return remove()
+	})
+}
+
+// ReferencedImages returns the storage key of every image a row points to,
+// along with every blob that is recorded at all.
+func ReferencedImages(ctx context.Context, db Querier) (_ []string, err error) {
+	ctx, end := begin(ctx, "ReferencedImages")
+	defer func() { end(err) }()
+
+	query := `
+		SELECT 'portfolio', image FROM portfolio WHERE image <> ''
+		UNION ALL SELECT 'experience', image FROM experiance WHERE image <> ''
+		UNION ALL SELECT 'skills', image FROM skills WHERE image <> ''
+		UNION ALL SELECT 'users', image FROM users WHERE image <> ''
+		UNION ALL SELECT '', key FROM portfolio_media
+		UNION ALL SELECT '', key FROM blobs`
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error listing referenced images", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var dir, image string
+		if err := rows.Scan(&dir, &image); err != nil {
+			slog.ErrorContext(ctx, "Error scanning referenced image", "error", err)
+			return nil, err
+		}
+		keys = append(keys, ImageKey(dir, image))
+	}
+	return keys, rows.Err()
+}
+
+// UnreferencedBlobs returns the blobs nothing has referenced since before.
+func UnreferencedBlobs(ctx context.Context, db Querier, before time.Time) (_ []string, err error) {
+	ctx, end := begin(ctx, "UnreferencedBlobs")
+	defer func() { end(err) }()
+
+	rows, err := db.QueryContext(ctx, `SELECT key FROM blobs WHERE ref_count = 0 AND created_at < $1`, before)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error listing unreferenced blobs", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
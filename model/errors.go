@@ -0,0 +1,100 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Stable error codes that API clients can switch on.
+const (
+	CodeNotFound   = "not_found"
+	CodeConflict   = "conflict"
+	CodeValidation = "validation_failed"
+	CodeForbidden  = "forbidden"
+)
+
+// Sentinels matched by errors.Is against the typed errors below.
+var (
+	ErrNotFound   = errors.New("not found")
+	ErrConflict   = errors.New("conflict")
+	ErrValidation = errors.New("validation failed")
+	ErrForbidden  = errors.New("forbidden")
+)
+
+// NotFoundError reports that a resource does not exist.
+type NotFoundError struct {
+	Resource string
+	ID       string
+}
+
+func (e *NotFoundError) Error() string {
+	if e.ID == "" {
+		return e.Resource + " not found"
+	}
+	return e.Resource + " " + e.ID + " not found"
+}
+
+func (e *NotFoundError) Is(target error) bool { return target == ErrNotFound }
+
+// ConflictError reports a write that clashes with existing data, such as a
+// duplicate key or a row that is still referenced elsewhere.
+type ConflictError struct {
+	Resource string
+	Message  string
+}
+
+func (e *ConflictError) Error() string { return e.Resource + ": " + e.Message }
+
+func (e *ConflictError) Is(target error) bool { return target == ErrConflict }
+
+// ForbiddenError reports an authenticated caller acting outside their rights.
+type ForbiddenError struct {
+	Message string
+}
+
+func (e *ForbiddenError) Error() string { return e.Message }
+
+func (e *ForbiddenError) Is(target error) bool { return target == ErrForbidden }
+
+func (v ValidationErrors) Is(target error) bool { return target == ErrValidation }
+
+// Postgres error codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	pgUniqueViolation     = "23505"
+	pgForeignKeyViolation = "23503"
+	pgLockNotAvailable    = "55P03"
+	pgQueryCanceled       = "57014"
+)
+
+// mapError translates driver errors into the domain errors above so that
+// handlers never need to know about sql or pgx.
+func mapError(err error, resource, id string) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return &NotFoundError{Resource: resource, ID: id}
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case pgUniqueViolation:
+			return &ConflictError{Resource: resource, Message: "already exists"}
+		case pgForeignKeyViolation:
+			return &ConflictError{Resource: resource, Message: "references a missing or still referenced record"}
+		case pgLockNotAvailable:
+			return &ConflictError{Resource: resource, Message: "is being modified by another request"}
+		case pgQueryCanceled:
+			// statement_timeout on the server, treated like our own deadline
+			return fmt.Errorf("%s: %w: %v", resource, context.DeadlineExceeded, err)
+		}
+	}
+
+	return err
+}
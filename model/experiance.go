@@ -1,20 +1,24 @@
 package model
 
 import (
+	"context"
 	"database/sql"
-	"log"
+	"log/slog"
 	"time"
 )
 
 type Experience struct {
 	ID          string    `json:"id"`
-	CompanyName string    `json:"company_name"`
-	Position    string    `json:"position"`
-	Image       string    `json:"image"`
-	StartDate   time.Time `json:"start_date"`
-	EndDate     time.Time `json:"end_date"`
-	Location    string    `json:"location"`
-	Skills      []Skills  `json:"skills"`
+	CompanyName string    `json:"company_name" validate:"required,max=255"`
+	Position    string    `json:"position" validate:"required,max=255"`
+	Image       string    `json:"-"`
+	ImageWidth  int       `json:"-"`
+	ImageSet    *ImageSet `json:"image,omitempty"`
+	StartDate   time.Time `json:"start_date" validate:"required"`
+	EndDate     time.Time `json:"end_date" validate:"required,gtefield=StartDate"`
+	Location    string    `json:"location" validate:"max=255"`
+	Skills      []Skills  `json:"skills" validate:"-"`
+	Version     int       `json:"version"`
 }
 
 type ExperienceSkill struct {
@@ -22,91 +26,95 @@ type ExperienceSkill struct {
 	SkillID      string `json:"skill_id"`
 }
 
-func (p *Experience) AddSkills(db *sql.DB, skillIDs []string) error {
-	tx, err := db.Begin()
-	if err != nil {
-		log.Printf("Error starting transaction: %v\n", err)
-		return err
-	}
-
-	stmt, err := tx.Prepare("INSERT INTO experiance_skills (experiance_id, skill_id) VALUES ($1, $2)")
-	if err != nil {
-		tx.Rollback()
-		log.Printf("Error preparing sql statement: %v\n", err)
-		return err
-	}
-	defer stmt.Close()
+func (p *Experience) AddSkills(ctx context.Context, db Querier, skillIDs []string) (err error) {
+	ctx, end := begin(ctx, "Experience.AddSkills")
+	defer func() { end(err) }()
 
-	//execute the statement for each skill id
-	for _, SkillID := range skillIDs {
-		_, err := stmt.Exec(p.ID, SkillID)
+	return inTx(ctx, db, func(tx Querier) error {
+		stmt, err := tx.PrepareContext(ctx, "INSERT INTO experiance_skills (experiance_id, skill_id) VALUES ($1, $2)")
 		if err != nil {
-			tx.Rollback()
-			log.Printf("Error executing sql statement: %v\n", err)
+			slog.ErrorContext(ctx, "Error preparing sql statement", "error", err)
 			return err
 		}
-	}
+		defer stmt.Close()
+
+		//execute the statement for each skill id
+		for _, SkillID := range skillIDs {
+			_, err := stmt.ExecContext(ctx, p.ID, SkillID)
+			if err != nil {
+				slog.ErrorContext(ctx, "Error executing sql statement", "error", err)
+				return mapError(err, "experience skill", SkillID)
+			}
+		}
+		return nil
+	})
+}
 
-	//commit the transaction
-	if err := tx.Commit(); err != nil {
-		log.Printf("Error commiting trasaction: %v\n", err)
-		return err
+func InsertExperience(ctx context.Context, db Querier, experience *Experience) (err error) {
+	ctx, end := begin(ctx, "InsertExperience")
+	defer func() { end(err) }()
+
+	if errs := Validate(experience); errs != nil {
+		return errs
 	}
-	return nil
-}
 
-func InsertExperience(db *sql.DB, experience *Experience) error {
-	query := `INSERT INTO experiance (id, company_name, position, image, start_date, end_date, location) VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	query := `INSERT INTO experiance (id, company_name, position, image, start_date, end_date, location, image_width) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING version`
 
-	_, err := db.Exec(query, experience.ID, experience.CompanyName, experience.Position, experience.Image, experience.StartDate, experience.EndDate, experience.Location)
+	err = db.QueryRowContext(ctx, query, experience.ID, experience.CompanyName, experience.Position, experience.Image, experience.StartDate, experience.EndDate, experience.Location, experience.ImageWidth).Scan(&experience.Version)
 
 	if err != nil {
-		log.Printf("Error inserting experience: %v\n", err)
-		return err
+		slog.ErrorContext(ctx, "Error inserting experience", "error", err)
+		return mapError(err, "experience", experience.ID)
 	}
 
 	return nil
 }
 
-func UpdateExperience(db *sql.DB, experiance *Experience) error {
-	query := `UPDATE experiance SET company_name = $2, position = $3, image = $4, start_date = $5, end_date = $6, location = $7 WHERE id = $1`
-	_, err := db.Exec(query, experiance.ID, experiance.CompanyName, experiance.Position, experiance.Image, experiance.StartDate, experiance.EndDate, experiance.Location)
+// UpdateExperience saves experiance when it is still at experiance.Version,
+// which is then replaced by the new version.
+func UpdateExperience(ctx context.Context, db Querier, experiance *Experience) (err error) {
+	ctx, end := begin(ctx, "UpdateExperience")
+	defer func() { end(err) }()
+
+	if errs := Validate(experiance); errs != nil {
+		return errs
+	}
+
+	query := `UPDATE experiance SET company_name = $2, position = $3, image = $4, start_date = $5, end_date = $6, location = $7, image_width = $9, version = version + 1 WHERE id = $1 AND version = $8 RETURNING version`
+	err = db.QueryRowContext(ctx, query, experiance.ID, experiance.CompanyName, experiance.Position, experiance.Image, experiance.StartDate, experiance.EndDate, experiance.Location, experiance.Version, experiance.ImageWidth).Scan(&experiance.Version)
 
+	if err == sql.ErrNoRows {
+		return staleOrMissing(ctx, db, "experiance", "experience", experiance.ID)
+	}
 	if err != nil {
-		log.Printf("Error updating experiance: %v\n", err)
-		return err
+		slog.ErrorContext(ctx, "Error updating experiance", "error", err)
+		return mapError(err, "experience", experiance.ID)
 	}
 	return nil
 }
 
-func DeleteExperience(db *sql.DB, experianceID string) error {
-	tx, err := db.Begin()
-	if err != nil {
-		log.Printf("Error Starting transaction: %v\n", err)
-		return err
-	}
+func DeleteExperience(ctx context.Context, db Querier, experianceID string) (err error) {
+	ctx, end := begin(ctx, "DeleteExperience")
+	defer func() { end(err) }()
 
 	deleteQuery := `DELETE FROM experiance WHERE id = $1`
-	if _, err := tx.Exec(deleteQuery, experianceID); err != nil {
-		tx.Rollback()
-		log.Printf("Error Deleting experince: %v\n", err)
-		return err
-	}
-
-	if err := tx.Commit(); err != nil {
-		log.Printf("Error Commiting transaction: %v\n", err)
-		return err
+	if _, err := db.ExecContext(ctx, deleteQuery, experianceID); err != nil {
+		slog.ErrorContext(ctx, "Error Deleting experince", "error", err)
+		return mapError(err, "experience", experianceID)
 	}
 	return nil
 }
 
-func GetExperience(db *sql.DB, offset int, limit int) ([]*Experience, error) {
-	query := `SELECT id, company_name, position, image, start_date, end_date, location FROM experiance LIMIT $1 OFFSET $2`
+func GetExperience(ctx context.Context, db Querier, offset int, limit int) (_ []*Experience, err error) {
+	ctx, end := begin(ctx, "GetExperience")
+	defer func() { end(err) }()
 
-	rows, err := db.Query(query, limit, offset)
+	query := `SELECT id, company_name, position, image, image_width, start_date, end_date, location, version FROM experiance LIMIT $1 OFFSET $2`
+
+	rows, err := db.QueryContext(ctx, query, limit, offset)
 
 	if err != nil {
-		log.Printf("Error querying experience: %v\n", err)
+		slog.ErrorContext(ctx, "Error querying experience", "error", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -114,96 +122,91 @@ func GetExperience(db *sql.DB, offset int, limit int) ([]*Experience, error) {
 	var experiances []*Experience
 	for rows.Next() {
 		var experience Experience
-		if err := rows.Scan(&experience.ID, &experience.CompanyName, &experience.Position, &experience.Image, &experience.StartDate, &experience.EndDate, &experience.Location); err != nil {
-			log.Printf("Error Scanning experence: %v\n", err)
+		if err := rows.Scan(&experience.ID, &experience.CompanyName, &experience.Position, &experience.Image, &experience.ImageWidth, &experience.StartDate, &experience.EndDate, &experience.Location, &experience.Version); err != nil {
+			slog.ErrorContext(ctx, "Error Scanning experence", "error", err)
 			return nil, err
 		}
 		experiances = append(experiances, &experience)
 	}
 
 	if err = rows.Err(); err != nil {
-		log.Printf("Error during rows iterationL %v\n", err)
+		slog.ErrorContext(ctx, "Error during rows iteration", "error", err)
 		return nil, err
 	}
 
 	return experiances, nil
 }
 
-func GetExperienceID(db *sql.DB, experienceID string) (*Experience, error) {
-	experienceQuery := `SELECT id, company_name, image, position,start_date, end_date, location FROM experiance WHERE id = $1`
-	row := db.QueryRow(experienceQuery, experienceID)
+func GetExperienceID(ctx context.Context, db Querier, experienceID string) (_ *Experience, err error) {
+	ctx, end := begin(ctx, "GetExperienceID")
+	defer func() { end(err) }()
+
+	experienceQuery := `SELECT id, company_name, image, image_width, position,start_date, end_date, location, version FROM experiance WHERE id = $1`
+	row := db.QueryRowContext(ctx, experienceQuery, experienceID)
 
 	var experience Experience
-	err := row.Scan(&experience.ID, &experience.CompanyName, &experience.Image, &experience.Position, &experience.StartDate, &experience.StartDate, &experience.Location)
+	err = row.Scan(&experience.ID, &experience.CompanyName, &experience.Image, &experience.ImageWidth, &experience.Position, &experience.StartDate, &experience.EndDate, &experience.Location, &experience.Version)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			log.Printf("No experience found with id: %v\n", err)
-			return nil, err
+			slog.WarnContext(ctx, "No experience found", "experience_id", experienceID)
+			return nil, &NotFoundError{Resource: "experience", ID: experienceID}
 		}
-		log.Printf("Error retrieving experience: %v\n", err)
+		slog.ErrorContext(ctx, "Error retrieving experience", "error", err)
 		return nil, err
 	}
 
 	return &experience, nil
 }
 
-func DeleteSkillAndExperienceRelations(db *sql.DB, skillID string, portfolioID string) error {
-	tx, err := db.Begin()
-	if err != nil {
-		log.Printf("Error starting transaction: %v", err)
-		return err
-	}
+func DeleteSkillAndExperienceRelations(ctx context.Context, db Querier, skillID string, portfolioID string) (err error) {
+	ctx, end := begin(ctx, "DeleteSkillAndExperienceRelations")
+	defer func() { end(err) }()
 
 	// Delete relations from portfolio_skills table for the given skill ID and portfolio ID
 	deleteRelationsQuery := `DELETE FROM experiance_skills WHERE skill_id = $1 AND experiance_id = $2`
-	if _, err := tx.Exec(deleteRelationsQuery, skillID, portfolioID); err != nil {
-		tx.Rollback()
-		log.Printf("Error deleting relations: %v", err)
-		return err
-	}
-
-	if err := tx.Commit(); err != nil {
-		log.Printf("Error committing transaction: %v", err)
+	if _, err := db.ExecContext(ctx, deleteRelationsQuery, skillID, portfolioID); err != nil {
+		slog.ErrorContext(ctx, "Error deleting relations", "error", err)
 		return err
 	}
 	return nil
 }
 
-func DeleteExperienceAndRelations(db *sql.DB, portfolioID string) error {
-	tx, err := db.Begin()
-	if err != nil {
-		log.Printf("Error starting transaction: %v", err)
-		return err
-	}
-
-	// Delete relations from portfolio_skills table only, do not delete the skills themselves
-	deleteRelationsQuery := `DELETE FROM experiance_skills WHERE experiance_id = $1`
-	if _, err := tx.Exec(deleteRelationsQuery, portfolioID); err != nil {
-		tx.Rollback()
-		log.Printf("Error deleting portfolio-skill relations: %v", err)
-		return err
-	}
-
-	// Delete the portfolio from portfolio table
-	deletePortfolioQuery := `DELETE FROM experiance WHERE id = $1`
-	if _, err := tx.Exec(deletePortfolioQuery, portfolioID); err != nil {
-		tx.Rollback()
-		log.Printf("Error deleting portfolio: %v", err)
-		return err
-	}
+// DeleteExperienceAndRelations deletes the experience and its skill relations
+// when it is still at the given version.
+func DeleteExperienceAndRelations(ctx context.Context, db Querier, portfolioID string, version int) (err error) {
+	ctx, end := begin(ctx, "DeleteExperienceAndRelations")
+	defer func() { end(err) }()
+
+	return inTx(ctx, db, func(tx Querier) error {
+		// Delete relations from portfolio_skills table only, do not delete the skills themselves
+		deleteRelationsQuery := `DELETE FROM experiance_skills WHERE experiance_id = $1`
+		if _, err := tx.ExecContext(ctx, deleteRelationsQuery, portfolioID); err != nil {
+			slog.ErrorContext(ctx, "Error deleting portfolio-skill relations", "error", err)
+			return err
+		}
 
-	if err := tx.Commit(); err != nil {
-		log.Printf("Error committing transaction: %v", err)
-		return err
-	}
-	return nil
+		// Delete the portfolio from portfolio table
+		deletePortfolioQuery := `DELETE FROM experiance WHERE id = $1 AND version = $2`
+		res, err := tx.ExecContext(ctx, deletePortfolioQuery, portfolioID, version)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error deleting portfolio", "error", err)
+			return mapError(err, "experience", portfolioID)
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return staleOrMissing(ctx, tx, "experiance", "experience", portfolioID)
+		}
+		return nil
+	})
 }
 
-func GetSkillByExperienceID(db *sql.DB, experienceID string) ([]Skills, error) {
-	query := `SELECT skills.id, skills.name, skills.image FROM skills INNER JOIN experiance_skills ON skills.id = experiance_skills.skill_id WHERE experiance_skills.experiance_id = $1`
-	rows, err := db.Query(query, experienceID)
+func GetSkillByExperienceID(ctx context.Context, db Querier, experienceID string) (_ []Skills, err error) {
+	ctx, end := begin(ctx, "GetSkillByExperienceID")
+	defer func() { end(err) }()
+
+	query := `SELECT skills.id, skills.name, skills.image, skills.image_width, skills.version FROM skills INNER JOIN experiance_skills ON skills.id = experiance_skills.skill_id WHERE experiance_skills.experiance_id = $1`
+	rows, err := db.QueryContext(ctx, query, experienceID)
 	if err != nil {
-		log.Printf("Error querying skill by experience id: %v", err)
+		slog.ErrorContext(ctx, "Error querying skill by experience id", "error", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -211,8 +214,8 @@ func GetSkillByExperienceID(db *sql.DB, experienceID string) ([]Skills, error) {
 	var skills []Skills
 	for rows.Next() {
 		var skill Skills
-		if err := rows.Scan(&skill.ID, &skill.Name, &skill.Image); err != nil {
-			log.Printf("Error scanning skill: %v", err)
+		if err := rows.Scan(&skill.ID, &skill.Name, &skill.Image, &skill.ImageWidth, &skill.Version); err != nil {
+			slog.ErrorContext(ctx, "Error scanning skill", "error", err)
 			return nil, err
 		}
 
@@ -220,7 +223,7 @@ func GetSkillByExperienceID(db *sql.DB, experienceID string) ([]Skills, error) {
 	}
 
 	if err = rows.Err(); err != nil {
-		log.Printf("Error during skill rows iteration: %v", err)
+		slog.ErrorContext(ctx, "Error during skill rows iteration", "error", err)
 		return nil, err
 	}
 
@@ -0,0 +1,21 @@
+package model
+
+// ImageSet is how an uploaded image appears in API responses: the original
+// plus the resized variants generated for it, ready for an <img srcset>.
+// Images uploaded before variants existed only have Src.
+type ImageSet struct {
+	Src        string        `json:"src"`
+	Width      int           `json:"width,omitempty"`
+	Srcset     string        `json:"srcset,omitempty"`
+	SrcsetWebP string        `json:"srcset_webp,omitempty"`
+	Thumbnail  *ImageVariant `json:"thumbnail,omitempty"`
+	Medium     *ImageVariant `json:"medium,omitempty"`
+	Large      *ImageVariant `json:"large,omitempty"`
+}
+
+// ImageVariant is one resized copy of an image.
+type ImageVariant struct {
+	URL   string `json:"url"`
+	WebP  string `json:"webp,omitempty"`
+	Width int    `json:"width"`
+}
@@ -1,21 +1,26 @@
 package model
 
 import (
+	"context"
 	"database/sql"
-	"log"
+	"log/slog"
 	"time"
 )
 
 type Portfolio struct {
-	ID          string      `json:"id"`
-	Title       string      `json:"title"`
-	Subtitle    string      `json:"subtitle"`
-	Image       string      `json:"image"`
-	Content     string      `json:"content"`
-	Status      string      `json:"status"`
-	DateProject time.Time   `json:"date_project"`
-	Skills      []Skills    `json:"skills"`
-	Experience  *Experience `json:"experience"`
+	ID          string           `json:"id"`
+	Title       string           `json:"title" validate:"required,max=255"`
+	Subtitle    string           `json:"subtitle" validate:"max=255"`
+	Image       string           `json:"-"`
+	ImageWidth  int              `json:"-"`
+	ImageSet    *ImageSet        `json:"image,omitempty"`
+	Content     string           `json:"content"`
+	Status      string           `json:"status" validate:"max=255"`
+	DateProject time.Time        `json:"date_project" validate:"required"`
+	Skills      []Skills         `json:"skills" validate:"-"`
+	Experience  *Experience      `json:"experience" validate:"-"`
+	Media       []PortfolioMedia `json:"media" validate:"-"`
+	Version     int              `json:"version"`
 }
 
 type PortfolioSkill struct {
@@ -24,127 +29,98 @@ type PortfolioSkill struct {
 }
 
 // Function to associate multiple skills with a single portfolio
-func (p *Portfolio) AddSkills(db *sql.DB, skillIDs []string) error {
-	// Start a transaction
-	tx, err := db.Begin()
-	if err != nil {
-		log.Printf("Error starting transaction: %v", err)
-		return err
-	}
+func (p *Portfolio) AddSkills(ctx context.Context, db Querier, skillIDs []string) (err error) {
+	ctx, end := begin(ctx, "Portfolio.AddSkills")
+	defer func() { end(err) }()
 
-	// Prepare the SQL statement for inserting portfolio-skill relationships
-	stmt, err := tx.Prepare("INSERT INTO portfolio_skills (portfolio_id, skill_id) VALUES ($1, $2)")
-	if err != nil {
-		tx.Rollback()
-		log.Printf("Error preparing SQL statement: %v", err)
-		return err
-	}
-	defer stmt.Close()
-
-	// Execute the statement for each skill ID
-	for _, skillID := range skillIDs {
-		_, err := stmt.Exec(p.ID, skillID)
+	return inTx(ctx, db, func(tx Querier) error {
+		// Prepare the SQL statement for inserting portfolio-skill relationships
+		stmt, err := tx.PrepareContext(ctx, "INSERT INTO portfolio_skills (portfolio_id, skill_id) VALUES ($1, $2)")
 		if err != nil {
-			tx.Rollback()
-			log.Printf("Error executing SQL statement: %v", err)
+			slog.ErrorContext(ctx, "Error preparing SQL statement", "error", err)
 			return err
 		}
-	}
-
-	// Commit the transaction
-	if err := tx.Commit(); err != nil {
-		log.Printf("Error committing transaction: %v", err)
-		return err
-	}
-	return nil
+		defer stmt.Close()
+
+		// Execute the statement for each skill ID
+		for _, skillID := range skillIDs {
+			_, err := stmt.ExecContext(ctx, p.ID, skillID)
+			if err != nil {
+				slog.ErrorContext(ctx, "Error executing SQL statement", "error", err)
+				return mapError(err, "portfolio skill", skillID)
+			}
+		}
+		return nil
+	})
 }
 
 // add experience
-func (p *Portfolio) AddExperience(db *sql.DB, experienceID string) error {
-	// Start a transaction
-	tx, err := db.Begin()
-	if err != nil {
-		log.Printf("Error starting transaction: %v", err)
-		return err
-	}
+func (p *Portfolio) AddExperience(ctx context.Context, db Querier, experienceID string) (err error) {
+	ctx, end := begin(ctx, "Portfolio.AddExperience")
+	defer func() { end(err) }()
 
-	// Prepare the SQL statement for inserting portfolio-experience relationships
-	stmt, err := tx.Prepare("INSERT INTO portfolio_experience (portfolio_id, experiance_id) VALUES ($1, $2)")
-	if err != nil {
-		tx.Rollback()
-		log.Printf("Error preparing SQL statement: %v", err)
-		return err
-	}
-	defer stmt.Close()
-
-	// Execute the statement
-	_, err = stmt.Exec(p.ID, experienceID)
-	if err != nil {
-		tx.Rollback()
-		log.Printf("Error executing SQL statement: %v", err)
-		return err
-	}
-
-	// Commit the transaction
-	if err := tx.Commit(); err != nil {
-		log.Printf("Error committing transaction: %v", err)
-		return err
+	query := "INSERT INTO portfolio_experience (portfolio_id, experiance_id) VALUES ($1, $2)"
+	if _, err := db.ExecContext(ctx, query, p.ID, experienceID); err != nil {
+		slog.ErrorContext(ctx, "Error executing SQL statement", "error", err)
+		return mapError(err, "portfolio experience", experienceID)
 	}
 	return nil
 }
 
 // update experience
-func (p *Portfolio) UpdateExperiencePortfolio(db *sql.DB, experienceID string) error {
-	// Start a transaction
-	tx, err := db.Begin()
-	if err != nil {
-		log.Printf("Error starting transaction: %v", err)
-		return err
-	}
-
-	// Prepare the SQL statement for inserting portfolio-skill relationships
-	stmt, err := tx.Prepare("UPDATE portfolio_experience SET experiance_id = $2 WHERE portfolio_id = $1")
-	if err != nil {
-		tx.Rollback()
-		log.Printf("Error preparing SQL statement: %v", err)
-		return err
-	}
-	defer stmt.Close()
+func (p *Portfolio) UpdateExperiencePortfolio(ctx context.Context, db Querier, experienceID string) (err error) {
+	ctx, end := begin(ctx, "Portfolio.UpdateExperiencePortfolio")
+	defer func() { end(err) }()
+
+	return inTx(ctx, db, func(tx Querier) error {
+		// Replace the relation so portfolios created without an experience can get one
+		if _, err := tx.ExecContext(ctx, "DELETE FROM portfolio_experience WHERE portfolio_id = $1", p.ID); err != nil {
+			slog.ErrorContext(ctx, "Error executing SQL statement", "error", err)
+			return err
+		}
+		return p.AddExperience(ctx, tx, experienceID)
+	})
+}
 
-	// Execute the statement for each skill ID
-	_, err = stmt.Exec(p.ID, experienceID)
-	if err != nil {
-		tx.Rollback()
-		log.Printf("Error executing SQL statement: %v", err)
-		return err
-	}
+// remove experience
+func (p *Portfolio) RemoveExperience(ctx context.Context, db Querier) (err error) {
+	ctx, end := begin(ctx, "Portfolio.RemoveExperience")
+	defer func() { end(err) }()
 
-	// Commit the transaction
-	if err := tx.Commit(); err != nil {
-		log.Printf("Error committing transaction: %v", err)
+	if _, err := db.ExecContext(ctx, "DELETE FROM portfolio_experience WHERE portfolio_id = $1", p.ID); err != nil {
+		slog.ErrorContext(ctx, "Error executing SQL statement", "error", err)
 		return err
 	}
 	return nil
-
 }
 
 // Function to insert a new portfolio into the database
-func InsertPortfolio(db *sql.DB, portfolio *Portfolio) error {
-	query := `INSERT INTO portfolio (id, title, subtitle, image, content, status, date_project) VALUES ($1, $2, $3, $4, $5, $6, $7)`
-	_, err := db.Exec(query, portfolio.ID, portfolio.Title, portfolio.Subtitle, portfolio.Image, portfolio.Content, portfolio.Status, portfolio.DateProject)
+func InsertPortfolio(ctx context.Context, db Querier, portfolio *Portfolio) (err error) {
+	ctx, end := begin(ctx, "InsertPortfolio")
+	defer func() { end(err) }()
+
+	if errs := Validate(portfolio); errs != nil {
+		return errs
+	}
+
+	query := `INSERT INTO portfolio (id, title, subtitle, image, content, status, date_project, image_width) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING version`
+	err = db.QueryRowContext(ctx, query, portfolio.ID, portfolio.Title, portfolio.Subtitle, portfolio.Image, portfolio.Content, portfolio.Status, portfolio.DateProject, portfolio.ImageWidth).Scan(&portfolio.Version)
 	if err != nil {
-		log.Printf("Error inserting portfolio: %v", err)
-		return err
+		slog.ErrorContext(ctx, "Error inserting portfolio", "error", err)
+		return mapError(err, "portfolio", portfolio.ID)
 	}
 	return nil
 }
 
 // Function to retrieve a portfolio along with its associated skills
-func GetPortfoliosPaginated(db *sql.DB, offset int, limit int) ([]*Portfolio, error) {
-	query := `SELECT id, title, subtitle, image, content, status, date_project FROM portfolio LIMIT $1 OFFSET $2`
-	rows, err := db.Query(query, limit, offset)
+func GetPortfoliosPaginated(ctx context.Context, db Querier, offset int, limit int) (_ []*Portfolio, err error) {
+	ctx, end := begin(ctx, "GetPortfoliosPaginated")
+	defer func() { end(err) }()
+
+	query := `SELECT id, title, subtitle, image, image_width, content, status, date_project, version FROM portfolio LIMIT $1 OFFSET $2`
+	rows, err := db.QueryContext(ctx, query, limit, offset)
 	if err != nil {
-		log.Printf("Error querying portfolios: %v", err)
+		slog.ErrorContext(ctx, "Error querying portfolios", "error", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -152,15 +128,15 @@ func GetPortfoliosPaginated(db *sql.DB, offset int, limit int) ([]*Portfolio, er
 	var portfolios []*Portfolio
 	for rows.Next() {
 		var portfolio Portfolio
-		if err := rows.Scan(&portfolio.ID, &portfolio.Title, &portfolio.Subtitle, &portfolio.Image, &portfolio.Content, &portfolio.Status, &portfolio.DateProject); err != nil {
-			log.Printf("Error scanning portfolio: %v", err)
+		if err := rows.Scan(&portfolio.ID, &portfolio.Title, &portfolio.Subtitle, &portfolio.Image, &portfolio.ImageWidth, &portfolio.Content, &portfolio.Status, &portfolio.DateProject, &portfolio.Version); err != nil {
+			slog.ErrorContext(ctx, "Error scanning portfolio", "error", err)
 			return nil, err
 		}
 		portfolios = append(portfolios, &portfolio)
 	}
 
 	if err = rows.Err(); err != nil {
-		log.Printf("Error during rows iteration: %v", err)
+		slog.ErrorContext(ctx, "Error during rows iteration", "error", err)
 		return nil, err
 	}
 
@@ -168,123 +144,141 @@ func GetPortfoliosPaginated(db *sql.DB, offset int, limit int) ([]*Portfolio, er
 }
 
 // Function to delete a skill and its relations from the database
-func DeleteSkillAndPortfolioRelations(db *sql.DB, skillID string, portfolioID string) error {
-	tx, err := db.Begin()
-	if err != nil {
-		log.Printf("Error starting transaction: %v", err)
-		return err
-	}
+func DeleteSkillAndPortfolioRelations(ctx context.Context, db Querier, skillID string, portfolioID string) (err error) {
+	ctx, end := begin(ctx, "DeleteSkillAndPortfolioRelations")
+	defer func() { end(err) }()
 
 	// Delete relations from portfolio_skills table for the given skill ID and portfolio ID
 	deleteRelationsQuery := `DELETE FROM portfolio_skills WHERE skill_id = $1 AND portfolio_id = $2`
-	if _, err := tx.Exec(deleteRelationsQuery, skillID, portfolioID); err != nil {
-		tx.Rollback()
-		log.Printf("Error deleting relations: %v", err)
-		return err
-	}
-
-	if err := tx.Commit(); err != nil {
-		log.Printf("Error committing transaction: %v", err)
+	if _, err := db.ExecContext(ctx, deleteRelationsQuery, skillID, portfolioID); err != nil {
+		slog.ErrorContext(ctx, "Error deleting relations", "error", err)
 		return err
 	}
 	return nil
 }
 
-// Function to update a portfolio in the database
-func UpdatePortfolio(db *sql.DB, portfolio *Portfolio) error {
-	query := `UPDATE portfolio SET title = $2, subtitle = $3, image = $4, content = $5, status = $6, date_project = $7 WHERE id = $1`
-	_, err := db.Exec(query, portfolio.ID, portfolio.Title, portfolio.Subtitle, portfolio.Image, portfolio.Content, portfolio.Status, portfolio.DateProject)
+// Function to update a portfolio in the database. portfolio.Version must hold
+// the version the caller read; it is replaced by the new version on success.
+func UpdatePortfolio(ctx context.Context, db Querier, portfolio *Portfolio) (err error) {
+	ctx, end := begin(ctx, "UpdatePortfolio")
+	defer func() { end(err) }()
+
+	if errs := Validate(portfolio); errs != nil {
+		return errs
+	}
+
+	query := `UPDATE portfolio SET title = $2, subtitle = $3, image = $4, content = $5, status = $6, date_project = $7, image_width = $9, version = version + 1 WHERE id = $1 AND version = $8 RETURNING version`
+	err = db.QueryRowContext(ctx, query, portfolio.ID, portfolio.Title, portfolio.Subtitle, portfolio.Image, portfolio.Content, portfolio.Status, portfolio.DateProject, portfolio.Version, portfolio.ImageWidth).Scan(&portfolio.Version)
+	if err == sql.ErrNoRows {
+		return staleOrMissing(ctx, db, "portfolio", "portfolio", portfolio.ID)
+	}
 	if err != nil {
-		log.Printf("Error updating portfolio: %v", err)
-		return err
+		slog.ErrorContext(ctx, "Error updating portfolio", "error", err)
+		return mapError(err, "portfolio", portfolio.ID)
 	}
 	return nil
 }
 
 // GetPortfolioByID retrieves a portfolio by its ID along with its associated skills
-func GetPortfolioByID(db *sql.DB, portfolioID string) (*Portfolio, error) {
-	portfolioQuery := `SELECT id, title, subtitle, image, content, status, date_project FROM portfolio WHERE id = $1`
-	row := db.QueryRow(portfolioQuery, portfolioID)
+func GetPortfolioByID(ctx context.Context, db Querier, portfolioID string) (_ *Portfolio, err error) {
+	ctx, end := begin(ctx, "GetPortfolioByID")
+	defer func() { end(err) }()
+
+	portfolioQuery := `SELECT id, title, subtitle, image, image_width, content, status, date_project, version FROM portfolio WHERE id = $1`
+	row := db.QueryRowContext(ctx, portfolioQuery, portfolioID)
 
 	var portfolio Portfolio
-	err := row.Scan(&portfolio.ID, &portfolio.Title, &portfolio.Subtitle, &portfolio.Image, &portfolio.Content, &portfolio.Status, &portfolio.DateProject)
+	err = row.Scan(&portfolio.ID, &portfolio.Title, &portfolio.Subtitle, &portfolio.Image, &portfolio.ImageWidth, &portfolio.Content, &portfolio.Status, &portfolio.DateProject, &portfolio.Version)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			log.Printf("No portfolio found with ID: %v", portfolioID)
-			return nil, err
+			slog.WarnContext(ctx, "No portfolio found", "portfolio_id", portfolioID)
+			return nil, &NotFoundError{Resource: "portfolio", ID: portfolioID}
 		}
-		log.Printf("Error retrieving portfolio: %v", err)
+		slog.ErrorContext(ctx, "Error retrieving portfolio", "error", err)
 		return nil, err
 	}
 
 	// Retrieve associated skills
-	skills, err := GetSkillsByPortfolioID(db, portfolioID)
+	skills, err := GetSkillsByPortfolioID(ctx, db, portfolioID)
 	if err != nil {
-		log.Printf("Error retrieving skills for portfolio %s: %v", portfolioID, err)
+		slog.ErrorContext(ctx, "Error retrieving skills for portfolio", "portfolio_id", portfolioID, "error", err)
 		return nil, err
 	}
 
 	// Retrieve associated experience
-	experience, err := GetExperienceByPortfolioID(db, portfolioID)
+	experience, err := GetExperienceByPortfolioID(ctx, db, portfolioID)
 	if err != nil {
-		log.Printf("Error retrieving experience for portfolio %s: %v", portfolioID, err)
+		slog.ErrorContext(ctx, "Error retrieving experience for portfolio", "portfolio_id", portfolioID, "error", err)
 		return nil, err
 
 	}
 
+	// Retrieve the gallery
+	media, err := GetPortfolioMedia(ctx, db, portfolioID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error retrieving media for portfolio", "portfolio_id", portfolioID, "error", err)
+		return nil, err
+	}
+
 	portfolio.Skills = skills
 	portfolio.Experience = experience
+	portfolio.Media = media
 
 	return &portfolio, nil
 }
 
-// Function to delete a portfolio and its relations from the database without deleting the master skills
-func DeletePortfolioAndRelations(db *sql.DB, portfolioID string) error {
-	tx, err := db.Begin()
-	if err != nil {
-		log.Printf("Error starting transaction: %v", err)
-		return err
-	}
-
-	// Delete relations from portfolio_skills table only, do not delete the skills themselves
-	deleteRelationsQuery := `DELETE FROM portfolio_skills WHERE portfolio_id = $1`
-	if _, err := tx.Exec(deleteRelationsQuery, portfolioID); err != nil {
-		tx.Rollback()
-		log.Printf("Error deleting portfolio-skill relations: %v", err)
-		return err
-	}
+// Function to delete a portfolio and its relations from the database without deleting the master skills.
+// Nothing is deleted unless the portfolio is still at the given version.
+func DeletePortfolioAndRelations(ctx context.Context, db Querier, portfolioID string, version int) (err error) {
+	ctx, end := begin(ctx, "DeletePortfolioAndRelations")
+	defer func() { end(err) }()
+
+	return inTx(ctx, db, func(tx Querier) error {
+		// Delete relations from portfolio_skills table only, do not delete the skills themselves
+		deleteRelationsQuery := `DELETE FROM portfolio_skills WHERE portfolio_id = $1`
+		if _, err := tx.ExecContext(ctx, deleteRelationsQuery, portfolioID); err != nil {
+			slog.ErrorContext(ctx, "Error deleting portfolio-skill relations", "error", err)
+			return err
+		}
 
-	// Delete relations from portfolio_experience table
-	deleteExperienceRelationsQuery := `DELETE FROM portfolio_experience WHERE portfolio_id = $1`
-	if _, err := tx.Exec(deleteExperienceRelationsQuery, portfolioID); err != nil {
-		tx.Rollback()
-		log.Printf("Error deleting portfolio-experience relations: %v", err)
-		return err
-	}
+		// Delete relations from portfolio_experience table
+		deleteExperienceRelationsQuery := `DELETE FROM portfolio_experience WHERE portfolio_id = $1`
+		if _, err := tx.ExecContext(ctx, deleteExperienceRelationsQuery, portfolioID); err != nil {
+			slog.ErrorContext(ctx, "Error deleting portfolio-experience relations", "error", err)
+			return err
+		}
 
-	// Delete the portfolio from portfolio table
-	deletePortfolioQuery := `DELETE FROM portfolio WHERE id = $1`
-	if _, err := tx.Exec(deletePortfolioQuery, portfolioID); err != nil {
-		tx.Rollback()
-		log.Printf("Error deleting portfolio: %v", err)
-		return err
-	}
+		// Delete the gallery rows, their blobs are released by the caller
+		if _, err := tx.ExecContext(ctx, `DELETE FROM portfolio_media WHERE portfolio_id = $1`, portfolioID); err != nil {
+			slog.ErrorContext(ctx, "Error deleting portfolio media", "error", err)
+			return err
+		}
 
-	if err := tx.Commit(); err != nil {
-		log.Printf("Error committing transaction: %v", err)
-		return err
-	}
-	return nil
+		// Delete the portfolio from portfolio table
+		deletePortfolioQuery := `DELETE FROM portfolio WHERE id = $1 AND version = $2`
+		res, err := tx.ExecContext(ctx, deletePortfolioQuery, portfolioID, version)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error deleting portfolio", "error", err)
+			return mapError(err, "portfolio", portfolioID)
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return staleOrMissing(ctx, tx, "portfolio", "portfolio", portfolioID)
+		}
+		return nil
+	})
 }
 
 // GetSkillsByPortfolioID retrieves the skills associated with a given portfolio ID
-func GetSkillsByPortfolioID(db *sql.DB, portfolioID string) ([]Skills, error) {
-	query := `SELECT skills.id, skills.name, skills.image FROM skills 
+func GetSkillsByPortfolioID(ctx context.Context, db Querier, portfolioID string) (_ []Skills, err error) {
+	ctx, end := begin(ctx, "GetSkillsByPortfolioID")
+	defer func() { end(err) }()
+
+	query := `SELECT skills.id, skills.name, skills.image, skills.image_width, skills.version FROM skills 
 	          INNER JOIN portfolio_skills ON skills.id = portfolio_skills.skill_id 
 	          WHERE portfolio_skills.portfolio_id = $1`
-	rows, err := db.Query(query, portfolioID)
+	rows, err := db.QueryContext(ctx, query, portfolioID)
 	if err != nil {
-		log.Printf("Error querying skills by portfolio ID: %v", err)
+		slog.ErrorContext(ctx, "Error querying skills by portfolio ID", "error", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -292,15 +286,15 @@ func GetSkillsByPortfolioID(db *sql.DB, portfolioID string) ([]Skills, error) {
 	var skills []Skills
 	for rows.Next() {
 		var skill Skills
-		if err := rows.Scan(&skill.ID, &skill.Name, &skill.Image); err != nil {
-			log.Printf("Error scanning skill: %v", err)
+		if err := rows.Scan(&skill.ID, &skill.Name, &skill.Image, &skill.ImageWidth, &skill.Version); err != nil {
+			slog.ErrorContext(ctx, "Error scanning skill", "error", err)
 			return nil, err
 		}
 		skills = append(skills, skill)
 	}
 
 	if err = rows.Err(); err != nil {
-		log.Printf("Error during skills rows iteration: %v", err)
+		slog.ErrorContext(ctx, "Error during skills rows iteration", "error", err)
 		return nil, err
 	}
 
@@ -308,26 +302,29 @@ func GetSkillsByPortfolioID(db *sql.DB, portfolioID string) ([]Skills, error) {
 }
 
 // get experience by portfolio id
-func GetExperienceByPortfolioID(db *sql.DB, portfolioID string) (*Experience, error) {
-	query := `SELECT experiance.id, experiance.company_name, experiance.position, experiance.image, experiance.start_date, experiance.end_date, experiance.location FROM experiance INNER JOIN portfolio_experience ON experiance.id = portfolio_experience.experiance_id WHERE portfolio_experience.portfolio_id = $1`
+func GetExperienceByPortfolioID(ctx context.Context, db Querier, portfolioID string) (_ *Experience, err error) {
+	ctx, end := begin(ctx, "GetExperienceByPortfolioID")
+	defer func() { end(err) }()
+
+	query := `SELECT experiance.id, experiance.company_name, experiance.position, experiance.image, experiance.image_width, experiance.start_date, experiance.end_date, experiance.location, experiance.version FROM experiance INNER JOIN portfolio_experience ON experiance.id = portfolio_experience.experiance_id WHERE portfolio_experience.portfolio_id = $1`
 
-	rows, err := db.Query(query, portfolioID)
+	rows, err := db.QueryContext(ctx, query, portfolioID)
 	if err != nil {
-		log.Printf("Error querying experience by portfolio ID: %v", err)
+		slog.ErrorContext(ctx, "Error querying experience by portfolio ID", "error", err)
 		return nil, err
 	}
 	defer rows.Close()
 
 	var experience Experience
 	for rows.Next() {
-		if err := rows.Scan(&experience.ID, &experience.CompanyName, &experience.Position, &experience.Image, &experience.StartDate, &experience.EndDate, &experience.Location); err != nil {
-			log.Printf("Error scanning experience: %v", err)
+		if err := rows.Scan(&experience.ID, &experience.CompanyName, &experience.Position, &experience.Image, &experience.ImageWidth, &experience.StartDate, &experience.EndDate, &experience.Location, &experience.Version); err != nil {
+			slog.ErrorContext(ctx, "Error scanning experience", "error", err)
 			return nil, err
 		}
 	}
 
 	if err = rows.Err(); err != nil {
-		log.Printf("Error during experience rows iteration: %v", err)
+		slog.ErrorContext(ctx, "Error during experience rows iteration", "error", err)
 		return nil, err
 	}
 
@@ -0,0 +1,158 @@
+package model
+
+import (
+	"context"
+	"log/slog"
+)
+
+// PortfolioMedia is an image, document or video in the gallery of a
+// portfolio. Its bytes are a blob, referenced once per row.
+type PortfolioMedia struct {
+	ID          string    `json:"id"`
+	PortfolioID string    `json:"portfolio_id"`
+	Type        string    `json:"type" validate:"required,oneof=image document video"`
+	Key         string    `json:"-"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Width       int       `json:"-"`
+	Caption     string    `json:"caption" validate:"max=1000"`
+	AltText     string    `json:"alt_text" validate:"max=255"`
+	Position    int       `json:"position"`
+	URL         string    `json:"url"`
+	ImageSet    *ImageSet `json:"image,omitempty"`
+}
+
+const portfolioMediaColumns = `id, portfolio_id, type, key, content_type, size, width, caption, alt_text, position`
+
+// InsertPortfolioMedia appends media to the end of its portfolio gallery.
+func InsertPortfolioMedia(ctx context.Context, db Querier, media *PortfolioMedia) (err error) {
+	ctx, end := begin(ctx, "InsertPortfolioMedia")
+	defer func() { end(err) }()
+
+	if errs := Validate(media); errs != nil {
+		return errs
+	}
+
+	query := `INSERT INTO portfolio_media (` + portfolioMediaColumns + `)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, COALESCE(MAX(position) + 1, 0) FROM portfolio_media WHERE portfolio_id = $2
+		RETURNING position`
+	err = db.QueryRowContext(ctx, query, media.ID, media.PortfolioID, media.Type, media.Key, media.ContentType, media.Size, media.Width, media.Caption, media.AltText).Scan(&media.Position)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error inserting portfolio media", "error", err)
+		return mapError(err, "portfolio media", media.ID)
+	}
+	return nil
+}
+
+// GetPortfolioMedia returns the gallery of a portfolio in display order.
+func GetPortfolioMedia(ctx context.Context, db Querier, portfolioID string) (_ []PortfolioMedia, err error) {
+	ctx, end := begin(ctx, "GetPortfolioMedia")
+	defer func() { end(err) }()
+
+	query := `SELECT ` + portfolioMediaColumns + ` FROM portfolio_media WHERE portfolio_id = $1 ORDER BY position, id`
+	rows, err := db.QueryContext(ctx, query, portfolioID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error querying portfolio media", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	media := []PortfolioMedia{}
+	for rows.Next() {
+		var m PortfolioMedia
+		if err := rows.Scan(&m.ID, &m.PortfolioID, &m.Type, &m.Key, &m.ContentType, &m.Size, &m.Width, &m.Caption, &m.AltText, &m.Position); err != nil {
+			slog.ErrorContext(ctx, "Error scanning portfolio media", "error", err)
+			return nil, err
+		}
+		media = append(media, m)
+	}
+	return media, rows.Err()
+}
+
+// GetPortfolioMediaByID returns one media item of a portfolio.
+func GetPortfolioMediaByID(ctx context.Context, db Querier, portfolioID, mediaID string) (_ *PortfolioMedia, err error) {
+	ctx, end := begin(ctx, "GetPortfolioMediaByID")
+	defer func() { end(err) }()
+
+	query := `SELECT ` + portfolioMediaColumns + ` FROM portfolio_media WHERE portfolio_id = $1 AND id = $2`
+
+	var m PortfolioMedia
+	err = db.QueryRowContext(ctx, query, portfolioID, mediaID).Scan(&m.ID, &m.PortfolioID, &m.Type, &m.Key, &m.ContentType, &m.Size, &m.Width, &m.Caption, &m.AltText, &m.Position)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error retrieving portfolio media", "error", err)
+		return nil, mapError(err, "portfolio media", mediaID)
+	}
+	return &m, nil
+}
+
+// DeletePortfolioMedia removes media from its gallery and closes the gap
+// it leaves in the order. The blob reference is the caller's to release.
+func DeletePortfolioMedia(ctx context.Context, db Querier, media *PortfolioMedia) (err error) {
+	ctx, end := begin(ctx, "DeletePortfolioMedia")
+	defer func() { end(err) }()
+
+	return inTx(ctx, db, func(tx Querier) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM portfolio_media WHERE id = $1`, media.ID); err != nil {
+			slog.ErrorContext(ctx, "Error deleting portfolio media", "error", err)
+			return err
+		}
+
+		query := `UPDATE portfolio_media SET position = position - 1 WHERE portfolio_id = $1 AND position > $2`
+		if _, err := tx.ExecContext(ctx, query, media.PortfolioID, media.Position); err != nil {
+			slog.ErrorContext(ctx, "Error reordering portfolio media", "error", err)
+			return err
+		}
+		return nil
+	})
+}
+
+// ReorderPortfolioMedia puts the gallery of a portfolio in the order of
+// mediaIDs, which must list each of its media exactly once.
+func ReorderPortfolioMedia(ctx context.Context, db Querier, portfolioID string, mediaIDs []string) (err error) {
+	ctx, end := begin(ctx, "ReorderPortfolioMedia")
+	defer func() { end(err) }()
+
+	return inTx(ctx, db, func(tx Querier) error {
+		current, err := GetPortfolioMedia(ctx, tx, portfolioID)
+		if err != nil {
+			return err
+		}
+		if err := CheckMediaOrder(current, mediaIDs); err != nil {
+			return err
+		}
+
+		stmt, err := tx.PrepareContext(ctx, `UPDATE portfolio_media SET position = $3 WHERE portfolio_id = $1 AND id = $2`)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error preparing SQL statement", "error", err)
+			return err
+		}
+		defer stmt.Close()
+
+		for position, id := range mediaIDs {
+			if _, err := stmt.ExecContext(ctx, portfolioID, id, position); err != nil {
+				slog.ErrorContext(ctx, "Error reordering portfolio media", "error", err)
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CheckMediaOrder reports whether mediaIDs lists each media of gallery
+// exactly once, as a new order for it must.
+func CheckMediaOrder(gallery []PortfolioMedia, mediaIDs []string) error {
+	remaining := make(map[string]bool, len(gallery))
+	for _, m := range gallery {
+		remaining[m.ID] = true
+	}
+	for _, id := range mediaIDs {
+		if !remaining[id] {
+			return ValidationErrors{{Field: "media_ids", Code: CodeInvalid, Message: "must list every media of the portfolio exactly once, " + id + " is unknown or repeated"}}
+		}
+		delete(remaining, id)
+	}
+	if len(remaining) > 0 {
+		return ValidationErrors{{Field: "media_ids", Code: CodeInvalid, Message: "must list every media of the portfolio exactly once"}}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"portfolio/metrics"
+	"portfolio/tracing"
+	"time"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// queryTimeout bounds each model function, 0 leaves it to the caller
+var queryTimeout time.Duration
+
+// SetQueryTimeout sets how long a model function may wait on the
+// database before its queries are cancelled. It must be called before
+// serving requests.
+func SetQueryTimeout(d time.Duration) {
+	queryTimeout = d
+}
+
+// begin starts the model function named function: it returns ctx bounded
+// by the query timeout and carrying the span of the function. The
+// returned func ends the span with the error the function returned,
+// records how long the function took and releases the deadline.
+func begin(ctx context.Context, function string) (context.Context, func(err error)) {
+	start := time.Now()
+	cancel := context.CancelFunc(func() {})
+	if queryTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, queryTimeout)
+	}
+	ctx, span := tracing.Start(ctx, "model."+function, semconv.DBSystemPostgreSQL)
+	return ctx, func(err error) {
+		// A missing row is an answer, not a failure
+		var notFound *NotFoundError
+		if errors.As(err, &notFound) {
+			err = nil
+		}
+		tracing.End(span, err)
+		cancel()
+		metrics.ObserveQuery(function, start)
+	}
+}
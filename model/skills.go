@@ -1,44 +1,57 @@
 package model
 
 import (
+	"context"
 	"database/sql"
-	"errors"
-	"log"
+	"log/slog"
 )
 
 type Skills struct {
-	ID    string `json:"id"`
-	Name  string `json:"name"`
-	Image string `json:"image,omitempty"`
+	ID         string    `json:"id"`
+	Name       string    `json:"name" validate:"required,max=255"`
+	Image      string    `json:"-"`
+	ImageWidth int       `json:"-"`
+	ImageSet   *ImageSet `json:"image,omitempty"`
+	Version    int       `json:"version"`
 }
 
-func InsertSkills(db *sql.DB, skills Skills) error {
+func InsertSkills(ctx context.Context, db Querier, skills Skills) (err error) {
+	ctx, end := begin(ctx, "InsertSkills")
+	defer func() { end(err) }()
+
 	if db == nil {
-		log.Println("Error: Database is nil")
+		slog.ErrorContext(ctx, "Database is nil")
 		return ErrDBNil
 	}
 
-	query := `INSERT INTO skills (id, name, image) VALUES ($1, $2, $3);`
-	_, err := db.Exec(query, skills.ID, skills.Name, skills.Image)
+	if errs := Validate(skills); errs != nil {
+		return errs
+	}
+
+	query := `INSERT INTO skills (id, name, image, image_width) VALUES ($1, $2, $3, $4) RETURNING version;`
+	err = db.QueryRowContext(ctx, query, skills.ID, skills.Name, skills.Image, skills.ImageWidth).Scan(&skills.Version)
 
 	if err != nil {
-		log.Printf("Error inserting skills: %v", err)
-		return err
+		slog.ErrorContext(ctx, "Error inserting skills", "error", err)
+		return mapError(err, "skill", skills.ID)
 	}
 
 	return nil
 }
 
-func GetListSkills(db *sql.DB, offset int, limit int) ([]Skills, error) {
+func GetListSkills(ctx context.Context, db Querier, offset int, limit int) (_ []Skills, err error) {
+	ctx, end := begin(ctx, "GetListSkills")
+	defer func() { end(err) }()
+
 	if db == nil {
-		log.Println("Error: Database is nil")
+		slog.ErrorContext(ctx, "Database is nil")
 		return nil, ErrDBNil
 	}
 
-	query := `SELECT id, name, image FROM skills LIMIT $1 OFFSET $2`
-	rows, err := db.Query(query, limit, offset)
+	query := `SELECT id, name, image, image_width, version FROM skills LIMIT $1 OFFSET $2`
+	rows, err := db.QueryContext(ctx, query, limit, offset)
 	if err != nil {
-		log.Printf("Error querying skills: %v", err)
+		slog.ErrorContext(ctx, "Error querying skills", "error", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -46,71 +59,93 @@ func GetListSkills(db *sql.DB, offset int, limit int) ([]Skills, error) {
 	var skillsList []Skills
 	for rows.Next() {
 		var skill Skills
-		if err := rows.Scan(&skill.ID, &skill.Name, &skill.Image); err != nil {
-			log.Printf("Error scanning skills: %v", err)
+		if err := rows.Scan(&skill.ID, &skill.Name, &skill.Image, &skill.ImageWidth, &skill.Version); err != nil {
+			slog.ErrorContext(ctx, "Error scanning skills", "error", err)
 			return nil, err
 		}
 		skillsList = append(skillsList, skill)
 	}
 
 	if err = rows.Err(); err != nil {
-		log.Printf("Error during rows iteration: %v", err)
+		slog.ErrorContext(ctx, "Error during rows iteration", "error", err)
 		return nil, err
 	}
 
 	return skillsList, nil
 }
 
-func DeleteSkill(db *sql.DB, skillID string) error {
+// DeleteSkill deletes the skill when it is still at the given version
+func DeleteSkill(ctx context.Context, db Querier, skillID string, version int) (err error) {
+	ctx, end := begin(ctx, "DeleteSkill")
+	defer func() { end(err) }()
+
 	if db == nil {
-		log.Println("Error: Database is nil")
+		slog.ErrorContext(ctx, "Database is nil")
 		return ErrDBNil
 	}
 
-	query := `DELETE FROM skills WHERE id = $1`
-	_, err := db.Exec(query, skillID)
+	query := `DELETE FROM skills WHERE id = $1 AND version = $2`
+	res, err := db.ExecContext(ctx, query, skillID, version)
 
 	if err != nil {
-		log.Printf("Error deleting skill: %v", err)
-		return err
+		slog.ErrorContext(ctx, "Error deleting skill", "error", err)
+		return mapError(err, "skill", skillID)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return staleOrMissing(ctx, db, "skills", "skill", skillID)
 	}
 
 	return nil
 }
 
-func UpdateSkill(db *sql.DB, skill *Skills) error {
+// UpdateSkill saves skill when it is still at skill.Version, which is then
+// replaced by the new version
+func UpdateSkill(ctx context.Context, db Querier, skill *Skills) (err error) {
+	ctx, end := begin(ctx, "UpdateSkill")
+	defer func() { end(err) }()
+
 	if db == nil {
-		log.Println("Error: Database is nil")
+		slog.ErrorContext(ctx, "Database is nil")
 		return ErrDBNil
 	}
 
-	query := `UPDATE skills SET name = $2, image = $3 WHERE id = $1`
-	_, err := db.Exec(query, skill.ID, skill.Name, skill.Image)
+	if errs := Validate(skill); errs != nil {
+		return errs
+	}
+
+	query := `UPDATE skills SET name = $2, image = $3, image_width = $5, version = version + 1 WHERE id = $1 AND version = $4 RETURNING version`
+	err = db.QueryRowContext(ctx, query, skill.ID, skill.Name, skill.Image, skill.Version, skill.ImageWidth).Scan(&skill.Version)
+	if err == sql.ErrNoRows {
+		return staleOrMissing(ctx, db, "skills", "skill", skill.ID)
+	}
 	if err != nil {
-		log.Printf("Error updating skill: %v", err)
-		return err
+		slog.ErrorContext(ctx, "Error updating skill", "error", err)
+		return mapError(err, "skill", skill.ID)
 	}
 
 	return nil
 }
 
-func GetSkillID(db *sql.DB, skillID string) (*Skills, error) {
+func GetSkillID(ctx context.Context, db Querier, skillID string) (_ *Skills, err error) {
+	ctx, end := begin(ctx, "GetSkillID")
+	defer func() { end(err) }()
+
 	if db == nil {
-		log.Println("Error: Database is nil")
+		slog.ErrorContext(ctx, "Database is nil")
 		return nil, ErrDBNil
 	}
 
-	query := `SELECT id, name, image FROM skills WHERE id = $1`
-	row := db.QueryRow(query, skillID)
+	query := `SELECT id, name, image, image_width, version FROM skills WHERE id = $1`
+	row := db.QueryRowContext(ctx, query, skillID)
 
 	var skill Skills
-	err := row.Scan(&skill.ID, &skill.Name, &skill.Image)
+	err = row.Scan(&skill.ID, &skill.Name, &skill.Image, &skill.ImageWidth, &skill.Version)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			log.Println("Error: No skill found")
-			return nil, errors.New("no skill found")
+			slog.ErrorContext(ctx, "No skill found")
+			return nil, &NotFoundError{Resource: "skill", ID: skillID}
 		}
-		log.Printf("Error scanning skill: %v", err)
+		slog.ErrorContext(ctx, "Error scanning skill", "error", err)
 		return nil, err
 	}
 
@@ -0,0 +1,108 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+)
+
+// Querier is satisfied by both *sql.DB and *sql.Tx, so model functions can
+// run on their own or as one step of a UnitOfWork.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// UnitOfWork groups several model calls into a single database transaction
+// and defers side effects such as file deletions until the outcome is known.
+type UnitOfWork struct {
+	Tx *sql.Tx
+
+	ctx        context.Context
+	onCommit   []func()
+	onRollback []func()
+}
+
+// RunInTx runs fn inside one transaction. It commits when fn returns nil and
+// rolls back otherwise, then runs the matching hooks registered by fn.
+func RunInTx(ctx context.Context, db *sql.DB, fn func(uow *UnitOfWork) error) (err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error starting transaction", "error", err)
+		return err
+	}
+
+	uow := &UnitOfWork{Tx: tx, ctx: ctx}
+	defer func() {
+		if p := recover(); p != nil {
+			uow.rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(uow); err != nil {
+		uow.rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.ErrorContext(ctx, "Error committing transaction", "error", err)
+		uow.runHooks(uow.onRollback)
+		return err
+	}
+
+	uow.runHooks(uow.onCommit)
+	return nil
+}
+
+// OnCommit registers fn to run after the transaction commits.
+func (u *UnitOfWork) OnCommit(fn func()) {
+	u.onCommit = append(u.onCommit, fn)
+}
+
+// OnRollback registers fn to run after the transaction is rolled back.
+func (u *UnitOfWork) OnRollback(fn func()) {
+	u.onRollback = append(u.onRollback, fn)
+}
+
+func (u *UnitOfWork) rollback() {
+	if err := u.Tx.Rollback(); err != nil && err != sql.ErrTxDone {
+		slog.ErrorContext(u.ctx, "Error rolling back transaction", "error", err)
+	}
+	u.runHooks(u.onRollback)
+}
+
+func (u *UnitOfWork) runHooks(hooks []func()) {
+	for _, hook := range hooks {
+		hook()
+	}
+}
+
+// inTx runs fn in a transaction: q itself when it already is one, otherwise
+// a new transaction begun on q. This keeps multi-statement model functions
+// atomic whether or not they are called from a UnitOfWork.
+func inTx(ctx context.Context, q Querier, fn func(tx Querier) error) error {
+	db, ok := q.(*sql.DB)
+	if !ok {
+		return fn(q)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error starting transaction", "error", err)
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.ErrorContext(ctx, "Error committing transaction", "error", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// UploadPrefix starts the storage key of every part of a resumable upload.
+const UploadPrefix = "tus/"
+
+// Upload is a resumable upload in progress. Its bytes are stored as parts
+// under UploadPrefix, one per request that appended to it. Only the parts
+// recorded in upload_parts hold its bytes; the others belong to requests
+// that failed or lost to a concurrent one.
+type Upload struct {
+	ID        string
+	UserID    string
+	Length    int64
+	Offset    int64
+	Metadata  string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// Done reports whether every byte of the upload has been received.
+func (u *Upload) Done() bool {
+	return u.Offset == u.Length
+}
+
+// PartsPrefix returns the storage prefix of the parts of the upload.
+func (u *Upload) PartsPrefix() string {
+	return UploadPrefix + u.ID + "/"
+}
+
+// PartKey returns the storage key of the part that the request attempt
+// writes from offset. Every attempt writes its own part, so concurrent
+// requests never overwrite a part that was recorded. Offsets are zero
+// padded so parts list in the order they were appended.
+func (u *Upload) PartKey(offset int64, attempt string) string {
+	return fmt.Sprintf("%s%020d-%s", u.PartsPrefix(), offset, attempt)
+}
+
+const uploadColumns = `id, user_id, upload_length, upload_offset, metadata, created_at, expires_at`
+
+func scanUpload(row interface{ Scan(...interface{}) error }) (*Upload, error) {
+	var u Upload
+	err := row.Scan(&u.ID, &u.UserID, &u.Length, &u.Offset, &u.Metadata, &u.CreatedAt, &u.ExpiresAt)
+	return &u, err
+}
+
+func InsertUpload(ctx context.Context, db Querier, upload *Upload) (err error) {
+	ctx, end := begin(ctx, "InsertUpload")
+	defer func() { end(err) }()
+
+	query := `INSERT INTO uploads (id, user_id, upload_length, metadata, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING created_at`
+	err = db.QueryRowContext(ctx, query, upload.ID, upload.UserID, upload.Length, upload.Metadata, upload.ExpiresAt).Scan(&upload.CreatedAt)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error inserting upload", "error", err)
+		return mapError(err, "upload", upload.ID)
+	}
+	return nil
+}
+
+// GetUpload returns the upload with id unless it belongs to another user
+// or has expired.
+func GetUpload(ctx context.Context, db Querier, id, userID string) (_ *Upload, err error) {
+	ctx, end := begin(ctx, "GetUpload")
+	defer func() { end(err) }()
+
+	row := db.QueryRowContext(ctx, `SELECT `+uploadColumns+` FROM uploads WHERE id = $1 AND expires_at > now()`, id)
+	return checkUpload(row, id, userID)
+}
+
+// LockUpload is GetUpload for a request that appends to the upload. The
+// row stays locked until the transaction ends; a second request writing
+// the same upload meanwhile fails with a ConflictError instead of waiting.
+func LockUpload(ctx context.Context, db Querier, id, userID string) (_ *Upload, err error) {
+	ctx, end := begin(ctx, "LockUpload")
+	defer func() { end(err) }()
+
+	row := db.QueryRowContext(ctx, `SELECT `+uploadColumns+` FROM uploads WHERE id = $1 AND expires_at > now() FOR UPDATE NOWAIT`, id)
+	return checkUpload(row, id, userID)
+}
+
+func checkUpload(row interface{ Scan(...interface{}) error }, id, userID string) (*Upload, error) {
+	upload, err := scanUpload(row)
+	if err != nil {
+		return nil, mapError(err, "upload", id)
+	}
+	if upload.UserID != userID {
+		return nil, &ForbiddenError{Message: "upload belongs to another user"}
+	}
+	return upload, nil
+}
+
+func UpdateUploadOffset(ctx context.Context, db Querier, id string, offset int64) (err error) {
+	ctx, end := begin(ctx, "UpdateUploadOffset")
+	defer func() { end(err) }()
+
+	_, err = db.ExecContext(ctx, `UPDATE uploads SET upload_offset = $2 WHERE id = $1`, id, offset)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error updating upload offset", "error", err)
+		return err
+	}
+	return nil
+}
+
+// AddUploadPart records the part stored under key as the bytes of the
+// upload from offset. A second part for the same offset is a conflict.
+func AddUploadPart(ctx context.Context, db Querier, id string, offset int64, key string) (err error) {
+	ctx, end := begin(ctx, "AddUploadPart")
+	defer func() { end(err) }()
+
+	_, err = db.ExecContext(ctx, `INSERT INTO upload_parts (upload_id, part_offset, key) VALUES ($1, $2, $3)`, id, offset, key)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error recording upload part", "error", err)
+		return mapError(err, "upload part", id)
+	}
+	return nil
+}
+
+// UploadParts returns the storage keys of the recorded parts of an upload
+// in offset order.
+func UploadParts(ctx context.Context, db Querier, id string) (_ []string, err error) {
+	ctx, end := begin(ctx, "UploadParts")
+	defer func() { end(err) }()
+
+	rows, err := db.QueryContext(ctx, `SELECT key FROM upload_parts WHERE upload_id = $1 ORDER BY part_offset`, id)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error listing upload parts", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func DeleteUpload(ctx context.Context, db Querier, id string) (err error) {
+	ctx, end := begin(ctx, "DeleteUpload")
+	defer func() { end(err) }()
+
+	_, err = db.ExecContext(ctx, `DELETE FROM uploads WHERE id = $1`, id)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error deleting upload", "error", err)
+		return err
+	}
+	return nil
+}
+
+// ActiveUploads returns the ids of the uploads that have not expired.
+func ActiveUploads(ctx context.Context, db Querier) (_ []string, err error) {
+	ctx, end := begin(ctx, "ActiveUploads")
+	defer func() { end(err) }()
+
+	rows, err := db.QueryContext(ctx, `SELECT id FROM uploads WHERE expires_at > now()`)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error listing uploads", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// DeleteExpiredUploads forgets the uploads that expired, returning how
+// many there were. Their parts are left for the garbage collector.
+func DeleteExpiredUploads(ctx context.Context, db Querier) (_ int64, err error) {
+	ctx, end := begin(ctx, "DeleteExpiredUploads")
+	defer func() { end(err) }()
+
+	result, err := db.ExecContext(ctx, `DELETE FROM uploads WHERE expires_at <= now()`)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error deleting expired uploads", "error", err)
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -1,64 +1,110 @@
 package model
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 )
 
 type User struct {
-	ID       string  `json:"id"`
-	Name     string  `json:"name"`
-	Email    string  `json:"email" gorm:"unique"`
-	Password string  `json:"password,omitempty"`
-	Image    string  `json:"image,omitempty"`
-	Token    *string `json:"token,omitempty"` // Token can be null
+	ID         string    `json:"id"`
+	Name       string    `json:"name" validate:"required,max=255"`
+	Email      string    `json:"email" gorm:"unique" validate:"required,email,max=255"`
+	Password   string    `json:"password,omitempty" validate:"required"`
+	Image      string    `json:"-"`
+	ImageWidth int       `json:"-"`
+	ImageSet   *ImageSet `json:"image,omitempty"`
+	Token      *string   `json:"token,omitempty"` // Token can be null
 }
 
 var (
 	ErrDBNil = errors.New("koneksi tidak tersedia")
 )
 
-func InsertUser(db *sql.DB, user User) error {
+func InsertUser(ctx context.Context, db Querier, user User) (err error) {
+	ctx, end := begin(ctx, "InsertUser")
+	defer func() { end(err) }()
+
 	if db == nil {
 		return ErrDBNil
 	}
 
-	query := `INSERT INTO users (id, name, email, password, image) VALUES ($1, $2, $3, $4, $5);`
-	_, err := db.Exec(query, user.ID, user.Name, user.Email, user.Password, user.Image)
+	if errs := Validate(user); errs != nil {
+		return errs
+	}
+
+	query := `INSERT INTO users (id, name, email, password, image, image_width) VALUES ($1, $2, $3, $4, $5, $6);`
+	_, err = db.ExecContext(ctx, query, user.ID, user.Name, user.Email, user.Password, user.Image, user.ImageWidth)
 	if err != nil {
-		return err
+		return mapError(err, "user", user.ID)
 	}
 
 	return nil
 }
 
-func UpdateUser(db *sql.DB, user User) error {
+func UpdateUser(ctx context.Context, db Querier, user User) (err error) {
+	ctx, end := begin(ctx, "UpdateUser")
+	defer func() { end(err) }()
+
 	if db == nil {
 		return ErrDBNil
 	}
 
-	query := `UPDATE users SET name=$2, email=$3, password=$4, image=$5, token=$6 WHERE id=$1;`
-	_, err := db.Exec(query, user.ID, user.Name, user.Email, user.Password, user.Image, user.Token)
+	if errs := Validate(user); errs != nil {
+		return errs
+	}
+
+	query := `UPDATE users SET name=$2, email=$3, password=$4, image=$5, token=$6, image_width=$7 WHERE id=$1;`
+	_, err = db.ExecContext(ctx, query, user.ID, user.Name, user.Email, user.Password, user.Image, user.Token, user.ImageWidth)
 	if err != nil {
-		return err
+		return mapError(err, "user", user.ID)
+	}
+
+	return nil
+}
+
+// UpdateUserProfile saves the name and email of user, leaving the password,
+// image and token untouched.
+func UpdateUserProfile(ctx context.Context, db Querier, user User) (err error) {
+	ctx, end := begin(ctx, "UpdateUserProfile")
+	defer func() { end(err) }()
+
+	if db == nil {
+		return ErrDBNil
+	}
+
+	if errs := ValidateFields(user, "Name", "Email"); errs != nil {
+		return errs
+	}
+
+	query := `UPDATE users SET name=$2, email=$3 WHERE id=$1;`
+	result, err := db.ExecContext(ctx, query, user.ID, user.Name, user.Email)
+	if err != nil {
+		return mapError(err, "user", user.ID)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return &NotFoundError{Resource: "user", ID: user.ID}
 	}
 
 	return nil
 }
 
-func GetUserID(db *sql.DB, userID string) (*User, error) {
+func GetUserID(ctx context.Context, db Querier, userID string) (_ *User, err error) {
+	ctx, end := begin(ctx, "GetUserID")
+	defer func() { end(err) }()
+
 	if db == nil {
 		return nil, ErrDBNil
 	}
 
-	query := `SELECT id, name, email, image, token FROM users WHERE id = $1;`
-	row := db.QueryRow(query, userID)
+	query := `SELECT id, name, email, image, image_width, token FROM users WHERE id = $1;`
+	row := db.QueryRowContext(ctx, query, userID)
 
 	var user User
-	err := row.Scan(&user.ID, &user.Name, &user.Email, &user.Image, &user.Token)
+	err = row.Scan(&user.ID, &user.Name, &user.Email, &user.Image, &user.ImageWidth, &user.Token)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, errors.New("no user found")
+			return nil, &NotFoundError{Resource: "user", ID: userID}
 		}
 		return nil, err
 	}
@@ -66,19 +112,22 @@ func GetUserID(db *sql.DB, userID string) (*User, error) {
 	return &user, nil
 }
 
-func GetUserByEmail(db *sql.DB, userEmail string) (*User, error) {
+func GetUserByEmail(ctx context.Context, db Querier, userEmail string) (_ *User, err error) {
+	ctx, end := begin(ctx, "GetUserByEmail")
+	defer func() { end(err) }()
+
 	if db == nil {
 		return nil, ErrDBNil
 	}
 
-	query := `SELECT id, name, email, image, password FROM users WHERE email = $1;`
-	row := db.QueryRow(query, userEmail)
+	query := `SELECT id, name, email, image, image_width, password FROM users WHERE email = $1;`
+	row := db.QueryRowContext(ctx, query, userEmail)
 
 	var user User
-	err := row.Scan(&user.ID, &user.Name, &user.Email, &user.Image, &user.Password)
+	err = row.Scan(&user.ID, &user.Name, &user.Email, &user.Image, &user.ImageWidth, &user.Password)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, errors.New("no user found with the specified email")
+			return nil, &NotFoundError{Resource: "user"}
 		}
 		return nil, err
 	}
@@ -86,17 +135,19 @@ func GetUserByEmail(db *sql.DB, userEmail string) (*User, error) {
 	return &user, nil
 }
 
-func DeleteUser(db *sql.DB, userID string) error {
+func DeleteUser(ctx context.Context, db Querier, userID string) (err error) {
+	ctx, end := begin(ctx, "DeleteUser")
+	defer func() { end(err) }()
+
 	if db == nil {
 		return ErrDBNil
 	}
 
 	query := `DELETE FROM users WHERE id = $1;`
-	_, err := db.Exec(query, userID)
+	_, err = db.ExecContext(ctx, query, userID)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
-
@@ -0,0 +1,129 @@
+package model
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Machine-readable codes reported for each failing field.
+const (
+	CodeRequired      = "required"
+	CodeTooLong       = "too_long"
+	CodeTooShort      = "too_short"
+	CodeTooLarge      = "too_large"
+	CodeInvalidEmail  = "invalid_email"
+	CodeInvalidChoice = "invalid_choice"
+	CodeBeforeStart   = "before_start_date"
+	CodeInvalidDate   = "invalid_date"
+	CodeInvalid       = "invalid"
+)
+
+// FieldError describes a single field that failed validation.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors lists every field that failed validation.
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(v))
+	for _, fe := range v {
+		msgs = append(msgs, fe.Field+": "+fe.Message)
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+// Merge appends the errors of more on fields v does not report yet, so a
+// field that already failed to parse is not reported as missing as well.
+func (v ValidationErrors) Merge(more ValidationErrors) ValidationErrors {
+	reported := make(map[string]bool, len(v))
+	for _, fe := range v {
+		reported[fe.Field] = true
+	}
+	for _, fe := range more {
+		if !reported[fe.Field] {
+			v = append(v, fe)
+		}
+	}
+	return v
+}
+
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+
+	// Report fields by their JSON name so they match the form inputs
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" || name == "" {
+			return field.Name
+		}
+		return name
+	})
+
+	return v
+}
+
+// Validate checks v against the `validate` rules declared on its struct
+// fields and returns every failing field, or nil when v is valid.
+func Validate(v interface{}) ValidationErrors {
+	return toValidationErrors(validate.Struct(v))
+}
+
+// ValidateFields is Validate restricted to the named struct fields, for
+// writes that only touch part of a model.
+func ValidateFields(v interface{}, fields ...string) ValidationErrors {
+	return toValidationErrors(validate.StructPartial(v, fields...))
+}
+
+func toValidationErrors(err error) ValidationErrors {
+	if err == nil {
+		return nil
+	}
+
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return ValidationErrors{{Field: "", Code: CodeInvalid, Message: err.Error()}}
+	}
+
+	errs := make(ValidationErrors, 0, len(verrs))
+	for _, fe := range verrs {
+		errs = append(errs, toFieldError(fe))
+	}
+	return errs
+}
+
+// RequireNonEmpty reports field as missing when values is empty.
+func RequireNonEmpty(field string, values []string) ValidationErrors {
+	if len(values) > 0 {
+		return nil
+	}
+	return ValidationErrors{{Field: field, Code: CodeRequired, Message: "at least one value is required"}}
+}
+
+func toFieldError(fe validator.FieldError) FieldError {
+	field := fe.Field()
+	switch fe.Tag() {
+	case "required":
+		return FieldError{Field: field, Code: CodeRequired, Message: "is required"}
+	case "max":
+		return FieldError{Field: field, Code: CodeTooLong, Message: "must be at most " + fe.Param() + " characters"}
+	case "min":
+		return FieldError{Field: field, Code: CodeTooShort, Message: "must be at least " + fe.Param() + " characters"}
+	case "email":
+		return FieldError{Field: field, Code: CodeInvalidEmail, Message: "must be a valid email address"}
+	case "oneof":
+		return FieldError{Field: field, Code: CodeInvalidChoice, Message: "must be one of: " + fe.Param()}
+	case "gtefield":
+		return FieldError{Field: field, Code: CodeBeforeStart, Message: "must not be before start_date"}
+	default:
+		return FieldError{Field: field, Code: CodeInvalid, Message: "is invalid"}
+	}
+}
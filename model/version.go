@@ -0,0 +1,72 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// CodePreconditionFailed is reported when a write targets a stale version.
+const CodePreconditionFailed = "precondition_failed"
+
+// ErrPreconditionFailed is matched by errors.Is against *PreconditionFailedError.
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// PreconditionFailedError reports that a row changed since the caller read it.
+type PreconditionFailedError struct {
+	Resource string
+	ID       string
+	Current  int
+}
+
+func (e *PreconditionFailedError) Error() string {
+	return fmt.Sprintf("%s %s was modified, current version is %d", e.Resource, e.ID, e.Current)
+}
+
+func (e *PreconditionFailedError) Is(target error) bool { return target == ErrPreconditionFailed }
+
+// TouchPortfolio bumps the portfolio version when it still matches expected,
+// for changes that only touch its relations.
+func TouchPortfolio(ctx context.Context, db Querier, portfolioID string, expected int) (_ int, err error) {
+	ctx, end := begin(ctx, "TouchPortfolio")
+	defer func() { end(err) }()
+
+	return bumpVersion(ctx, db, "portfolio", "portfolio", portfolioID, expected)
+}
+
+// TouchExperience bumps the experience version when it still matches expected,
+// for changes that only touch its relations.
+func TouchExperience(ctx context.Context, db Querier, experienceID string, expected int) (_ int, err error) {
+	ctx, end := begin(ctx, "TouchExperience")
+	defer func() { end(err) }()
+
+	return bumpVersion(ctx, db, "experiance", "experience", experienceID, expected)
+}
+
+func bumpVersion(ctx context.Context, db Querier, table, resource, id string, expected int) (int, error) {
+	query := `UPDATE ` + table + ` SET version = version + 1 WHERE id = $1 AND version = $2 RETURNING version`
+
+	var version int
+	err := db.QueryRowContext(ctx, query, id, expected).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, staleOrMissing(ctx, db, table, resource, id)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error bumping version", "resource", resource, "error", err)
+		return 0, err
+	}
+	return version, nil
+}
+
+// staleOrMissing explains why a versioned write matched no row: either the
+// row is gone or somebody else bumped its version first.
+func staleOrMissing(ctx context.Context, db Querier, table, resource, id string) error {
+	var current int
+	err := db.QueryRowContext(ctx, `SELECT version FROM `+table+` WHERE id = $1`, id).Scan(&current)
+	if err != nil {
+		return mapError(err, resource, id)
+	}
+	return &PreconditionFailedError{Resource: resource, ID: id, Current: current}
+}
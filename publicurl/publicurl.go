@@ -0,0 +1,163 @@
+// Package publicurl turns paths served by the API into the absolute URLs
+// clients should follow. Behind a reverse proxy or CDN the host and scheme
+// of the incoming request are not the public ones, so they come from
+// configuration or from forwarding headers set by a trusted proxy.
+package publicurl
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const contextKey = "publicurl"
+
+// Config decides how absolute URLs are built. The zero Config uses the
+// host and scheme of the request itself.
+type Config struct {
+	// BaseURL prefixes links to the API, such as upload locations.
+	BaseURL string
+	// AssetBaseURL prefixes links to uploaded files and image
+	// transformations, for instance a CDN. It falls back to BaseURL.
+	AssetBaseURL string
+	// TrustedProxies lists the networks whose X-Forwarded-Proto and
+	// X-Forwarded-Host headers are honored when BaseURL is not set.
+	TrustedProxies []netip.Prefix
+}
+
+// New checks the base URLs, which may be empty, and parses the trusted
+// proxies given as IP addresses or CIDR ranges.
+func New(base, asset string, trustedProxies []string) (*Config, error) {
+	cfg := &Config{}
+
+	var err error
+	if cfg.BaseURL, err = baseURL("public base URL", base); err != nil {
+		return nil, err
+	}
+	if cfg.AssetBaseURL, err = baseURL("asset base URL", asset); err != nil {
+		return nil, err
+	}
+
+	for _, entry := range trustedProxies {
+		prefix, err := parsePrefix(strings.TrimSpace(entry))
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy: %w", err)
+		}
+		cfg.TrustedProxies = append(cfg.TrustedProxies, prefix)
+	}
+	return cfg, nil
+}
+
+func baseURL(name, raw string) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("%s must be an absolute http or https URL, got %q", name, raw)
+	}
+	return strings.TrimSuffix(raw, "/"), nil
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		return netip.ParsePrefix(s)
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Middleware makes cfg available to API and Asset for every request.
+func (cfg *Config) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(contextKey, cfg)
+		c.Next()
+	}
+}
+
+// API returns the public URL of path on the API.
+func API(c *gin.Context, path string) string {
+	return configFor(c).api(c.Request) + path
+}
+
+// Asset returns the public URL of an uploaded file or image
+// transformation served by the API under path.
+func Asset(c *gin.Context, path string) string {
+	cfg := configFor(c)
+	if cfg.AssetBaseURL != "" {
+		return cfg.AssetBaseURL + path
+	}
+	return cfg.api(c.Request) + path
+}
+
+func configFor(c *gin.Context) *Config {
+	if cfg, ok := c.Get(contextKey); ok {
+		return cfg.(*Config)
+	}
+	return &Config{}
+}
+
+// api returns scheme and host of the API as seen by the client
+func (cfg *Config) api(r *http.Request) string {
+	if cfg.BaseURL != "" {
+		return cfg.BaseURL
+	}
+
+	scheme, host := "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if cfg.trusted(r) {
+		if proto := lastValue(r.Header.Values("X-Forwarded-Proto")); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if fwdHost := lastValue(r.Header.Values("X-Forwarded-Host")); fwdHost != "" {
+			host = fwdHost
+		}
+	}
+	return scheme + "://" + host
+}
+
+// trusted reports whether r comes straight from one of the trusted proxies
+func (cfg *Config) trusted(r *http.Request) bool {
+	if len(cfg.TrustedProxies) == 0 {
+		return false
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range cfg.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// lastValue returns the value the trusted proxy in front of the API added.
+// Proxies append to the header, so earlier values come from hops further
+// away, including the client itself, and can be forged.
+func lastValue(headers []string) string {
+	if len(headers) == 0 {
+		return ""
+	}
+	last := headers[len(headers)-1]
+	if i := strings.LastIndex(last, ","); i >= 0 {
+		last = last[i+1:]
+	}
+	return strings.TrimSpace(last)
+}
@@ -0,0 +1,189 @@
+package publicurl
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name           string
+		base, asset    string
+		trustedProxies []string
+		wantErr        bool
+	}{
+		{name: "empty"},
+		{name: "base URLs", base: "https://api.example.com/", asset: "https://cdn.example.com"},
+		{name: "proxies", trustedProxies: []string{"10.0.0.0/8", " 192.168.1.1 ", "::1"}},
+		{name: "relative base", base: "/api", wantErr: true},
+		{name: "base without host", base: "https://", wantErr: true},
+		{name: "ftp asset", asset: "ftp://files.example.com", wantErr: true},
+		{name: "bad proxy", trustedProxies: []string{"10.0.0.0/33"}, wantErr: true},
+		{name: "proxy hostname", trustedProxies: []string{"proxy.local"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := New(tt.base, tt.asset, tt.trustedProxies)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && strings.HasSuffix(cfg.BaseURL, "/") {
+				t.Fatalf("BaseURL = %q keeps its trailing slash", cfg.BaseURL)
+			}
+			if err == nil && len(cfg.TrustedProxies) != len(tt.trustedProxies) {
+				t.Fatalf("parsed %d trusted proxies, want %d", len(cfg.TrustedProxies), len(tt.trustedProxies))
+			}
+		})
+	}
+}
+
+func TestAPI(t *testing.T) {
+	proxied, err := New("", "", []string{"10.0.0.0/8", "fd00::/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixed, err := New("https://api.example.com", "", []string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		cfg        *Config
+		remoteAddr string
+		tls        bool
+		header     http.Header
+		want       string
+	}{
+		{
+			name: "request host",
+			cfg:  &Config{}, remoteAddr: "203.0.113.9:1234",
+			want: "http://api.internal",
+		},
+		{
+			name: "request over tls",
+			cfg:  &Config{}, remoteAddr: "203.0.113.9:1234", tls: true,
+			want: "https://api.internal",
+		},
+		{
+			name: "headers without trusted proxies",
+			cfg:  &Config{}, remoteAddr: "10.0.0.1:1234",
+			header: http.Header{"X-Forwarded-Proto": {"https"}, "X-Forwarded-Host": {"evil.example"}},
+			want:   "http://api.internal",
+		},
+		{
+			name: "headers from an untrusted address",
+			cfg:  proxied, remoteAddr: "203.0.113.9:1234",
+			header: http.Header{"X-Forwarded-Proto": {"https"}, "X-Forwarded-Host": {"evil.example"}},
+			want:   "http://api.internal",
+		},
+		{
+			name: "headers from a trusted proxy",
+			cfg:  proxied, remoteAddr: "10.1.2.3:1234",
+			header: http.Header{"X-Forwarded-Proto": {"https"}, "X-Forwarded-Host": {"example.com"}},
+			want:   "https://example.com",
+		},
+		{
+			name: "trusted ipv6 proxy",
+			cfg:  proxied, remoteAddr: "[fd00::1]:1234",
+			header: http.Header{"X-Forwarded-Host": {"example.com"}},
+			want:   "http://example.com",
+		},
+		{
+			name: "trusted proxy as ipv4 mapped address",
+			cfg:  proxied, remoteAddr: "[::ffff:10.0.0.1]:1234",
+			header: http.Header{"X-Forwarded-Host": {"example.com"}},
+			want:   "http://example.com",
+		},
+		{
+			name: "values forged by the client come first",
+			cfg:  proxied, remoteAddr: "10.1.2.3:1234",
+			header: http.Header{"X-Forwarded-Proto": {"http, https"}, "X-Forwarded-Host": {"evil.example, example.com"}},
+			want:   "https://example.com",
+		},
+		{
+			name: "values on separate header lines",
+			cfg:  proxied, remoteAddr: "10.1.2.3:1234",
+			header: http.Header{"X-Forwarded-Host": {"evil.example", "example.com"}},
+			want:   "http://example.com",
+		},
+		{
+			name: "unknown scheme",
+			cfg:  proxied, remoteAddr: "10.1.2.3:1234",
+			header: http.Header{"X-Forwarded-Proto": {"gopher"}},
+			want:   "http://api.internal",
+		},
+		{
+			name: "empty last value",
+			cfg:  proxied, remoteAddr: "10.1.2.3:1234",
+			header: http.Header{"X-Forwarded-Host": {"example.com, "}},
+			want:   "http://api.internal",
+		},
+		{
+			name: "remote address without port",
+			cfg:  proxied, remoteAddr: "10.1.2.3",
+			header: http.Header{"X-Forwarded-Host": {"example.com"}},
+			want:   "http://example.com",
+		},
+		{
+			name: "base URL wins",
+			cfg:  fixed, remoteAddr: "10.1.2.3:1234",
+			header: http.Header{"X-Forwarded-Host": {"example.com"}},
+			want:   "https://api.example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "http://api.internal/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			for key, values := range tt.header {
+				r.Header[key] = values
+			}
+			if got := tt.cfg.api(r); got != tt.want {
+				t.Fatalf("api() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAsset(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cdn, err := New("https://api.example.com", "https://cdn.example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api, err := New("https://api.example.com", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cfg  *Config
+		want string
+	}{
+		{"asset base URL", cdn, "https://cdn.example.com/uploads/a.png"},
+		{"falls back to the base URL", api, "https://api.example.com/uploads/a.png"},
+		{"without the middleware", nil, "http://api.internal/uploads/a.png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", "http://api.internal/", nil)
+			if tt.cfg != nil {
+				tt.cfg.Middleware()(c)
+			}
+			if got := Asset(c, "/uploads/a.png"); got != tt.want {
+				t.Fatalf("Asset() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,1158 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"portfolio/model"
+	"sort"
+	"sync"
+	"time"
+)
+
+// NewMemory returns an empty repository kept in memory, for tests. It
+// enforces the keys, foreign keys and versions of the schema and reports
+// violations with the same errors as Postgres. Transactions are undone on
+// rollback but not isolated from each other, and Lock does not lock.
+func NewMemory() DB {
+	m := &memory{}
+	return &memDB{memQuerier{m: m}}
+}
+
+// memory holds the tables. Rows are copies, so callers never share them.
+type memory struct {
+	mu sync.Mutex
+
+	users       table[model.User]
+	skills      table[model.Skills]
+	portfolios  table[model.Portfolio]
+	experiences table[model.Experience]
+	media       table[model.PortfolioMedia]
+	blobs       table[blob]
+	uploads     table[model.Upload]
+	uploadParts table[uploadPart]
+
+	portfolioSkills     table[link]
+	portfolioExperience table[link]
+	experienceSkills    table[link]
+}
+
+type blob struct {
+	key     string
+	size    int64
+	refs    int
+	created time.Time
+}
+
+type uploadPart struct {
+	upload string
+	offset int64
+	key    string
+}
+
+func (p uploadPart) id() string { return fmt.Sprintf("%s\x00%020d", p.upload, p.offset) }
+
+// link is a row of a join table, from a to b
+type link struct{ a, b string }
+
+func (l link) key() string { return l.a + "\x00" + l.b }
+
+type memDB struct {
+	memQuerier
+}
+
+func (d *memDB) RunInTx(ctx context.Context, fn func(tx Tx) error) error {
+	tx := &memTx{}
+	tx.memQuerier = memQuerier{m: d.m, tx: tx}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		tx.rollback()
+		return err
+	}
+
+	for _, hook := range tx.onCommit {
+		hook()
+	}
+	return nil
+}
+
+type memTx struct {
+	memQuerier
+
+	undo       []func()
+	onCommit   []func()
+	onRollback []func()
+}
+
+func (t *memTx) OnCommit(fn func())   { t.onCommit = append(t.onCommit, fn) }
+func (t *memTx) OnRollback(fn func()) { t.onRollback = append(t.onRollback, fn) }
+
+func (t *memTx) rollback() {
+	t.m.mu.Lock()
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		t.undo[i]()
+	}
+	t.undo = nil
+	t.m.mu.Unlock()
+
+	for _, hook := range t.onRollback {
+		hook()
+	}
+}
+
+// memQuerier reaches the tables on their own, or within tx when it is set
+type memQuerier struct {
+	m  *memory
+	tx *memTx
+}
+
+func (q memQuerier) Users() Users             { return memUsers{q} }
+func (q memQuerier) Skills() Skills           { return memSkills{q} }
+func (q memQuerier) Portfolios() Portfolios   { return memPortfolios{q} }
+func (q memQuerier) Experiences() Experiences { return memExperiences{q} }
+func (q memQuerier) Blobs() Blobs             { return memBlobs{q} }
+func (q memQuerier) Uploads() Uploads         { return memUploads{q} }
+
+// lock gives the caller the tables, unless ctx is already done
+func (q memQuerier) lock(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	q.m.mu.Lock()
+	return nil
+}
+
+func (q memQuerier) unlock() { q.m.mu.Unlock() }
+
+// keep records how to undo the writes just made, for a rollback of the
+// transaction they belong to. Writes made on their own are final.
+func (q memQuerier) keep(undo ...func()) {
+	if q.tx != nil {
+		q.tx.undo = append(q.tx.undo, undo...)
+	}
+}
+
+// changes collects the undos of a call that writes several rows, so they
+// can be undone together when a later write fails
+type changes []func()
+
+func (c *changes) add(undo func()) { *c = append(*c, undo) }
+
+func (c changes) undo() {
+	for i := len(c) - 1; i >= 0; i-- {
+		c[i]()
+	}
+}
+
+type memUsers struct{ memQuerier }
+
+func (r memUsers) Insert(ctx context.Context, user model.User) error {
+	if errs := model.Validate(user); len(errs) > 0 {
+		return errs
+	}
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	if _, ok := r.m.users.get(user.ID); ok {
+		return alreadyExists("user")
+	}
+	r.keep(r.m.users.put(user.ID, storedUser(user)))
+	return nil
+}
+
+func (r memUsers) Update(ctx context.Context, user model.User) error {
+	if errs := model.Validate(user); len(errs) > 0 {
+		return errs
+	}
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	if _, ok := r.m.users.get(user.ID); ok {
+		r.keep(r.m.users.put(user.ID, storedUser(user)))
+	}
+	return nil
+}
+
+func (r memUsers) UpdateProfile(ctx context.Context, user model.User) error {
+	if errs := model.ValidateFields(user, "Name", "Email"); len(errs) > 0 {
+		return errs
+	}
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	current, ok := r.m.users.get(user.ID)
+	if !ok {
+		return &model.NotFoundError{Resource: "user", ID: user.ID}
+	}
+	current.Name, current.Email = user.Name, user.Email
+	r.keep(r.m.users.put(user.ID, current))
+	return nil
+}
+
+func (r memUsers) Get(ctx context.Context, id string) (*model.User, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	user, ok := r.m.users.get(id)
+	if !ok {
+		return nil, &model.NotFoundError{Resource: "user", ID: id}
+	}
+	user = storedUser(user)
+	user.Password = ""
+	return &user, nil
+}
+
+func (r memUsers) GetByEmail(ctx context.Context, email string) (*model.User, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	users := r.m.users.list(func(u model.User) bool { return u.Email == email })
+	if len(users) == 0 {
+		return nil, &model.NotFoundError{Resource: "user"}
+	}
+	user := users[0]
+	user.Token = nil
+	return &user, nil
+}
+
+func (r memUsers) Delete(ctx context.Context, id string) error {
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	r.keep(r.m.users.remove(id))
+	return nil
+}
+
+// storedUser copies user without anything computed for a response
+func storedUser(user model.User) model.User {
+	if user.Token != nil {
+		token := *user.Token
+		user.Token = &token
+	}
+	user.ImageSet = nil
+	return user
+}
+
+type memSkills struct{ memQuerier }
+
+func (r memSkills) Insert(ctx context.Context, skill model.Skills) error {
+	if errs := model.Validate(skill); len(errs) > 0 {
+		return errs
+	}
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	if _, ok := r.m.skills.get(skill.ID); ok {
+		return alreadyExists("skill")
+	}
+	skill.ImageSet, skill.Version = nil, 1
+	r.keep(r.m.skills.put(skill.ID, skill))
+	return nil
+}
+
+func (r memSkills) List(ctx context.Context, offset, limit int) ([]model.Skills, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	return page(r.m.skills.list(nil), offset, limit)
+}
+
+func (r memSkills) Get(ctx context.Context, id string) (*model.Skills, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	skill, ok := r.m.skills.get(id)
+	if !ok {
+		return nil, &model.NotFoundError{Resource: "skill", ID: id}
+	}
+	return &skill, nil
+}
+
+func (r memSkills) Update(ctx context.Context, skill *model.Skills) error {
+	if errs := model.Validate(skill); len(errs) > 0 {
+		return errs
+	}
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	current, ok := r.m.skills.get(skill.ID)
+	if !ok || current.Version != skill.Version {
+		return staleOrMissing("skill", skill.ID, current.Version, ok)
+	}
+	stored := *skill
+	stored.ImageSet, stored.Version = nil, current.Version+1
+	r.keep(r.m.skills.put(skill.ID, stored))
+	skill.Version = stored.Version
+	return nil
+}
+
+func (r memSkills) Delete(ctx context.Context, id string, version int) error {
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	current, ok := r.m.skills.get(id)
+	if !ok || current.Version != version {
+		return staleOrMissing("skill", id, current.Version, ok)
+	}
+	used := func(l link) bool { return l.b == id }
+	if len(r.m.portfolioSkills.list(used)) > 0 || len(r.m.experienceSkills.list(used)) > 0 {
+		return stillReferenced("skill")
+	}
+	r.keep(r.m.skills.remove(id))
+	return nil
+}
+
+type memPortfolios struct{ memQuerier }
+
+func (r memPortfolios) Insert(ctx context.Context, portfolio *model.Portfolio) error {
+	if errs := model.Validate(portfolio); len(errs) > 0 {
+		return errs
+	}
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	if _, ok := r.m.portfolios.get(portfolio.ID); ok {
+		return alreadyExists("portfolio")
+	}
+	stored := storedPortfolio(*portfolio)
+	stored.Version = 1
+	r.keep(r.m.portfolios.put(portfolio.ID, stored))
+	portfolio.Version = stored.Version
+	return nil
+}
+
+func (r memPortfolios) List(ctx context.Context, offset, limit int) ([]*model.Portfolio, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	rows, err := page(r.m.portfolios.list(nil), offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	var portfolios []*model.Portfolio
+	for i := range rows {
+		portfolios = append(portfolios, &rows[i])
+	}
+	return portfolios, nil
+}
+
+func (r memPortfolios) Get(ctx context.Context, id string) (*model.Portfolio, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	portfolio, ok := r.m.portfolios.get(id)
+	if !ok {
+		return nil, &model.NotFoundError{Resource: "portfolio", ID: id}
+	}
+	portfolio.Skills = r.skills(id)
+	portfolio.Experience = r.experience(id)
+	portfolio.Media = r.media(id)
+	return &portfolio, nil
+}
+
+func (r memPortfolios) Update(ctx context.Context, portfolio *model.Portfolio) error {
+	if errs := model.Validate(portfolio); len(errs) > 0 {
+		return errs
+	}
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	current, ok := r.m.portfolios.get(portfolio.ID)
+	if !ok || current.Version != portfolio.Version {
+		return staleOrMissing("portfolio", portfolio.ID, current.Version, ok)
+	}
+	stored := storedPortfolio(*portfolio)
+	stored.Version = current.Version + 1
+	r.keep(r.m.portfolios.put(portfolio.ID, stored))
+	portfolio.Version = stored.Version
+	return nil
+}
+
+func (r memPortfolios) Delete(ctx context.Context, id string, version int) error {
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	current, ok := r.m.portfolios.get(id)
+	if !ok || current.Version != version {
+		return staleOrMissing("portfolio", id, current.Version, ok)
+	}
+
+	var c changes
+	of := func(l link) bool { return l.a == id }
+	for _, l := range r.m.portfolioSkills.list(of) {
+		c.add(r.m.portfolioSkills.remove(l.key()))
+	}
+	for _, l := range r.m.portfolioExperience.list(of) {
+		c.add(r.m.portfolioExperience.remove(l.key()))
+	}
+	for _, m := range r.media(id) {
+		c.add(r.m.media.remove(m.ID))
+	}
+	c.add(r.m.portfolios.remove(id))
+	r.keep(c...)
+	return nil
+}
+
+func (r memPortfolios) Touch(ctx context.Context, id string, version int) (int, error) {
+	if err := r.lock(ctx); err != nil {
+		return 0, err
+	}
+	defer r.unlock()
+
+	current, ok := r.m.portfolios.get(id)
+	if !ok || current.Version != version {
+		return 0, staleOrMissing("portfolio", id, current.Version, ok)
+	}
+	current.Version++
+	r.keep(r.m.portfolios.put(id, current))
+	return current.Version, nil
+}
+
+func (r memPortfolios) AddSkills(ctx context.Context, id string, skillIDs []string) error {
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	var c changes
+	for _, skillID := range skillIDs {
+		_, portfolioOK := r.m.portfolios.get(id)
+		_, skillOK := r.m.skills.get(skillID)
+		l := link{id, skillID}
+		_, exists := r.m.portfolioSkills.get(l.key())
+		switch {
+		case !portfolioOK || !skillOK:
+			c.undo()
+			return stillReferenced("portfolio skill")
+		case exists:
+			c.undo()
+			return alreadyExists("portfolio skill")
+		}
+		c.add(r.m.portfolioSkills.put(l.key(), l))
+	}
+	r.keep(c...)
+	return nil
+}
+
+func (r memPortfolios) RemoveSkill(ctx context.Context, id, skillID string) error {
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	r.keep(r.m.portfolioSkills.remove(link{id, skillID}.key()))
+	return nil
+}
+
+func (r memPortfolios) Skills(ctx context.Context, id string) ([]model.Skills, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	return r.skills(id), nil
+}
+
+func (r memPortfolios) skills(id string) []model.Skills {
+	var skills []model.Skills
+	for _, l := range r.m.portfolioSkills.list(func(l link) bool { return l.a == id }) {
+		if skill, ok := r.m.skills.get(l.b); ok {
+			skills = append(skills, skill)
+		}
+	}
+	return skills
+}
+
+func (r memPortfolios) SetExperience(ctx context.Context, id, experienceID string) error {
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	var c changes
+	for _, l := range r.m.portfolioExperience.list(func(l link) bool { return l.a == id }) {
+		c.add(r.m.portfolioExperience.remove(l.key()))
+	}
+	if experienceID != "" {
+		_, portfolioOK := r.m.portfolios.get(id)
+		_, experienceOK := r.m.experiences.get(experienceID)
+		if !portfolioOK || !experienceOK {
+			c.undo()
+			return stillReferenced("portfolio experience")
+		}
+		l := link{id, experienceID}
+		c.add(r.m.portfolioExperience.put(l.key(), l))
+	}
+	r.keep(c...)
+	return nil
+}
+
+func (r memPortfolios) Experience(ctx context.Context, id string) (*model.Experience, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	return r.experience(id), nil
+}
+
+func (r memPortfolios) experience(id string) *model.Experience {
+	var experience model.Experience
+	for _, l := range r.m.portfolioExperience.list(func(l link) bool { return l.a == id }) {
+		experience, _ = r.m.experiences.get(l.b)
+	}
+	return &experience
+}
+
+func (r memPortfolios) AddMedia(ctx context.Context, media *model.PortfolioMedia) error {
+	if errs := model.Validate(media); len(errs) > 0 {
+		return errs
+	}
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	if _, ok := r.m.portfolios.get(media.PortfolioID); !ok {
+		return stillReferenced("portfolio media")
+	}
+	if _, ok := r.m.media.get(media.ID); ok {
+		return alreadyExists("portfolio media")
+	}
+	gallery := r.media(media.PortfolioID)
+	media.Position = 0
+	if len(gallery) > 0 {
+		media.Position = gallery[len(gallery)-1].Position + 1
+	}
+	stored := *media
+	stored.URL, stored.ImageSet = "", nil
+	r.keep(r.m.media.put(media.ID, stored))
+	return nil
+}
+
+func (r memPortfolios) Media(ctx context.Context, id string) ([]model.PortfolioMedia, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	return r.media(id), nil
+}
+
+func (r memPortfolios) media(id string) []model.PortfolioMedia {
+	media := r.m.media.list(func(m model.PortfolioMedia) bool { return m.PortfolioID == id })
+	sort.SliceStable(media, func(i, j int) bool {
+		if media[i].Position != media[j].Position {
+			return media[i].Position < media[j].Position
+		}
+		return media[i].ID < media[j].ID
+	})
+	if media == nil {
+		media = []model.PortfolioMedia{}
+	}
+	return media
+}
+
+func (r memPortfolios) GetMedia(ctx context.Context, id, mediaID string) (*model.PortfolioMedia, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	media, ok := r.m.media.get(mediaID)
+	if !ok || media.PortfolioID != id {
+		return nil, &model.NotFoundError{Resource: "portfolio media", ID: mediaID}
+	}
+	return &media, nil
+}
+
+func (r memPortfolios) DeleteMedia(ctx context.Context, media *model.PortfolioMedia) error {
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	var c changes
+	c.add(r.m.media.remove(media.ID))
+	for _, m := range r.media(media.PortfolioID) {
+		if m.Position > media.Position {
+			m.Position--
+			c.add(r.m.media.put(m.ID, m))
+		}
+	}
+	r.keep(c...)
+	return nil
+}
+
+func (r memPortfolios) ReorderMedia(ctx context.Context, id string, mediaIDs []string) error {
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	if err := model.CheckMediaOrder(r.media(id), mediaIDs); err != nil {
+		return err
+	}
+	var c changes
+	for position, mediaID := range mediaIDs {
+		m, _ := r.m.media.get(mediaID)
+		m.Position = position
+		c.add(r.m.media.put(mediaID, m))
+	}
+	r.keep(c...)
+	return nil
+}
+
+// storedPortfolio copies portfolio without its relations or anything
+// computed for a response
+func storedPortfolio(portfolio model.Portfolio) model.Portfolio {
+	portfolio.ImageSet = nil
+	portfolio.Skills = nil
+	portfolio.Experience = nil
+	portfolio.Media = nil
+	return portfolio
+}
+
+type memExperiences struct{ memQuerier }
+
+func (r memExperiences) Insert(ctx context.Context, experience *model.Experience) error {
+	if errs := model.Validate(experience); len(errs) > 0 {
+		return errs
+	}
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	if _, ok := r.m.experiences.get(experience.ID); ok {
+		return alreadyExists("experience")
+	}
+	stored := storedExperience(*experience)
+	stored.Version = 1
+	r.keep(r.m.experiences.put(experience.ID, stored))
+	experience.Version = stored.Version
+	return nil
+}
+
+func (r memExperiences) List(ctx context.Context, offset, limit int) ([]*model.Experience, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	rows, err := page(r.m.experiences.list(nil), offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	var experiences []*model.Experience
+	for i := range rows {
+		experiences = append(experiences, &rows[i])
+	}
+	return experiences, nil
+}
+
+func (r memExperiences) Get(ctx context.Context, id string) (*model.Experience, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	experience, ok := r.m.experiences.get(id)
+	if !ok {
+		return nil, &model.NotFoundError{Resource: "experience", ID: id}
+	}
+	return &experience, nil
+}
+
+func (r memExperiences) Update(ctx context.Context, experience *model.Experience) error {
+	if errs := model.Validate(experience); len(errs) > 0 {
+		return errs
+	}
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	current, ok := r.m.experiences.get(experience.ID)
+	if !ok || current.Version != experience.Version {
+		return staleOrMissing("experience", experience.ID, current.Version, ok)
+	}
+	stored := storedExperience(*experience)
+	stored.Version = current.Version + 1
+	r.keep(r.m.experiences.put(experience.ID, stored))
+	experience.Version = stored.Version
+	return nil
+}
+
+func (r memExperiences) Delete(ctx context.Context, id string, version int) error {
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	current, ok := r.m.experiences.get(id)
+	if !ok || current.Version != version {
+		return staleOrMissing("experience", id, current.Version, ok)
+	}
+	if len(r.m.portfolioExperience.list(func(l link) bool { return l.b == id })) > 0 {
+		return stillReferenced("experience")
+	}
+
+	var c changes
+	for _, l := range r.m.experienceSkills.list(func(l link) bool { return l.a == id }) {
+		c.add(r.m.experienceSkills.remove(l.key()))
+	}
+	c.add(r.m.experiences.remove(id))
+	r.keep(c...)
+	return nil
+}
+
+func (r memExperiences) Touch(ctx context.Context, id string, version int) (int, error) {
+	if err := r.lock(ctx); err != nil {
+		return 0, err
+	}
+	defer r.unlock()
+
+	current, ok := r.m.experiences.get(id)
+	if !ok || current.Version != version {
+		return 0, staleOrMissing("experience", id, current.Version, ok)
+	}
+	current.Version++
+	r.keep(r.m.experiences.put(id, current))
+	return current.Version, nil
+}
+
+func (r memExperiences) AddSkills(ctx context.Context, id string, skillIDs []string) error {
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	var c changes
+	for _, skillID := range skillIDs {
+		_, experienceOK := r.m.experiences.get(id)
+		_, skillOK := r.m.skills.get(skillID)
+		l := link{id, skillID}
+		_, exists := r.m.experienceSkills.get(l.key())
+		switch {
+		case !experienceOK || !skillOK:
+			c.undo()
+			return stillReferenced("experience skill")
+		case exists:
+			c.undo()
+			return alreadyExists("experience skill")
+		}
+		c.add(r.m.experienceSkills.put(l.key(), l))
+	}
+	r.keep(c...)
+	return nil
+}
+
+func (r memExperiences) RemoveSkill(ctx context.Context, id, skillID string) error {
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	r.keep(r.m.experienceSkills.remove(link{id, skillID}.key()))
+	return nil
+}
+
+func (r memExperiences) Skills(ctx context.Context, id string) ([]model.Skills, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	var skills []model.Skills
+	for _, l := range r.m.experienceSkills.list(func(l link) bool { return l.a == id }) {
+		if skill, ok := r.m.skills.get(l.b); ok {
+			skills = append(skills, skill)
+		}
+	}
+	return skills, nil
+}
+
+// storedExperience copies experience without its skills or anything
+// computed for a response
+func storedExperience(experience model.Experience) model.Experience {
+	experience.ImageSet = nil
+	experience.Skills = nil
+	return experience
+}
+
+type memBlobs struct{ memQuerier }
+
+func (r memBlobs) Ensure(ctx context.Context, key string, size int64) (bool, error) {
+	if err := r.lock(ctx); err != nil {
+		return false, err
+	}
+	defer r.unlock()
+
+	if _, ok := r.m.blobs.get(key); ok {
+		return false, nil
+	}
+	r.keep(r.m.blobs.put(key, blob{key: key, size: size, created: time.Now()}))
+	return true, nil
+}
+
+func (r memBlobs) Retain(ctx context.Context, key string) error {
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	b, ok := r.m.blobs.get(key)
+	if !ok {
+		return fmt.Errorf("blob %s was removed while it was being referenced", key)
+	}
+	b.refs++
+	r.keep(r.m.blobs.put(key, b))
+	return nil
+}
+
+func (r memBlobs) Release(ctx context.Context, key string) error {
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	if b, ok := r.m.blobs.get(key); ok && b.refs > 0 {
+		b.refs--
+		r.keep(r.m.blobs.put(key, b))
+	}
+	return nil
+}
+
+func (r memBlobs) Collect(ctx context.Context, key string, remove func() error) error {
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	if b, ok := r.m.blobs.get(key); !ok || b.refs > 0 {
+		return nil
+	}
+	undo := r.m.blobs.remove(key)
+	if err := remove(); err != nil {
+		undo()
+		return err
+	}
+	return nil
+}
+
+func (r memBlobs) Referenced(ctx context.Context) ([]string, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	var keys []string
+	image := func(dir, image string) {
+		if image != "" {
+			keys = append(keys, model.ImageKey(dir, image))
+		}
+	}
+	for _, p := range r.m.portfolios.list(nil) {
+		image("portfolio", p.Image)
+	}
+	for _, e := range r.m.experiences.list(nil) {
+		image("experience", e.Image)
+	}
+	for _, s := range r.m.skills.list(nil) {
+		image("skills", s.Image)
+	}
+	for _, u := range r.m.users.list(nil) {
+		image("users", u.Image)
+	}
+	for _, m := range r.m.media.list(nil) {
+		keys = append(keys, m.Key)
+	}
+	for _, b := range r.m.blobs.list(nil) {
+		keys = append(keys, b.key)
+	}
+	return keys, nil
+}
+
+func (r memBlobs) Unreferenced(ctx context.Context, before time.Time) ([]string, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	var keys []string
+	for _, b := range r.m.blobs.list(func(b blob) bool { return b.refs == 0 && b.created.Before(before) }) {
+		keys = append(keys, b.key)
+	}
+	return keys, nil
+}
+
+type memUploads struct{ memQuerier }
+
+func (r memUploads) Insert(ctx context.Context, upload *model.Upload) error {
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	if _, ok := r.m.uploads.get(upload.ID); ok {
+		return alreadyExists("upload")
+	}
+	upload.CreatedAt = time.Now()
+	r.keep(r.m.uploads.put(upload.ID, *upload))
+	return nil
+}
+
+func (r memUploads) Get(ctx context.Context, id, userID string) (*model.Upload, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	upload, ok := r.m.uploads.get(id)
+	if !ok || !upload.ExpiresAt.After(time.Now()) {
+		return nil, &model.NotFoundError{Resource: "upload", ID: id}
+	}
+	if upload.UserID != userID {
+		return nil, &model.ForbiddenError{Message: "upload belongs to another user"}
+	}
+	return &upload, nil
+}
+
+func (r memUploads) Lock(ctx context.Context, id, userID string) (*model.Upload, error) {
+	return r.Get(ctx, id, userID)
+}
+
+func (r memUploads) UpdateOffset(ctx context.Context, id string, offset int64) error {
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	if upload, ok := r.m.uploads.get(id); ok {
+		upload.Offset = offset
+		r.keep(r.m.uploads.put(id, upload))
+	}
+	return nil
+}
+
+func (r memUploads) AddPart(ctx context.Context, id string, offset int64, key string) error {
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	if _, ok := r.m.uploads.get(id); !ok {
+		return stillReferenced("upload part")
+	}
+	part := uploadPart{upload: id, offset: offset, key: key}
+	if _, ok := r.m.uploadParts.get(part.id()); ok {
+		return alreadyExists("upload part")
+	}
+	r.keep(r.m.uploadParts.put(part.id(), part))
+	return nil
+}
+
+func (r memUploads) Parts(ctx context.Context, id string) ([]string, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	parts := r.m.uploadParts.list(func(p uploadPart) bool { return p.upload == id })
+	sort.Slice(parts, func(i, j int) bool { return parts[i].offset < parts[j].offset })
+	var keys []string
+	for _, p := range parts {
+		keys = append(keys, p.key)
+	}
+	return keys, nil
+}
+
+func (r memUploads) Delete(ctx context.Context, id string) error {
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	r.remove(id)
+	return nil
+}
+
+// remove deletes the upload with id and, like the foreign key of
+// upload_parts, its parts
+func (r memUploads) remove(id string) {
+	r.keep(r.m.uploads.remove(id))
+	for _, p := range r.m.uploadParts.list(func(p uploadPart) bool { return p.upload == id }) {
+		r.keep(r.m.uploadParts.remove(p.id()))
+	}
+}
+
+func (r memUploads) Active(ctx context.Context) ([]string, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	now := time.Now()
+	var ids []string
+	for _, u := range r.m.uploads.list(func(u model.Upload) bool { return u.ExpiresAt.After(now) }) {
+		ids = append(ids, u.ID)
+	}
+	return ids, nil
+}
+
+func (r memUploads) DeleteExpired(ctx context.Context) (int64, error) {
+	if err := r.lock(ctx); err != nil {
+		return 0, err
+	}
+	defer r.unlock()
+
+	now := time.Now()
+	expired := r.m.uploads.list(func(u model.Upload) bool { return !u.ExpiresAt.After(now) })
+	for _, u := range expired {
+		r.remove(u.ID)
+	}
+	return int64(len(expired)), nil
+}
+
+// Errors for the constraint violations Postgres reports, worded as
+// model maps them
+func alreadyExists(resource string) error {
+	return &model.ConflictError{Resource: resource, Message: "already exists"}
+}
+
+func stillReferenced(resource string) error {
+	return &model.ConflictError{Resource: resource, Message: "references a missing or still referenced record"}
+}
+
+// staleOrMissing explains why a versioned write did not apply, given the
+// current version of the row if found
+func staleOrMissing(resource, id string, current int, found bool) error {
+	if !found {
+		return &model.NotFoundError{Resource: resource, ID: id}
+	}
+	return &model.PreconditionFailedError{Resource: resource, ID: id, Current: current}
+}
+
+// page returns rows[offset:offset+limit], refusing negative bounds as
+// Postgres does
+func page[T any](rows []T, offset, limit int) ([]T, error) {
+	if offset < 0 || limit < 0 {
+		return nil, errors.New("OFFSET and LIMIT must not be negative")
+	}
+	if offset >= len(rows) {
+		return nil, nil
+	}
+	rows = rows[offset:]
+	if limit < len(rows) {
+		rows = rows[:limit]
+	}
+	return rows, nil
+}
+
+// table holds rows by key and remembers the order they were inserted in,
+// which listing follows
+type table[T any] struct {
+	rows map[string]tableRow[T]
+	seq  int
+}
+
+type tableRow[T any] struct {
+	seq int
+	v   T
+}
+
+func (t *table[T]) get(key string) (T, bool) {
+	r, ok := t.rows[key]
+	return r.v, ok
+}
+
+// put inserts or replaces the row under key and returns how to undo it
+func (t *table[T]) put(key string, v T) func() {
+	if t.rows == nil {
+		t.rows = make(map[string]tableRow[T])
+	}
+	prev, existed := t.rows[key]
+	r := tableRow[T]{seq: prev.seq, v: v}
+	if !existed {
+		t.seq++
+		r.seq = t.seq
+	}
+	t.rows[key] = r
+
+	return func() {
+		if existed {
+			t.rows[key] = prev
+		} else {
+			delete(t.rows, key)
+		}
+	}
+}
+
+// remove deletes the row under key, if any, and returns how to undo it
+func (t *table[T]) remove(key string) func() {
+	prev, existed := t.rows[key]
+	delete(t.rows, key)
+
+	return func() {
+		if existed {
+			t.rows[key] = prev
+		}
+	}
+}
+
+// list returns the rows keep accepts, or all of them when keep is nil, in
+// insertion order
+func (t *table[T]) list(keep func(T) bool) []T {
+	rows := make([]tableRow[T], 0, len(t.rows))
+	for _, r := range t.rows {
+		if keep == nil || keep(r.v) {
+			rows = append(rows, r)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].seq < rows[j].seq })
+
+	var values []T
+	for _, r := range rows {
+		values = append(values, r.v)
+	}
+	return values
+}
@@ -0,0 +1,268 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"portfolio/model"
+	"time"
+)
+
+// NewPostgres returns the repository of the model functions on db.
+func NewPostgres(db *sql.DB) DB {
+	return &postgres{pgQuerier{db: db, q: db}}
+}
+
+type postgres struct {
+	pgQuerier
+}
+
+func (p *postgres) RunInTx(ctx context.Context, fn func(tx Tx) error) error {
+	return model.RunInTx(ctx, p.db, func(uow *model.UnitOfWork) error {
+		return fn(&pgTx{pgQuerier{db: p.db, q: uow.Tx}, uow})
+	})
+}
+
+type pgTx struct {
+	pgQuerier
+	uow *model.UnitOfWork
+}
+
+func (t *pgTx) OnCommit(fn func())   { t.uow.OnCommit(fn) }
+func (t *pgTx) OnRollback(fn func()) { t.uow.OnRollback(fn) }
+
+// pgQuerier runs the model functions on q, the pool itself or one of its
+// transactions
+type pgQuerier struct {
+	db *sql.DB
+	q  model.Querier
+}
+
+func (p pgQuerier) Users() Users             { return pgUsers{p.q} }
+func (p pgQuerier) Skills() Skills           { return pgSkills{p.q} }
+func (p pgQuerier) Portfolios() Portfolios   { return pgPortfolios{p.q} }
+func (p pgQuerier) Experiences() Experiences { return pgExperiences{p.q} }
+func (p pgQuerier) Blobs() Blobs             { return pgBlobs{p.db, p.q} }
+func (p pgQuerier) Uploads() Uploads         { return pgUploads{p.q} }
+
+type pgUsers struct{ q model.Querier }
+
+func (r pgUsers) Insert(ctx context.Context, user model.User) error {
+	return model.InsertUser(ctx, r.q, user)
+}
+
+func (r pgUsers) Update(ctx context.Context, user model.User) error {
+	return model.UpdateUser(ctx, r.q, user)
+}
+
+func (r pgUsers) UpdateProfile(ctx context.Context, user model.User) error {
+	return model.UpdateUserProfile(ctx, r.q, user)
+}
+
+func (r pgUsers) Get(ctx context.Context, id string) (*model.User, error) {
+	return model.GetUserID(ctx, r.q, id)
+}
+
+func (r pgUsers) GetByEmail(ctx context.Context, email string) (*model.User, error) {
+	return model.GetUserByEmail(ctx, r.q, email)
+}
+
+func (r pgUsers) Delete(ctx context.Context, id string) error {
+	return model.DeleteUser(ctx, r.q, id)
+}
+
+type pgSkills struct{ q model.Querier }
+
+func (r pgSkills) Insert(ctx context.Context, skill model.Skills) error {
+	return model.InsertSkills(ctx, r.q, skill)
+}
+
+func (r pgSkills) List(ctx context.Context, offset, limit int) ([]model.Skills, error) {
+	return model.GetListSkills(ctx, r.q, offset, limit)
+}
+
+func (r pgSkills) Get(ctx context.Context, id string) (*model.Skills, error) {
+	return model.GetSkillID(ctx, r.q, id)
+}
+
+func (r pgSkills) Update(ctx context.Context, skill *model.Skills) error {
+	return model.UpdateSkill(ctx, r.q, skill)
+}
+
+func (r pgSkills) Delete(ctx context.Context, id string, version int) error {
+	return model.DeleteSkill(ctx, r.q, id, version)
+}
+
+type pgPortfolios struct{ q model.Querier }
+
+func (r pgPortfolios) Insert(ctx context.Context, portfolio *model.Portfolio) error {
+	return model.InsertPortfolio(ctx, r.q, portfolio)
+}
+
+func (r pgPortfolios) List(ctx context.Context, offset, limit int) ([]*model.Portfolio, error) {
+	return model.GetPortfoliosPaginated(ctx, r.q, offset, limit)
+}
+
+func (r pgPortfolios) Get(ctx context.Context, id string) (*model.Portfolio, error) {
+	return model.GetPortfolioByID(ctx, r.q, id)
+}
+
+func (r pgPortfolios) Update(ctx context.Context, portfolio *model.Portfolio) error {
+	return model.UpdatePortfolio(ctx, r.q, portfolio)
+}
+
+func (r pgPortfolios) Delete(ctx context.Context, id string, version int) error {
+	return model.DeletePortfolioAndRelations(ctx, r.q, id, version)
+}
+
+func (r pgPortfolios) Touch(ctx context.Context, id string, version int) (int, error) {
+	return model.TouchPortfolio(ctx, r.q, id, version)
+}
+
+func (r pgPortfolios) AddSkills(ctx context.Context, id string, skillIDs []string) error {
+	return (&model.Portfolio{ID: id}).AddSkills(ctx, r.q, skillIDs)
+}
+
+func (r pgPortfolios) RemoveSkill(ctx context.Context, id, skillID string) error {
+	return model.DeleteSkillAndPortfolioRelations(ctx, r.q, skillID, id)
+}
+
+func (r pgPortfolios) Skills(ctx context.Context, id string) ([]model.Skills, error) {
+	return model.GetSkillsByPortfolioID(ctx, r.q, id)
+}
+
+func (r pgPortfolios) SetExperience(ctx context.Context, id, experienceID string) error {
+	portfolio := &model.Portfolio{ID: id}
+	if experienceID == "" {
+		return portfolio.RemoveExperience(ctx, r.q)
+	}
+	return portfolio.UpdateExperiencePortfolio(ctx, r.q, experienceID)
+}
+
+func (r pgPortfolios) Experience(ctx context.Context, id string) (*model.Experience, error) {
+	return model.GetExperienceByPortfolioID(ctx, r.q, id)
+}
+
+func (r pgPortfolios) AddMedia(ctx context.Context, media *model.PortfolioMedia) error {
+	return model.InsertPortfolioMedia(ctx, r.q, media)
+}
+
+func (r pgPortfolios) Media(ctx context.Context, id string) ([]model.PortfolioMedia, error) {
+	return model.GetPortfolioMedia(ctx, r.q, id)
+}
+
+func (r pgPortfolios) GetMedia(ctx context.Context, id, mediaID string) (*model.PortfolioMedia, error) {
+	return model.GetPortfolioMediaByID(ctx, r.q, id, mediaID)
+}
+
+func (r pgPortfolios) DeleteMedia(ctx context.Context, media *model.PortfolioMedia) error {
+	return model.DeletePortfolioMedia(ctx, r.q, media)
+}
+
+func (r pgPortfolios) ReorderMedia(ctx context.Context, id string, mediaIDs []string) error {
+	return model.ReorderPortfolioMedia(ctx, r.q, id, mediaIDs)
+}
+
+type pgExperiences struct{ q model.Querier }
+
+func (r pgExperiences) Insert(ctx context.Context, experience *model.Experience) error {
+	return model.InsertExperience(ctx, r.q, experience)
+}
+
+func (r pgExperiences) List(ctx context.Context, offset, limit int) ([]*model.Experience, error) {
+	return model.GetExperience(ctx, r.q, offset, limit)
+}
+
+func (r pgExperiences) Get(ctx context.Context, id string) (*model.Experience, error) {
+	return model.GetExperienceID(ctx, r.q, id)
+}
+
+func (r pgExperiences) Update(ctx context.Context, experience *model.Experience) error {
+	return model.UpdateExperience(ctx, r.q, experience)
+}
+
+func (r pgExperiences) Delete(ctx context.Context, id string, version int) error {
+	return model.DeleteExperienceAndRelations(ctx, r.q, id, version)
+}
+
+func (r pgExperiences) Touch(ctx context.Context, id string, version int) (int, error) {
+	return model.TouchExperience(ctx, r.q, id, version)
+}
+
+func (r pgExperiences) AddSkills(ctx context.Context, id string, skillIDs []string) error {
+	return (&model.Experience{ID: id}).AddSkills(ctx, r.q, skillIDs)
+}
+
+func (r pgExperiences) RemoveSkill(ctx context.Context, id, skillID string) error {
+	return model.DeleteSkillAndExperienceRelations(ctx, r.q, skillID, id)
+}
+
+func (r pgExperiences) Skills(ctx context.Context, id string) ([]model.Skills, error) {
+	return model.GetSkillByExperienceID(ctx, r.q, id)
+}
+
+type pgBlobs struct {
+	db *sql.DB
+	q  model.Querier
+}
+
+func (r pgBlobs) Ensure(ctx context.Context, key string, size int64) (bool, error) {
+	return model.EnsureBlob(ctx, r.q, key, size)
+}
+
+func (r pgBlobs) Retain(ctx context.Context, key string) error {
+	return model.RetainBlob(ctx, r.q, key)
+}
+
+func (r pgBlobs) Release(ctx context.Context, key string) error {
+	return model.ReleaseBlob(ctx, r.q, key)
+}
+
+func (r pgBlobs) Collect(ctx context.Context, key string, remove func() error) error {
+	return model.CollectBlob(ctx, r.db, key, remove)
+}
+
+func (r pgBlobs) Referenced(ctx context.Context) ([]string, error) {
+	return model.ReferencedImages(ctx, r.q)
+}
+
+func (r pgBlobs) Unreferenced(ctx context.Context, before time.Time) ([]string, error) {
+	return model.UnreferencedBlobs(ctx, r.q, before)
+}
+
+type pgUploads struct{ q model.Querier }
+
+func (r pgUploads) Insert(ctx context.Context, upload *model.Upload) error {
+	return model.InsertUpload(ctx, r.q, upload)
+}
+
+func (r pgUploads) Get(ctx context.Context, id, userID string) (*model.Upload, error) {
+	return model.GetUpload(ctx, r.q, id, userID)
+}
+
+func (r pgUploads) Lock(ctx context.Context, id, userID string) (*model.Upload, error) {
+	return model.LockUpload(ctx, r.q, id, userID)
+}
+
+func (r pgUploads) UpdateOffset(ctx context.Context, id string, offset int64) error {
+	return model.UpdateUploadOffset(ctx, r.q, id, offset)
+}
+
+func (r pgUploads) AddPart(ctx context.Context, id string, offset int64, key string) error {
+	return model.AddUploadPart(ctx, r.q, id, offset, key)
+}
+
+func (r pgUploads) Parts(ctx context.Context, id string) ([]string, error) {
+	return model.UploadParts(ctx, r.q, id)
+}
+
+func (r pgUploads) Delete(ctx context.Context, id string) error {
+	return model.DeleteUpload(ctx, r.q, id)
+}
+
+func (r pgUploads) Active(ctx context.Context) ([]string, error) {
+	return model.ActiveUploads(ctx, r.q)
+}
+
+func (r pgUploads) DeleteExpired(ctx context.Context) (int64, error) {
+	return model.DeleteExpiredUploads(ctx, r.q)
+}
@@ -0,0 +1,163 @@
+// Package repository puts the data the handlers read and write behind
+// interfaces, so they run on Postgres in production and on an in-memory
+// store in tests.
+package repository
+
+import (
+	"context"
+	"portfolio/model"
+	"time"
+)
+
+// DB is the entry point of a repository. Calls made through it run on
+// their own; RunInTx groups several of them.
+type DB interface {
+	Querier
+	// RunInTx runs fn inside one transaction. It commits when fn returns
+	// nil and rolls back otherwise, then runs the matching hooks
+	// registered by fn.
+	RunInTx(ctx context.Context, fn func(tx Tx) error) error
+}
+
+// Tx is one transaction of a DB.
+type Tx interface {
+	Querier
+	// OnCommit registers fn to run after the transaction commits.
+	OnCommit(fn func())
+	// OnRollback registers fn to run after the transaction is rolled back.
+	OnRollback(fn func())
+}
+
+// Querier is satisfied by both DB and Tx, so a step can run on its own or
+// as part of a transaction.
+type Querier interface {
+	Users() Users
+	Skills() Skills
+	Portfolios() Portfolios
+	Experiences() Experiences
+	Blobs() Blobs
+	Uploads() Uploads
+}
+
+// Users stores accounts. Get leaves out the password and GetByEmail the
+// token.
+type Users interface {
+	Insert(ctx context.Context, user model.User) error
+	// Update saves every field of user.
+	Update(ctx context.Context, user model.User) error
+	// UpdateProfile saves the name and email of user only.
+	UpdateProfile(ctx context.Context, user model.User) error
+	Get(ctx context.Context, id string) (*model.User, error)
+	GetByEmail(ctx context.Context, email string) (*model.User, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// Skills stores skills. Writes take the version the caller read and fail
+// with a PreconditionFailedError once it is stale.
+type Skills interface {
+	Insert(ctx context.Context, skill model.Skills) error
+	List(ctx context.Context, offset, limit int) ([]model.Skills, error)
+	Get(ctx context.Context, id string) (*model.Skills, error)
+	// Update saves skill at skill.Version, which is then replaced by the
+	// new version.
+	Update(ctx context.Context, skill *model.Skills) error
+	Delete(ctx context.Context, id string, version int) error
+}
+
+// Portfolios stores portfolios along with their skills, experience and
+// gallery. Versioned writes behave as for Skills.
+type Portfolios interface {
+	Insert(ctx context.Context, portfolio *model.Portfolio) error
+	// List returns a page of portfolios without their relations.
+	List(ctx context.Context, offset, limit int) ([]*model.Portfolio, error)
+	// Get returns a portfolio with its skills, experience and gallery.
+	Get(ctx context.Context, id string) (*model.Portfolio, error)
+	Update(ctx context.Context, portfolio *model.Portfolio) error
+	// Delete removes a portfolio, its relations and its gallery rows. The
+	// blobs of the gallery are the caller's to release.
+	Delete(ctx context.Context, id string, version int) error
+	// Touch bumps the version of a portfolio whose relations changed.
+	Touch(ctx context.Context, id string, version int) (int, error)
+
+	AddSkills(ctx context.Context, id string, skillIDs []string) error
+	RemoveSkill(ctx context.Context, id, skillID string) error
+	Skills(ctx context.Context, id string) ([]model.Skills, error)
+
+	// SetExperience links a portfolio to experienceID, replacing the
+	// experience it had. An empty experienceID unlinks it.
+	SetExperience(ctx context.Context, id, experienceID string) error
+	// Experience returns the experience of a portfolio, empty when it has
+	// none.
+	Experience(ctx context.Context, id string) (*model.Experience, error)
+
+	// AddMedia appends media to the end of its portfolio gallery.
+	AddMedia(ctx context.Context, media *model.PortfolioMedia) error
+	// Media returns the gallery of a portfolio in display order.
+	Media(ctx context.Context, id string) ([]model.PortfolioMedia, error)
+	GetMedia(ctx context.Context, id, mediaID string) (*model.PortfolioMedia, error)
+	// DeleteMedia removes media from its gallery and closes the gap in
+	// the order. The blob reference is the caller's to release.
+	DeleteMedia(ctx context.Context, media *model.PortfolioMedia) error
+	// ReorderMedia puts the gallery in the order of mediaIDs, which must
+	// list each of its media exactly once.
+	ReorderMedia(ctx context.Context, id string, mediaIDs []string) error
+}
+
+// Experiences stores experiences and their skills. Versioned writes
+// behave as for Skills.
+type Experiences interface {
+	Insert(ctx context.Context, experience *model.Experience) error
+	// List returns a page of experiences without their skills.
+	List(ctx context.Context, offset, limit int) ([]*model.Experience, error)
+	Get(ctx context.Context, id string) (*model.Experience, error)
+	Update(ctx context.Context, experience *model.Experience) error
+	// Delete removes an experience and its skill relations.
+	Delete(ctx context.Context, id string, version int) error
+	// Touch bumps the version of an experience whose skills changed.
+	Touch(ctx context.Context, id string, version int) (int, error)
+
+	AddSkills(ctx context.Context, id string, skillIDs []string) error
+	RemoveSkill(ctx context.Context, id, skillID string) error
+	Skills(ctx context.Context, id string) ([]model.Skills, error)
+}
+
+// Blobs counts the references to content-addressed uploads.
+type Blobs interface {
+	// Ensure records the blob under key, reporting whether the caller
+	// created it and so has to store its bytes.
+	Ensure(ctx context.Context, key string, size int64) (bool, error)
+	Retain(ctx context.Context, key string) error
+	Release(ctx context.Context, key string) error
+	// Collect removes the blob under key when nothing references it,
+	// calling remove to delete the stored bytes. It always runs on its
+	// own, even when reached through a Tx.
+	Collect(ctx context.Context, key string, remove func() error) error
+	// Referenced lists the storage key of every image a row points to,
+	// along with every blob that is recorded at all.
+	Referenced(ctx context.Context) ([]string, error)
+	// Unreferenced lists the blobs nothing has referenced since before.
+	Unreferenced(ctx context.Context, before time.Time) ([]string, error)
+}
+
+// Uploads stores resumable uploads. Get and Lock fail for uploads of
+// another user or that expired.
+type Uploads interface {
+	Insert(ctx context.Context, upload *model.Upload) error
+	Get(ctx context.Context, id, userID string) (*model.Upload, error)
+	// Lock is Get for a request that writes the upload, which a second
+	// request then cannot until the transaction ends.
+	Lock(ctx context.Context, id, userID string) (*model.Upload, error)
+	UpdateOffset(ctx context.Context, id string, offset int64) error
+	// AddPart records the part stored under key as the bytes from offset.
+	// Only one part is recorded per offset, a second one is a conflict.
+	AddPart(ctx context.Context, id string, offset int64, key string) error
+	// Parts lists the keys of the recorded parts in offset order.
+	Parts(ctx context.Context, id string) ([]string, error)
+	// Delete forgets the upload along with its recorded parts.
+	Delete(ctx context.Context, id string) error
+	// Active lists the ids of the uploads that have not expired.
+	Active(ctx context.Context) ([]string, error)
+	// DeleteExpired forgets the uploads that expired and returns how many
+	// there were. Their parts are left for the garbage collector.
+	DeleteExpired(ctx context.Context) (int64, error)
+}
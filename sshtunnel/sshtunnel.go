@@ -0,0 +1,338 @@
+// Package sshtunnel reaches a database that only listens on a private
+// network by dialing it through an SSH server. The SSH connection is
+// opened on demand and reopened when it drops.
+package sshtunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Config describes the SSH server to tunnel through.
+type Config struct {
+	// Addr is host:port of the SSH server, port 22 when omitted.
+	Addr string
+	User string
+	// KeyFile is tried before Password when both are set.
+	Password      string
+	KeyFile       string
+	KeyPassphrase string
+	// KnownHostsFile lists the host keys the server may present,
+	// ~/.ssh/known_hosts by default.
+	KnownHostsFile string
+	// Timeout bounds establishing the SSH connection, 10s by default.
+	Timeout time.Duration
+	// KeepAlive is how often a dead connection is checked for, 30s by
+	// default.
+	KeepAlive time.Duration
+}
+
+// withDefaults fills in port 22, ~/.ssh/known_hosts and the timeouts
+func (cfg Config) withDefaults() (Config, error) {
+	switch {
+	case cfg.Addr == "":
+		return cfg, errors.New("the SSH server address is required")
+	case cfg.User == "":
+		return cfg, errors.New("the SSH user is required")
+	case cfg.Password == "" && cfg.KeyFile == "":
+		return cfg, errors.New("an SSH key file or password is required")
+	}
+	if _, _, err := net.SplitHostPort(cfg.Addr); err != nil {
+		cfg.Addr = net.JoinHostPort(cfg.Addr, "22")
+	}
+	if cfg.KnownHostsFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return cfg, fmt.Errorf("no known hosts file is set and the home directory is unknown: %w", err)
+		}
+		cfg.KnownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.KeepAlive <= 0 {
+		cfg.KeepAlive = 30 * time.Second
+	}
+	return cfg, nil
+}
+
+// Tunnel dials addresses as seen from the SSH server.
+type Tunnel struct {
+	addr      string
+	config    *ssh.ClientConfig
+	keepAlive time.Duration
+
+	mu     sync.Mutex
+	client *ssh.Client
+	closed bool
+	// dialing is closed when the connection being established, if any,
+	// is ready or failed
+	dialing chan struct{}
+}
+
+// Open checks cfg and connects to the SSH server, so that a wrong key or
+// an unknown host fails at startup rather than on the first query.
+func Open(cfg Config) (*Tunnel, error) {
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	var auth []ssh.AuthMethod
+	if cfg.KeyFile != "" {
+		signer, err := loadKey(cfg.KeyFile, cfg.KeyPassphrase)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if cfg.Password != "" {
+		auth = append(auth, ssh.Password(cfg.Password))
+	}
+
+	hostKeys, err := knownhosts.New(cfg.KnownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading known hosts: %w", err)
+	}
+
+	t := &Tunnel{
+		addr: cfg.Addr,
+		config: &ssh.ClientConfig{
+			User:            cfg.User,
+			Auth:            auth,
+			HostKeyCallback: hostKeys,
+			Timeout:         cfg.Timeout,
+		},
+		keepAlive: cfg.KeepAlive,
+	}
+	if _, err := t.connect(context.Background()); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func loadKey(file, passphrase string) (ssh.Signer, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading SSH key: %w", err)
+	}
+	if passphrase != "" {
+		return ssh.ParsePrivateKeyWithPassphrase(pem, []byte(passphrase))
+	}
+	return ssh.ParsePrivateKey(pem)
+}
+
+// DialContext opens a connection to addr from the SSH server. It has the
+// signature of pgconn.DialFunc. A connection that fails because the SSH
+// session died is retried once on a new session; one the server refused
+// fails right away, leaving the connections tunnelled so far alone.
+func (t *Tunnel) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	client, err := t.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := client.DialContext(ctx, network, addr)
+	if err == nil || ctx.Err() != nil {
+		return conn, err
+	}
+	// The server answered, it just could not reach addr
+	var refused *ssh.OpenChannelError
+	if errors.As(err, &refused) {
+		return nil, err
+	}
+	// The session may be gone without the keepalive having noticed yet
+	pingCtx, cancel := context.WithTimeout(ctx, t.config.Timeout)
+	alive := ping(pingCtx, client) == nil
+	cancel()
+	if alive {
+		return nil, err
+	}
+
+	slog.WarnContext(ctx, "sshtunnel: dial failed, reconnecting", "addr", addr, "error", err)
+	t.drop(client)
+	if client, err = t.connect(ctx); err != nil {
+		return nil, err
+	}
+	return client.DialContext(ctx, network, addr)
+}
+
+// Close shuts the SSH connection down for good.
+func (t *Tunnel) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closed = true
+	if t.client == nil {
+		return nil
+	}
+	err := t.client.Close()
+	t.client = nil
+	return err
+}
+
+// connect returns the current SSH client, establishing one if needed. The
+// mutex is not held while dialing, so callers waiting for the connection
+// give up when their own ctx is done and Close is not held up.
+func (t *Tunnel) connect(ctx context.Context) (*ssh.Client, error) {
+	for {
+		t.mu.Lock()
+		if t.closed {
+			t.mu.Unlock()
+			return nil, errors.New("sshtunnel: tunnel is closed")
+		}
+		if t.client != nil {
+			client := t.client
+			t.mu.Unlock()
+			return client, nil
+		}
+		if dialing := t.dialing; dialing != nil {
+			t.mu.Unlock()
+			select {
+			case <-dialing:
+				// Connected, or failed and the next caller dials again
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		dialing := make(chan struct{})
+		t.dialing = dialing
+		t.mu.Unlock()
+
+		client, err := t.dial(ctx)
+
+		t.mu.Lock()
+		t.dialing = nil
+		close(dialing)
+		if err == nil && t.closed {
+			client.Close()
+			err = errors.New("sshtunnel: tunnel is closed")
+		}
+		if err != nil {
+			t.mu.Unlock()
+			return nil, err
+		}
+		t.client = client
+		t.mu.Unlock()
+
+		slog.InfoContext(ctx, "sshtunnel: connected", "server", t.addr)
+		go t.watch(client)
+		return client, nil
+	}
+}
+
+// dial connects to the SSH server and authenticates, giving up at the
+// configured timeout or when ctx is done
+func (t *Tunnel) dial(ctx context.Context) (*ssh.Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.config.Timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", t.addr)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to SSH server %s: %w", t.addr, err)
+	}
+
+	// The handshake knows no context, so cut the connection short instead
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	c, chans, reqs, err := ssh.NewClientConn(conn, t.addr, t.config)
+	if !stop() && err == nil {
+		c.Close()
+		err = ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("connecting to SSH server %s: %w", t.addr, err)
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// watch forgets client once its connection ends, probing it with
+// keepalive requests so a silently dropped link is noticed too
+func (t *Tunnel) watch(client *ssh.Client) {
+	done := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(done)
+	}()
+
+	var tick <-chan time.Time
+	if t.keepAlive > 0 {
+		ticker := time.NewTicker(t.keepAlive)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-done:
+			t.drop(client)
+			return
+		case <-tick:
+			ctx, cancel := context.WithTimeout(context.Background(), t.keepAlive)
+			err := ping(ctx, client)
+			cancel()
+			if err != nil {
+				slog.Warn("sshtunnel: connection lost", "server", t.addr, "error", err)
+				t.drop(client)
+				return
+			}
+		}
+	}
+}
+
+// Ping checks that the SSH server answers, connecting first if the
+// connection was dropped.
+func (t *Tunnel) Ping(ctx context.Context) error {
+	client, err := t.connect(ctx)
+	if err != nil {
+		return err
+	}
+	if err := ping(ctx, client); err != nil {
+		t.drop(client)
+		return err
+	}
+	return nil
+}
+
+// ping sends a keepalive request, failing when the server does not answer
+// before ctx is done
+func ping(ctx context.Context, client *ssh.Client) error {
+	reply := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		reply <- err
+	}()
+
+	select {
+	case err := <-reply:
+		return err
+	case <-ctx.Done():
+		return errors.New("keepalive timed out")
+	}
+}
+
+// drop closes client and forgets it, unless it was already replaced
+func (t *Tunnel) drop(client *ssh.Client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.client == client {
+		t.client = nil
+	}
+	client.Close()
+}
@@ -0,0 +1,462 @@
+package sshtunnel
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testServer is an SSH server accepting one password and one key, which
+// forwards direct-tcpip channels like sshd does
+type testServer struct {
+	addr       string
+	knownHosts string
+
+	mu       sync.Mutex
+	attempts []string
+	conns    int
+}
+
+func newKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// writeKey stores key as an OpenSSH private key file
+func writeKey(t *testing.T, key ed25519.PrivateKey, passphrase string) string {
+	t.Helper()
+	var block *pem.Block
+	var err error
+	if passphrase != "" {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte(passphrase))
+	} else {
+		block, err = ssh.MarshalPrivateKey(key, "")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(file, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func startServer(t *testing.T, password string, authorized ed25519.PrivateKey) *testServer {
+	t.Helper()
+	hostKey, err := ssh.NewSignerFromKey(newKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorizedKey, err := ssh.NewPublicKey(authorized.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testServer{}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(_ ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			s.attempt("password")
+			if string(pass) != password {
+				return nil, errors.New("wrong password")
+			}
+			return nil, nil
+		},
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			s.attempt("publickey")
+			if !bytes.Equal(key.Marshal(), authorizedKey.Marshal()) {
+				return nil, errors.New("unknown key")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				s.mu.Lock()
+				s.conns++
+				s.mu.Unlock()
+				go ssh.DiscardRequests(reqs)
+				for ch := range chans {
+					go forward(ch)
+				}
+			}()
+		}
+	}()
+
+	s.addr = l.Addr().String()
+	s.knownHosts = filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(s.addr)}, hostKey.PublicKey())
+	if err := os.WriteFile(s.knownHosts, []byte(line+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// forward connects a direct-tcpip channel to its target, refusing it when
+// the target cannot be reached
+func forward(ch ssh.NewChannel) {
+	if ch.ChannelType() != "direct-tcpip" {
+		ch.Reject(ssh.UnknownChannelType, "only direct-tcpip")
+		return
+	}
+	var target struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(ch.ExtraData(), &target); err != nil {
+		ch.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+	if err != nil {
+		ch.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, reqs, err := ch.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	go func() {
+		io.Copy(channel, conn)
+		channel.CloseWrite()
+	}()
+	io.Copy(conn, channel)
+	conn.Close()
+}
+
+// connections returns how many SSH connections were established
+func (s *testServer) connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns
+}
+
+// attempt records an authentication method tried, once per run of tries
+func (s *testServer) attempt(method string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n := len(s.attempts); n == 0 || s.attempts[n-1] != method {
+		s.attempts = append(s.attempts, method)
+	}
+}
+
+func (s *testServer) tried() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return strings.Join(s.attempts, ",")
+}
+
+func TestWithDefaults(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		want    Config
+		wantErr string
+	}{
+		{
+			name: "defaults",
+			cfg:  Config{Addr: "bastion", User: "deploy", Password: "secret", KnownHostsFile: "hosts"},
+			want: Config{Addr: "bastion:22", User: "deploy", Password: "secret", KnownHostsFile: "hosts", Timeout: 10 * time.Second, KeepAlive: 30 * time.Second},
+		},
+		{
+			name: "explicit",
+			cfg:  Config{Addr: "[::1]:2222", User: "deploy", KeyFile: "key", KnownHostsFile: "hosts", Timeout: time.Second, KeepAlive: time.Minute},
+			want: Config{Addr: "[::1]:2222", User: "deploy", KeyFile: "key", KnownHostsFile: "hosts", Timeout: time.Second, KeepAlive: time.Minute},
+		},
+		{name: "no address", cfg: Config{User: "deploy", Password: "secret"}, wantErr: "address is required"},
+		{name: "no user", cfg: Config{Addr: "bastion", Password: "secret"}, wantErr: "user is required"},
+		{name: "no credentials", cfg: Config{Addr: "bastion", User: "deploy"}, wantErr: "key file or password is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.cfg.withDefaults()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("withDefaults() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("withDefaults() error = %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("withDefaults() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenAuth(t *testing.T) {
+	authorized := newKey(t)
+	authorizedFile := writeKey(t, authorized, "")
+	protectedFile := writeKey(t, authorized, "passphrase")
+	otherFile := writeKey(t, newKey(t), "")
+
+	tests := []struct {
+		name          string
+		password      string
+		keyFile       string
+		keyPassphrase string
+		tried         string
+		wantErr       bool
+	}{
+		{name: "key", keyFile: authorizedFile, tried: "publickey"},
+		{name: "key with passphrase", keyFile: protectedFile, keyPassphrase: "passphrase", tried: "publickey"},
+		{name: "password", password: "secret", tried: "password"},
+		{name: "key before password", keyFile: authorizedFile, password: "secret", tried: "publickey"},
+		{name: "password after a rejected key", keyFile: otherFile, password: "secret", tried: "publickey,password"},
+		{name: "wrong password", password: "guess", tried: "password", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := startServer(t, "secret", authorized)
+			tunnel, err := Open(Config{
+				Addr:           s.addr,
+				User:           "deploy",
+				Password:       tt.password,
+				KeyFile:        tt.keyFile,
+				KeyPassphrase:  tt.keyPassphrase,
+				KnownHostsFile: s.knownHosts,
+				Timeout:        5 * time.Second,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Open() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				defer tunnel.Close()
+			}
+			if got := s.tried(); got != tt.tried {
+				t.Fatalf("server saw %q, want %q", got, tt.tried)
+			}
+		})
+	}
+}
+
+func TestOpenUnknownHost(t *testing.T) {
+	s := startServer(t, "secret", newKey(t))
+	other := startServer(t, "secret", newKey(t))
+
+	// The known hosts file of another server does not list this one's key
+	_, err := Open(Config{Addr: s.addr, User: "deploy", Password: "secret", KnownHostsFile: other.knownHosts, Timeout: 5 * time.Second})
+	if err == nil {
+		t.Fatal("Open() succeeded against an unknown host key")
+	}
+}
+
+func TestPingAndClose(t *testing.T) {
+	s := startServer(t, "secret", newKey(t))
+	tunnel, err := Open(Config{Addr: s.addr, User: "deploy", Password: "secret", KnownHostsFile: s.knownHosts, Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tunnel.Ping(ctx); err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+
+	// A dropped connection is reopened on demand
+	tunnel.mu.Lock()
+	client := tunnel.client
+	tunnel.mu.Unlock()
+	tunnel.drop(client)
+	if err := tunnel.Ping(ctx); err != nil {
+		t.Fatalf("Ping() after a drop error = %v", err)
+	}
+
+	if err := tunnel.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := tunnel.Ping(ctx); err == nil {
+		t.Fatal("Ping() on a closed tunnel succeeded")
+	}
+}
+
+// echoServer returns the address of a listener that echoes what it reads
+func echoServer(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// echo checks that conn is still tunnelled by a round trip
+func echo(t *testing.T, conn net.Conn, msg string) {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		t.Fatalf("writing through the tunnel: %v", err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != msg {
+		t.Fatalf("read %q, %v through the tunnel, want %q", buf, err, msg)
+	}
+}
+
+func TestDialRefused(t *testing.T) {
+	s := startServer(t, "secret", newKey(t))
+	tunnel, err := Open(Config{Addr: s.addr, User: "deploy", Password: "secret", KnownHostsFile: s.knownHosts, Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tunnel.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := tunnel.DialContext(ctx, "tcp", echoServer(t))
+	if err != nil {
+		t.Fatalf("DialContext() error = %v", err)
+	}
+	defer conn.Close()
+	echo(t, conn, "before")
+
+	// Nothing listens on a port that was just released
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.Addr().String()
+	l.Close()
+
+	var refused *ssh.OpenChannelError
+	if _, err := tunnel.DialContext(ctx, "tcp", closed); !errors.As(err, &refused) {
+		t.Fatalf("DialContext() to a closed port error = %v, want an OpenChannelError", err)
+	}
+
+	// The session and the connection through it survive the refusal
+	echo(t, conn, "after")
+	if n := s.connections(); n != 1 {
+		t.Fatalf("server saw %d SSH connections, want 1", n)
+	}
+}
+
+// TestConnectContext dials a server that never completes the handshake
+func TestConnectContext(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	tunnel := &Tunnel{
+		addr: l.Addr().String(),
+		config: &ssh.ClientConfig{
+			User:            "deploy",
+			Auth:            []ssh.AuthMethod{ssh.Password("secret")},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			Timeout:         time.Minute,
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := tunnel.connect(ctx)
+		first <- err
+	}()
+	for dialing := false; !dialing; {
+		time.Sleep(time.Millisecond)
+		tunnel.mu.Lock()
+		dialing = tunnel.dialing != nil
+		tunnel.mu.Unlock()
+	}
+
+	// A second caller waits for the dial in progress only as long as its
+	// own context allows
+	short, cancelShort := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelShort()
+	start := time.Now()
+	if _, err := tunnel.connect(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("waiting connect() error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("waiting connect() took %v", elapsed)
+	}
+
+	// Close does not wait for the dial either
+	closed := make(chan struct{})
+	go func() {
+		tunnel.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close() blocked behind the dial")
+	}
+
+	cancel()
+	select {
+	case err := <-first:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("dialing connect() error = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("dialing connect() ignored the cancelled context")
+	}
+}
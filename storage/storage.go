@@ -0,0 +1,63 @@
+// Package storage keeps uploaded files behind a small interface so the API
+// can run on a local disk or on any S3-compatible object store.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned by Get when no object exists under the key.
+var ErrNotFound = errors.New("storage: object not found")
+
+// Storage stores uploads under slash separated keys such as
+// "portfolio/<uuid>.png".
+type Storage interface {
+	// Put writes size bytes from r under key, replacing any existing object.
+	// A size of -1 reads r to its end.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the object stored under key.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Stat describes the object under key, or returns ErrNotFound.
+	Stat(ctx context.Context, key string) (Object, error)
+	// Delete removes the object under key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	// URL returns where clients can download key. It may be a path relative
+	// to the API host when the files are served by the API itself.
+	URL(key string) string
+	// Walk calls fn for every object whose key starts with prefix. Returning
+	// an error from fn stops the walk with that error.
+	Walk(ctx context.Context, prefix string, fn func(Object) error) error
+}
+
+// Object describes a stored object found by Walk.
+type Object struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Config selects and configures a driver.
+type Config struct {
+	// Driver is "local" or "s3".
+	Driver string
+	// LocalRoot is the directory of the local driver, served under
+	// /uploads.
+	LocalRoot string
+	S3        S3Config
+}
+
+// New builds the driver selected by cfg.Driver.
+func New(cfg Config) (Storage, error) {
+	switch cfg.Driver {
+	case "local":
+		return NewLocal(cfg.LocalRoot, "/uploads"), nil
+	case "s3":
+		return NewS3(cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.Driver)
+	}
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"portfolio/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Traced wraps s so every operation on it becomes a span of the trace in
+// its context.
+func Traced(s Storage) Storage {
+	return &traced{s}
+}
+
+// Unwrap returns the driver behind s when it was wrapped by Traced.
+func Unwrap(s Storage) Storage {
+	if t, ok := s.(*traced); ok {
+		return t.next
+	}
+	return s
+}
+
+type traced struct {
+	next Storage
+}
+
+func (t *traced) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (err error) {
+	ctx, span := tracing.Start(ctx, "storage.Put", attribute.String("storage.key", key), attribute.Int64("storage.size", size))
+	defer func() { tracing.End(span, err) }()
+	return t.next.Put(ctx, key, r, size, contentType)
+}
+
+func (t *traced) Get(ctx context.Context, key string) (_ io.ReadCloser, err error) {
+	ctx, span := tracing.Start(ctx, "storage.Get", attribute.String("storage.key", key))
+	// A missing object is an answer, not a failure
+	defer func() {
+		if errors.Is(err, ErrNotFound) {
+			tracing.End(span, nil)
+			return
+		}
+		tracing.End(span, err)
+	}()
+	return t.next.Get(ctx, key)
+}
+
+func (t *traced) Stat(ctx context.Context, key string) (_ Object, err error) {
+	ctx, span := tracing.Start(ctx, "storage.Stat", attribute.String("storage.key", key))
+	defer func() {
+		if errors.Is(err, ErrNotFound) {
+			tracing.End(span, nil)
+			return
+		}
+		tracing.End(span, err)
+	}()
+	return t.next.Stat(ctx, key)
+}
+
+func (t *traced) Delete(ctx context.Context, key string) (err error) {
+	ctx, span := tracing.Start(ctx, "storage.Delete", attribute.String("storage.key", key))
+	defer func() { tracing.End(span, err) }()
+	return t.next.Delete(ctx, key)
+}
+
+func (t *traced) URL(key string) string {
+	return t.next.URL(key)
+}
+
+func (t *traced) Walk(ctx context.Context, prefix string, fn func(Object) error) (err error) {
+	ctx, span := tracing.Start(ctx, "storage.Walk", attribute.String("storage.prefix", prefix))
+	defer func() { tracing.End(span, err) }()
+	return t.next.Walk(ctx, prefix, fn)
+}